@@ -0,0 +1,28 @@
+package ssoclient
+
+import (
+	"context"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/middleware"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+// UserID returns the ID of the signed-in user for a request that went through
+// Middleware.SetUserID. ctx is the handler's *gin.Context or the request's
+// context.Context, e.g. in code called from the handler. It returns
+// auth.ErrNoSessionUser if nobody is signed in.
+func UserID(ctx context.Context) (uint, error) {
+	return middleware.UserID(ctx)
+}
+
+// CurrentUser returns the signed-in user, loading it on first use. Every later
+// call for the same request returns the same user without another query.
+func CurrentUser(ctx context.Context) (*models.User, error) {
+	return middleware.CurrentUser(ctx)
+}
+
+// MustUser is CurrentUser for handlers behind RequireAuth. It panics if there
+// is no user.
+func MustUser(ctx context.Context) *models.User {
+	return middleware.MustUser(ctx)
+}
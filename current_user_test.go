@@ -0,0 +1,117 @@
+package ssoclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+func TestCurrentUserThroughMiddleware(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+	cfg.IsRedisSecure = false
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	db := newTestDB(t, &models.User{})
+	client.WithRepository(db, nil)
+
+	user := &models.User{Email: "user@example.com", Name: "User"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	signIn := httptest.NewRecorder()
+	if err := client.authService.SignInUser(signIn, httptest.NewRequest(http.MethodGet, "/callback", nil), user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := 0
+	if err := db.Callback().Query().After("gorm:query").Register("count_queries", func(*gorm.DB) { queries++ }); err != nil {
+		t.Fatal(err)
+	}
+
+	m := client.GetMiddleware()
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/", m.RequireAuth, m.SetUserID, func(c *gin.Context) {
+		if id, err := UserID(c); err != nil || id != user.ID {
+			t.Errorf("UserID() = %d, %v, want %d", id, err, user.ID)
+		}
+		for _, current := range []*models.User{MustUser(c), MustUser(c.Request.Context())} {
+			if current.Email != user.Email {
+				t.Errorf("current user = %+v, want %s", current, user.Email)
+			}
+		}
+		c.Status(http.StatusOK)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range signIn.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+	if queries != 1 {
+		t.Errorf("ran %d user queries, want 1", queries)
+	}
+}
+
+func TestCurrentUserBeforeWithRepository(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+	cfg.IsRedisSecure = false
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	// The middleware is taken before the repository is set.
+	m := client.GetMiddleware()
+	db := newTestDB(t, &models.User{})
+	client.WithRepository(db, nil)
+
+	user := &models.User{Email: "user@example.com", Name: "User"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	signIn := httptest.NewRecorder()
+	if err := client.authService.SignInUser(signIn, httptest.NewRequest(http.MethodGet, "/callback", nil), user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/", m.RequireAuth, m.SetUserID, func(c *gin.Context) {
+		current, err := CurrentUser(c)
+		if err != nil {
+			t.Fatalf("CurrentUser() error = %v", err)
+		}
+		if current.Email != user.Email {
+			t.Errorf("current user = %+v, want %s", current, user.Email)
+		}
+		c.Status(http.StatusOK)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range signIn.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+}
@@ -1,40 +1,119 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/gomodule/redigo/redis"
 
 	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/jwe"
+	"github.com/jarvisconsulting/sso-client-go/pkg/keys"
+	"github.com/jarvisconsulting/sso-client-go/pkg/metrics"
 	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+	"github.com/jarvisconsulting/sso-client-go/pkg/oauth"
+	"github.com/jarvisconsulting/sso-client-go/pkg/singleflight"
 	"github.com/jarvisconsulting/sso-client-go/pkg/store"
 )
 
 const (
-	SessionUserIDKey = "session_user_id" // Key used to store the user ID in the session
-	SessionIsMobileKey = "is_mobile"
+	SessionUserIDKey      = "session_user_id" // Key used to store the user ID in the session
+	SessionIsMobileKey    = "is_mobile"
+	SessionIDTokenKey     = "id_token_hint" // Signed ID token, sent back to the SSO server on sign-out
+	SessionLogoutStateKey = "logout_state"  // State expected when the SSO server returns after sign-out
+	SessionIssuerKey      = "sso_iss"       // iss claim of the ID token, checked by front-channel logout
+	SessionSIDKey         = "sso_sid"       // sid claim of the ID token, checked by front-channel logout
 )
 
+// CallbackResult is everything the callback learned about the signed-in user.
+type CallbackResult struct {
+	UserID  uint
+	UserKey string // encoded ID from a UserIDResolver; UserID is zero when set
+	IDToken string // the verified, signed ID token (decrypted if it arrived as a JWE)
+	Claims  jwt.MapClaims
+	Tokens  *Tokens // access and refresh tokens, if the SSO server sent any
+}
+
 type UserRepository interface {
-	FindByID(id uint) (*models.User, error)
-	FindByJTI(jti string) (uint, error)
-	GetLastSshKey() (*models.SshKey, error)
+	FindByIDContext(ctx context.Context, id uint) (*models.User, error)
+	FindByJTIContext(ctx context.Context, jti string) (uint, error)
+	ConsumeAccessTokenContext(ctx context.Context, jti string) error
+	UpsertUserContext(ctx context.Context, user *models.User, columns ...string) error
+	keys.Repository
 }
 
 type AuthService struct {
 	userRepo     UserRepository
 	config       *config.Config
 	sessionStore store.SessionStore
+	pool         *redis.Pool // nil if the session store has none
+	keyProvider  *keys.Provider
+	decrypter    *jwe.Decrypter
+	metrics      metrics.Recorder
+	tokenClient  *oauth.TokenClient
+	refreshGroup singleflight.Group
+
+	introspection  *introspectionVerifier
+	userInfo       *oauth.UserInfoClient
+	userIDResolver UserIDResolver
+	userStatus     UserStatusFunc
 }
 
-func NewAuthService(userRepo UserRepository, cfg *config.Config, sessionStore store.SessionStore) *AuthService {
-	return &AuthService{
+// NewAuthService returns an error if IDTokenDecryptionKey is set but cannot be
+// used.
+func NewAuthService(userRepo UserRepository, cfg *config.Config, sessionStore store.SessionStore) (*AuthService, error) {
+	keyProvider := keys.NewProvider(
+		keys.NewSource(cfg, userRepo),
+		time.Duration(cfg.SigningKeyRefreshInterval)*time.Second,
+		cfg.SigningKeyRetainCount,
+	)
+
+	var decrypter *jwe.Decrypter
+	if cfg.IDTokenDecryptionKey != "" {
+		var err error
+		decrypter, err = jwe.NewDecrypterFromPEM([]byte(cfg.IDTokenDecryptionKey))
+		if err != nil {
+			return nil, fmt.Errorf("error loading ID token decryption key: %w", err)
+		}
+	}
+
+	var tokenClient *oauth.TokenClient
+	if cfg.TokenURL != "" {
+		tokenClient = oauth.NewTokenClient(cfg.TokenURL, cfg.ClientID, cfg.ClientSecret)
+	}
+
+	s := &AuthService{
 		userRepo:     userRepo,
 		config:       cfg,
 		sessionStore: sessionStore,
+		pool:         store.PoolOf(sessionStore),
+		keyProvider:  keyProvider,
+		decrypter:    decrypter,
+		metrics:      metrics.Default(),
+		tokenClient:  tokenClient,
+	}
+	s.introspection = newIntrospectionVerifier(s)
+	if cfg.UserInfoURL != "" {
+		s.userInfo = oauth.NewUserInfoClient(cfg.UserInfoURL)
 	}
+
+	return s, nil
+}
+
+// SetMetricsRecorder replaces the recorder that receives the service's counters.
+func (s *AuthService) SetMetricsRecorder(recorder metrics.Recorder) {
+	s.metrics = recorder
+}
+
+// KeyProvider returns the cache of signing keys used to verify callback tokens.
+func (s *AuthService) KeyProvider() *keys.Provider {
+	return s.keyProvider
 }
 
 func (s *AuthService) IsUserSignedIn(r *http.Request) bool {
@@ -48,14 +127,110 @@ func (s *AuthService) IsUserSignedIn(r *http.Request) bool {
 }
 
 func (s *AuthService) SignInUser(w http.ResponseWriter, r *http.Request, userID uint, isMobile bool) error {
+	return s.SignInUserWithTokens(w, r, userID, isMobile, nil)
+}
+
+// SignInUserWithTokens is SignInUser for sign-ins that obtained OAuth tokens
+// themselves, e.g. through a back-channel code exchange. The tokens replace
+// any in the session, so AccessToken can return and refresh them; nil clears
+// them.
+func (s *AuthService) SignInUserWithTokens(w http.ResponseWriter, r *http.Request, userID uint, isMobile bool, tokens *Tokens) error {
 	session, err := s.sessionStore.GetStore().Get(r, s.config.SessionName)
 	if err != nil {
 		return err
 	}
 
+	userKey := strconv.FormatUint(uint64(userID), 10)
 	session.Values[SessionUserIDKey] = userID
 	session.Values[SessionIsMobileKey] = isMobile
-	return session.Save(r, w)
+	clearTokens(session)
+	if tokens != nil {
+		storeTokens(session, tokens)
+	}
+	if err := s.stampSessionEpoch(r.Context(), session, userKey); err != nil {
+		return err
+	}
+	if err := session.Save(r, w); err != nil {
+		return err
+	}
+
+	s.trackSession(r.Context(), userKey, session.ID)
+	s.forgetUserStatus(r.Context(), userKey)
+	return nil
+}
+
+// CompleteSignIn signs in the user from a processed callback and keeps the ID
+// token server-side so sign-out can pass it to the SSO server as a hint.
+func (s *AuthService) CompleteSignIn(w http.ResponseWriter, r *http.Request, result *CallbackResult, isMobile bool) error {
+	session, err := s.sessionStore.GetStore().Get(r, s.config.SessionName)
+	if err != nil {
+		return err
+	}
+
+	userKey := result.UserKey
+	if userKey != "" {
+		session.Values[SessionUserIDKey] = userKey
+	} else {
+		session.Values[SessionUserIDKey] = result.UserID
+		userKey = strconv.FormatUint(uint64(result.UserID), 10)
+	}
+	session.Values[SessionIsMobileKey] = isMobile
+	session.Values[SessionIDTokenKey] = result.IDToken
+	if iss, ok := result.Claims["iss"].(string); ok {
+		session.Values[SessionIssuerKey] = iss
+	}
+	if sid, ok := result.Claims["sid"].(string); ok {
+		session.Values[SessionSIDKey] = sid
+	}
+	clearTokens(session)
+	if result.Tokens != nil {
+		storeTokens(session, result.Tokens)
+	}
+	if err := s.stampSessionEpoch(r.Context(), session, userKey); err != nil {
+		return err
+	}
+	if err := session.Save(r, w); err != nil {
+		return err
+	}
+
+	s.trackSession(r.Context(), userKey, session.ID)
+	s.forgetUserStatus(r.Context(), userKey)
+	return nil
+}
+
+// trackSession indexes the session under its user for RevokeUserSessions. A
+// failure only means the session cannot be revoked early, so sign-in goes on.
+func (s *AuthService) trackSession(ctx context.Context, userKey, sessionID string) {
+	if err := store.TrackUserSession(ctx, s.pool, userKey, sessionID); err != nil {
+		log.Printf("Failed to track session for user %s: %v", userKey, err)
+	}
+}
+
+// RevokeUserSessions signs the user out everywhere by deleting all of their
+// sessions.
+func (s *AuthService) RevokeUserSessions(userID uint) error {
+	return s.RevokeUserSessionsContext(context.Background(), userID)
+}
+
+func (s *AuthService) RevokeUserSessionsContext(ctx context.Context, userID uint) error {
+	return s.RevokeSessionsByKeyContext(ctx, strconv.FormatUint(uint64(userID), 10))
+}
+
+// RevokeSessionsByKey is RevokeUserSessions for a user ID in its session
+// string form (see SessionUserKey).
+func (s *AuthService) RevokeSessionsByKey(userKey string) error {
+	return s.RevokeSessionsByKeyContext(context.Background(), userKey)
+}
+
+func (s *AuthService) RevokeSessionsByKeyContext(ctx context.Context, userKey string) error {
+	count, err := store.RevokeUserSessions(ctx, s.pool, userKey)
+	if err != nil {
+		return fmt.Errorf("error revoking sessions of user %s: %w", userKey, err)
+	}
+	s.forgetUserStatus(ctx, userKey)
+
+	log.Printf("Revoked %d sessions of user %s", count, userKey)
+	return nil
 }
 
 func (s *AuthService) SignOutUser(w http.ResponseWriter, r *http.Request) error {
@@ -65,74 +240,121 @@ func (s *AuthService) SignOutUser(w http.ResponseWriter, r *http.Request) error
 	}
 
 	delete(session.Values, SessionUserIDKey)
+	clearTokens(session)
 	return session.Save(r, w)
 }
 
-
 func (s *AuthService) HandleCallback(params map[string]string) (uint, error) {
+	return s.HandleCallbackContext(context.Background(), params)
+}
+
+func (s *AuthService) HandleCallbackContext(ctx context.Context, params map[string]string) (uint, error) {
+	result, err := s.ProcessCallbackContext(ctx, params)
+	if err != nil {
+		return 0, err
+	}
+	return result.UserID, nil
+}
+
+// ProcessCallback verifies the callback's ID token and resolves the user it
+// was issued for.
+func (s *AuthService) ProcessCallback(params map[string]string) (*CallbackResult, error) {
+	return s.ProcessCallbackContext(context.Background(), params)
+}
+
+// ProcessCallbackContext is ProcessCallback; ctx bounds the Redis and
+// database calls it makes.
+func (s *AuthService) ProcessCallbackContext(ctx context.Context, params map[string]string) (*CallbackResult, error) {
 	idToken, ok := params["id_token"]
 	if !ok || idToken == "" {
-		return 0, errors.New("id_token not provided")
+		return nil, errors.New("id_token not provided")
 	}
 
-	sshKey, err := s.userRepo.GetLastSshKey()
+	idToken, err := s.decryptIDToken(idToken)
 	if err != nil {
-		return 0, fmt.Errorf("error getting SSH key: %w", err)
+		return nil, err
 	}
 
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(sshKey.PrivateRsaKey))
+	token, err := s.parseIDToken(idToken)
 	if err != nil {
-		return 0, fmt.Errorf("error parsing private key: %w", err)
-	}
-
-	// Parse and validate the token
-	token, err := jwt.Parse(idToken, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return &privateKey.PublicKey, nil
-	})
-
-	// If token is invalid, return immediately
-	if err != nil || !token.Valid {
-		return 0, fmt.Errorf("invalid token: %w", err)
+		return nil, err
 	}
 
-	fmt.Printf("Token claims: %+v\n", token.Claims)
-
 	var jti string
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return 0, errors.New("invalid claims format")
+		return nil, errors.New("invalid claims format")
 	}
 
 	if jtiClaim, exists := claims["jti"]; exists {
 		if jtiStr, ok := jtiClaim.(string); ok {
 			jti = jtiStr
-			fmt.Printf("Found JTI in jti claim: %s\n", jti)
 		}
 	} else if len(claims) == 1 {
-		for k, v := range claims {
-			fmt.Printf("Checking claim key: %s, value: %v\n", k, v)
+		for _, v := range claims {
 			if strVal, ok := v.(string); ok {
 				jti = strVal
-				fmt.Printf("Found JTI as direct string value: %s\n", jti)
 				break
 			}
 		}
 	}
 
 	if jti == "" {
-		return 0, errors.New("could not extract JTI from token - token must contain either a jti claim or a single string value")
+		return nil, errors.New("could not extract JTI from token - token must contain either a jti claim or a single string value")
+	}
+
+	tokens := tokensFromParams(params)
+
+	if s.userIDResolver != nil {
+		userKey, err := s.userIDResolver(jti, claims)
+		if err != nil {
+			return nil, fmt.Errorf("error finding user by JTI: %w", err)
+		}
+		if userKey == "" {
+			return nil, errors.New("error finding user by JTI: resolver returned an empty user ID")
+		}
+		if err := s.checkSignIn(ctx, userKey); err != nil {
+			return nil, err
+		}
+
+		return &CallbackResult{
+			UserKey: userKey,
+			IDToken: idToken,
+			Claims:  claims,
+			Tokens:  tokens,
+		}, nil
 	}
 
-	userID, err := s.userRepo.FindByJTI(jti)
+	userID, err := s.userRepo.FindByJTIContext(ctx, jti)
 	if err != nil {
-		return 0, fmt.Errorf("error finding user by JTI: %w", err)
+		return nil, fmt.Errorf("error finding user by JTI: %w", err)
 	}
 
-	return userID, nil
+	// Only the callback that marks the JTI as consumed signs the user in, so
+	// a token replayed while the first callback runs is refused.
+	if err := s.userRepo.ConsumeAccessTokenContext(ctx, jti); err != nil {
+		return nil, fmt.Errorf("error consuming JTI: %w", err)
+	}
+
+	// A failed profile sync should not lock the user out; the row is
+	// refreshed again on their next sign-in.
+	if s.config.ProvisionUsers {
+		if err := s.provisionUser(ctx, userID, claims, tokens); err != nil {
+			log.Printf("Failed to provision user %d: %v", userID, err)
+		}
+	}
+
+	if err := s.checkSignIn(ctx, strconv.FormatUint(uint64(userID), 10)); err != nil {
+		return nil, err
+	}
+
+	return &CallbackResult{
+		UserID:  userID,
+		IDToken: idToken,
+		Claims:  claims,
+		Tokens:  tokens,
+	}, nil
 }
 
 func (s *AuthService) GetUserIDFromSession(r *http.Request) (uint, error) {
@@ -143,14 +365,18 @@ func (s *AuthService) GetUserIDFromSession(r *http.Request) (uint, error) {
 
 	userID, ok := session.Values[SessionUserIDKey].(uint)
 	if !ok {
-		return 0, errors.New("user ID not found in session")
+		return 0, ErrNoSessionUser
 	}
 
 	return userID, nil
 }
 
 func (s *AuthService) GetUserByID(id uint) (*models.User, error) {
-	return s.userRepo.FindByID(id)
+	return s.GetUserByIDContext(context.Background(), id)
+}
+
+func (s *AuthService) GetUserByIDContext(ctx context.Context, id uint) (*models.User, error) {
+	return s.userRepo.FindByIDContext(ctx, id)
 }
 
 func (s *AuthService) IsUserMobile(r *http.Request) (bool, error) {
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+)
+
+func TestNewAuthServiceRejectsInvalidDecryptionKey(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.IDTokenDecryptionKey = "not a PEM key"
+
+	if _, err := NewAuthService(newFakeRepo(), cfg, nil); err == nil {
+		t.Error("NewAuthService() accepted an invalid decryption key")
+	}
+}
+
+func TestDecryptIDTokenRequiresEncryption(t *testing.T) {
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.RequireEncryptedIDToken = true
+	})
+	token := sign(t, jwt.SigningMethodRS256, newRSAKey(t), "1", validClaims("a"))
+
+	if _, err := s.decryptIDToken(token); err == nil || !strings.Contains(err.Error(), "encrypted ID token required") {
+		t.Errorf("decryptIDToken() error = %v, want encrypted ID token required", err)
+	}
+}
+
+func TestDecryptIDTokenWithoutKey(t *testing.T) {
+	s := newTestService(t, nil)
+
+	if _, err := s.decryptIDToken("a.b.c.d.e"); err == nil {
+		t.Error("decryptIDToken() accepted an encrypted token without a decryption key")
+	}
+}
+
+func TestRedisCallsStopWithContext(t *testing.T) {
+	s := newTestService(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]func() error{
+		"RevokeUserSessionsContext":     func() error { return s.RevokeUserSessionsContext(ctx, 1) },
+		"BumpSessionEpochContext":       func() error { return s.BumpSessionEpochContext(ctx, 1) },
+		"BumpGlobalSessionEpochContext": func() error { return s.BumpGlobalSessionEpochContext(ctx) },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s() error = %v, want context.Canceled", name, err)
+		}
+	}
+}
+
+func TestProcessCallbackRejectsReplayedJTI(t *testing.T) {
+	s := newTestService(t, nil)
+	params := map[string]string{"id_token": s.signedInUser(t, "jti")}
+
+	if _, err := s.ProcessCallbackContext(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := s.ProcessCallbackContext(context.Background(), params); err == nil {
+		t.Errorf("ProcessCallbackContext() of a replayed token = %+v, want an error", result)
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type contextKey string
+
+const bearerClaimsContextKey contextKey = "bearer_claims"
+
+// ErrNoBearerAudience is returned for every locally verified bearer token
+// while BearerAudience is empty: without it any token the SSO server signed,
+// for any service, would be accepted.
+var ErrNoBearerAudience = errors.New("bearer_audience is not configured; bearer tokens are rejected")
+
+// Actor is the party acting on the subject's behalf, from the "act" claim of
+// a token obtained through token exchange (RFC 8693 section 4.1). Actor is set
+// when the token passed through more than one delegation.
+type Actor struct {
+	Subject  string
+	ClientID string
+	Actor    *Actor
+}
+
+// VerifyAccessToken validates a bearer token and returns its claims. By
+// default the token is verified locally as a JWT signed by the SSO server; in
+// introspection mode the SSO server is asked instead.
+func (s *AuthService) VerifyAccessToken(accessToken string) (jwt.MapClaims, error) {
+	return s.VerifyAccessTokenContext(context.Background(), accessToken)
+}
+
+// VerifyAccessTokenContext is VerifyAccessToken; in introspection mode the
+// caller stops waiting for the SSO server once ctx is done.
+func (s *AuthService) VerifyAccessTokenContext(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+	if s.config.BearerValidationMode == BearerValidationIntrospection {
+		return s.introspection.verify(ctx, accessToken)
+	}
+	return s.verifyJWTAccessToken(accessToken)
+}
+
+// verifyJWTAccessToken verifies a bearer token signed by the SSO server. The
+// token's aud must include BearerAudience, and ID tokens, which the same keys
+// sign, are refused.
+func (s *AuthService) verifyJWTAccessToken(accessToken string) (jwt.MapClaims, error) {
+	if s.config.BearerAudience == "" {
+		return nil, ErrNoBearerAudience
+	}
+
+	token, err := s.parseToken(accessToken, jwt.WithAudience(s.config.BearerAudience))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid claims format")
+	}
+	// The parser checks exp only when present; bearer tokens must expire.
+	if exp, err := claims.GetExpirationTime(); err != nil || exp == nil {
+		return nil, errors.New("invalid token: bearer token has no expiry")
+	}
+	if err := s.checkNotIDToken(token, claims); err != nil {
+		return nil, err
+	}
+	if _, err := ActorFromClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkNotIDToken refuses ID tokens presented as bearer tokens. An ID token is
+// addressed to this client (aud or azp is ClientID), carries a nonce, or has
+// a typ other than the JWT access token ones of RFC 9068.
+func (s *AuthService) checkNotIDToken(token *jwt.Token, claims jwt.MapClaims) error {
+	if typ, ok := token.Header["typ"].(string); ok {
+		switch strings.ToLower(typ) {
+		case "jwt", "at+jwt", "application/at+jwt":
+		default:
+			return fmt.Errorf("invalid token: typ %q is not an access token", typ)
+		}
+	}
+
+	if _, ok := claims["nonce"]; ok {
+		return errors.New("invalid token: ID tokens are not accepted as bearer tokens")
+	}
+	if s.config.ClientID != "" {
+		audience, _ := claims.GetAudience()
+		for _, aud := range audience {
+			if aud == s.config.ClientID {
+				return errors.New("invalid token: ID tokens are not accepted as bearer tokens")
+			}
+		}
+		if azp, _ := claims["azp"].(string); azp == s.config.ClientID {
+			return errors.New("invalid token: ID tokens are not accepted as bearer tokens")
+		}
+	}
+	return nil
+}
+
+// ActorFromClaims returns the actor chain in the token's act claim, or nil if
+// the token was not issued through delegation.
+func ActorFromClaims(claims jwt.MapClaims) (*Actor, error) {
+	act, ok := claims["act"]
+	if !ok {
+		return nil, nil
+	}
+	return parseActor(act, 0)
+}
+
+func parseActor(value any, depth int) (*Actor, error) {
+	// Delegation chains are short in practice; refuse absurdly deep ones.
+	if depth > 10 {
+		return nil, errors.New("invalid token: act claim is nested too deeply")
+	}
+
+	claim, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid token: act claim is not an object")
+	}
+
+	actor := &Actor{}
+	actor.Subject, _ = claim["sub"].(string)
+	actor.ClientID, _ = claim["client_id"].(string)
+	if actor.Subject == "" && actor.ClientID == "" {
+		return nil, errors.New("invalid token: act claim has no sub or client_id")
+	}
+
+	if nested, ok := claim["act"]; ok {
+		parent, err := parseActor(nested, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		actor.Actor = parent
+	}
+
+	return actor, nil
+}
+
+// WithBearerClaims stores verified bearer token claims in ctx.
+func WithBearerClaims(ctx context.Context, claims jwt.MapClaims) context.Context {
+	return context.WithValue(ctx, bearerClaimsContextKey, claims)
+}
+
+// BearerClaimsFromContext returns the claims stored by WithBearerClaims.
+func BearerClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(bearerClaimsContextKey).(jwt.MapClaims)
+	return claims, ok
+}
+
+// ActorFromContext returns the actor of the bearer token in ctx, or nil when
+// the caller is the subject itself.
+func ActorFromContext(ctx context.Context) *Actor {
+	claims, ok := BearerClaimsFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	actor, _ := ActorFromClaims(claims)
+	return actor
+}
+
+func (a *Actor) String() string {
+	if a == nil {
+		return ""
+	}
+
+	name := a.Subject
+	if name == "" {
+		name = a.ClientID
+	}
+	if a.Actor != nil {
+		return fmt.Sprintf("%s (via %s)", name, a.Actor)
+	}
+	return name
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+)
+
+func accessClaims(audience any) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":       "user",
+		"aud":       audience,
+		"client_id": "service-a",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func TestVerifyAccessTokenRequiresAudience(t *testing.T) {
+	s := newTestService(t, nil)
+	key := newRSAKey(t)
+	kid := s.repo.addKey(t, key, 0)
+
+	_, err := s.VerifyAccessToken(sign(t, jwt.SigningMethodRS256, key, kid, accessClaims("orders")))
+	if !errors.Is(err, ErrNoBearerAudience) {
+		t.Errorf("VerifyAccessToken() error = %v, want ErrNoBearerAudience", err)
+	}
+}
+
+func TestVerifyAccessToken(t *testing.T) {
+	withClaims := func(edit func(jwt.MapClaims)) jwt.MapClaims {
+		claims := accessClaims("orders")
+		edit(claims)
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		typ     string
+		claims  jwt.MapClaims
+		wantErr bool
+	}{
+		{"access token", "", accessClaims("orders"), false},
+		{"one of several audiences", "", accessClaims([]string{"billing", "orders"}), false},
+		{"RFC 9068 typ", "at+jwt", accessClaims("orders"), false},
+		{"media type typ", "application/at+jwt", accessClaims("orders"), false},
+		{"other audience", "", accessClaims("billing"), true},
+		{"no audience", "", withClaims(func(c jwt.MapClaims) { delete(c, "aud") }), true},
+		{"no expiry", "", withClaims(func(c jwt.MapClaims) { delete(c, "exp") }), true},
+		{"expired", "", withClaims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }), true},
+		{"ID token typ", "id_token+jwt", accessClaims("orders"), true},
+		{"ID token audience", "", accessClaims([]string{"orders", "web-app"}), true},
+		{"ID token azp", "", withClaims(func(c jwt.MapClaims) { c["azp"] = "web-app" }), true},
+		{"ID token nonce", "", withClaims(func(c jwt.MapClaims) { c["nonce"] = "n-0S6_WzA2Mj" }), true},
+		{"bad act claim", "", withClaims(func(c jwt.MapClaims) { c["act"] = "service-a" }), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, func(cfg *config.Config) {
+				cfg.ClientID = "web-app"
+				cfg.BearerAudience = "orders"
+			})
+			key := newRSAKey(t)
+			kid := s.repo.addKey(t, key, 0)
+
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, tt.claims)
+			token.Header["kid"] = kid
+			if tt.typ != "" {
+				token.Header["typ"] = tt.typ
+			}
+			signed, err := token.SignedString(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = s.VerifyAccessTokenContext(context.Background(), signed)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyAccessTokenContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestActorFromClaims(t *testing.T) {
+	claims := jwt.MapClaims{
+		"sub": "user",
+		"act": map[string]any{
+			"sub": "service-b",
+			"act": map[string]any{"client_id": "service-a"},
+		},
+	}
+
+	actor, err := ActorFromClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actor.Subject != "service-b" || actor.Actor == nil || actor.Actor.ClientID != "service-a" {
+		t.Errorf("ActorFromClaims() = %+v", actor)
+	}
+	if got, want := actor.String(), "service-b (via service-a)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	ctx := WithBearerClaims(context.Background(), claims)
+	if got := ActorFromContext(ctx); got == nil || got.Subject != "service-b" {
+		t.Errorf("ActorFromContext() = %+v", got)
+	}
+
+	if actor, err := ActorFromClaims(jwt.MapClaims{"sub": "user"}); actor != nil || err != nil {
+		t.Errorf("ActorFromClaims() without act = %+v, %v", actor, err)
+	}
+	if _, err := ActorFromClaims(jwt.MapClaims{"act": map[string]any{"iss": "x"}}); err == nil {
+		t.Error("ActorFromClaims() accepted an act claim without sub or client_id")
+	}
+
+	deep := map[string]any{"sub": "service"}
+	for i := 0; i < 20; i++ {
+		deep = map[string]any{"sub": "service", "act": deep}
+	}
+	if _, err := ActorFromClaims(jwt.MapClaims{"act": deep}); err == nil {
+		t.Error("ActorFromClaims() accepted an act chain nested 20 deep")
+	}
+}
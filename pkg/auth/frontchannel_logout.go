@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EndSessionFor deletes the session in the request if it was created from an
+// ID token with the given iss and sid. It reports whether a session was ended.
+// Both values are required: the session cookie is sent cross-site, so any page
+// could otherwise sign the user out by framing the logout URL.
+func (s *AuthService) EndSessionFor(w http.ResponseWriter, r *http.Request, iss, sid string) (bool, error) {
+	session, err := s.sessionStore.GetStore().Get(r, s.config.SessionName)
+	if err != nil {
+		return false, err
+	}
+	if session.IsNew {
+		return false, nil
+	}
+
+	savedIss, _ := session.Values[SessionIssuerKey].(string)
+	savedSID, _ := session.Values[SessionSIDKey].(string)
+	if savedIss == "" || savedSID == "" || !constantTimeEqual(savedIss, iss) || !constantTimeEqual(savedSID, sid) {
+		return false, nil
+	}
+
+	for key := range session.Values {
+		delete(session.Values, key)
+	}
+	session.Options.MaxAge = -1
+	return true, session.Save(r, w)
+}
+
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// FrontChannelLogout implements the RP side of OIDC Front-Channel Logout. The
+// SSO server loads it in a hidden iframe with iss and sid query parameters.
+// The response is the same whether or not a session matched.
+func (h *Handler) FrontChannelLogout(c *gin.Context) {
+	ended, err := h.authService.EndSessionFor(c.Writer, c.Request, c.Query("iss"), c.Query("sid"))
+	if err != nil {
+		log.Printf("Failed to process front-channel logout: %v", err)
+	} else if ended {
+		log.Printf("Ended session through front-channel logout")
+	}
+
+	c.Header("Cache-Control", "no-cache, no-store")
+	c.Header("Pragma", "no-cache")
+	c.Header("Content-Security-Policy", "default-src 'none'; frame-ancestors "+h.frameAncestor())
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte("<!DOCTYPE html><html><head><title>Signed out</title></head><body></body></html>"))
+}
+
+// frameAncestor returns the only origin allowed to frame the logout page: the
+// configured provider origin, or the origin of the sign-in URL.
+func (h *Handler) frameAncestor() string {
+	origin := h.config.FrontChannelLogoutOrigin
+	if origin == "" {
+		if u, err := url.Parse(h.config.SignInURL); err == nil && u.Host != "" {
+			origin = u.Scheme + "://" + u.Host
+		}
+	}
+	if origin == "" {
+		return "'none'"
+	}
+	return origin
+}
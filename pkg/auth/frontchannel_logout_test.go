@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signInWithSID signs user 1 in from an ID token carrying iss and sid.
+func signInWithSID(t *testing.T, s *testService, iss, sid string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	result := &CallbackResult{UserID: 1, IDToken: "token", Claims: jwt.MapClaims{"iss": iss, "sid": sid}}
+	if err := s.CompleteSignIn(recorder, httptest.NewRequest(http.MethodGet, "/callback", nil), result, false); err != nil {
+		t.Fatal(err)
+	}
+	return recorder
+}
+
+func TestFrontChannelLogout(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     url.Values
+		wantEnded bool
+	}{
+		{"matching iss and sid", url.Values{"iss": {"https://sso.example.com"}, "sid": {"sid-1"}}, true},
+		{"other sid", url.Values{"iss": {"https://sso.example.com"}, "sid": {"sid-2"}}, false},
+		{"other issuer", url.Values{"iss": {"https://evil.example.com"}, "sid": {"sid-1"}}, false},
+		{"sid without iss", url.Values{"sid": {"sid-1"}}, false},
+		{"iss without sid", url.Values{"iss": {"https://sso.example.com"}}, false},
+		{"no parameters", url.Values{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, nil)
+			handler := NewHandler(s.AuthService, &Config{SignInURL: "https://sso.example.com/signin"})
+			signedIn := signInWithSID(t, s, "https://sso.example.com", "sid-1")
+
+			recorder := serve(handler.FrontChannelLogout, withCookies(httptest.NewRequest(http.MethodGet, "/logout?"+tt.query.Encode(), nil), signedIn))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+			}
+			if csp := recorder.Header().Get("Content-Security-Policy"); !strings.HasSuffix(csp, "frame-ancestors https://sso.example.com") {
+				t.Errorf("Content-Security-Policy = %q", csp)
+			}
+
+			stillSignedIn := s.IsUserSignedIn(withCookies(httptest.NewRequest(http.MethodGet, "/", nil), signedIn))
+			if stillSignedIn == tt.wantEnded {
+				t.Errorf("signed in after logout = %v, want %v", stillSignedIn, !tt.wantEnded)
+			}
+		})
+	}
+}
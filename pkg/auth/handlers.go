@@ -1,23 +1,36 @@
 package auth
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"net/url"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
 )
 
 type Handler struct {
 	authService *AuthService
 	config      *Config
+	userJSON    func(*models.User) any
 }
 
 type Config struct {
-	SignInURL   string
-	SignOutURL  string
-	CallbackURL string
-	RootURL     string
+	SignInURL     string
+	SignOutURL    string
+	CallbackURL   string
+	RootURL       string
+	ResponseModes []string
+
+	// RP-initiated logout
+	EndSessionURL         string
+	PostLogoutRedirectURL string
+	ClientID              string
+
+	// Front-channel logout
+	FrontChannelLogoutOrigin string
 }
 
 func NewHandler(authService *AuthService, config *Config) *Handler {
@@ -27,6 +40,12 @@ func NewHandler(authService *AuthService, config *Config) *Handler {
 	}
 }
 
+// SetUserJSON replaces the body the User handler returns for the signed-in
+// user. By default it is the user's id, email and name.
+func (h *Handler) SetUserJSON(render func(*models.User) any) {
+	h.userJSON = render
+}
+
 func (h *Handler) SignIn(c *gin.Context) {
 	if h.authService.IsUserSignedIn(c.Request) {
 		c.Redirect(http.StatusFound, h.config.RootURL)
@@ -43,6 +62,11 @@ func (h *Handler) SignOut(c *gin.Context) {
 		return
 	}
 
+	if h.config.EndSessionURL != "" {
+		h.endSession(c)
+		return
+	}
+
 	err := h.authService.SignOutUser(c.Writer, c.Request)
 	if err != nil {
 		log.Printf("Failed to sign out: %v", err)
@@ -55,37 +79,62 @@ func (h *Handler) SignOut(c *gin.Context) {
 }
 
 func (h *Handler) Callback(c *gin.Context) {
+	param, ok := h.callbackParams(c)
+	if !ok {
+		return
+	}
+
 	params := make(map[string]string)
 
-	pyIdToken := c.Query("py_id_token")
+	pyIdToken := param("py_id_token")
 	if pyIdToken != "" {
 		params["id_token"] = pyIdToken
 		log.Printf("Using py_id_token for authentication")
 	} else {
-		params["id_token"] = c.Query("id_token")
+		params["id_token"] = param("id_token")
 		log.Printf("Using id_token for authentication")
 	}
 
-	redirectFor := c.Query("redirect_for")
+	redirectFor := param("redirect_for")
 	if redirectFor != "" {
 		params["redirect_for"] = redirectFor
 		log.Printf("Using redirect_for for authentication")
 	} else {
-		params["redirect_for"] = c.Query("redirect_for")
+		params["redirect_for"] = param("redirect_for")
 		log.Printf("Using redirect_for for authentication")
 	}
 
+	if params["id_token"] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing id_token"})
+		return
+	}
+
+	// Tokens in a query string end up in browser history and access logs, so
+	// they are only taken from a POSTed form.
+	if c.Request.Method == http.MethodPost {
+		for _, name := range []string{"access_token", "refresh_token", "expires_in"} {
+			if value := param(name); value != "" {
+				params[name] = value
+			}
+		}
+	}
+
 	isMobile := redirectFor == "mobile" || redirectFor == "in_app_web"
-	endpoint := c.Query("endpoint")
+	endpoint := param("endpoint")
 
-	userID, err := h.authService.HandleCallback(params)
+	result, err := h.authService.ProcessCallbackContext(c.Request.Context(), params)
+	if errors.Is(err, ErrUserDisabled) {
+		log.Printf("Refused callback: %v", err)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to process callback: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process callback"})
 		return
 	}
 
-	err = h.authService.SignInUser(c.Writer, c.Request, userID, isMobile)
+	err = h.authService.CompleteSignIn(c.Writer, c.Request, result, isMobile)
 	if err != nil {
 		log.Printf("Failed to sign in user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in user"})
@@ -117,13 +166,18 @@ func (h *Handler) User(c *gin.Context) {
 		return
 	}
 
-	user, err := h.authService.GetUserByID(userID)
+	user, err := h.authService.GetUserByIDContext(c.Request.Context(), userID)
 	if err != nil {
 		log.Printf("Error getting user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user details"})
 		return
 	}
 
+	if h.userJSON != nil {
+		c.JSON(http.StatusOK, h.userJSON(user))
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"id":    user.ID,
 		"email": user.Email,
@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+	"github.com/jarvisconsulting/sso-client-go/pkg/store"
+)
+
+// fakeRepo is an in-memory UserRepository. FindByIDContext serves cached
+// users ahead of users, like a repository with a user cache.
+type fakeRepo struct {
+	mu       sync.Mutex
+	users    map[uint]*models.User
+	cached   map[uint]*models.User
+	jtis     map[string]uint
+	consumed map[string]bool
+	sshKeys  []models.SshKey
+	upserts  []upsert
+}
+
+// upsert records one UpsertUserContext call.
+type upsert struct {
+	user    models.User
+	columns []string
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{
+		users:    map[uint]*models.User{},
+		cached:   map[uint]*models.User{},
+		jtis:     map[string]uint{},
+		consumed: map[string]bool{},
+	}
+}
+
+func (r *fakeRepo) FindByIDContext(ctx context.Context, id uint) (*models.User, error) {
+	r.mu.Lock()
+	cached, ok := r.cached[id]
+	r.mu.Unlock()
+	if ok {
+		copied := *cached
+		return &copied, nil
+	}
+	return r.FindByIDUncachedContext(ctx, id)
+}
+
+func (r *fakeRepo) FindByIDUncachedContext(ctx context.Context, id uint) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (r *fakeRepo) FindByJTIContext(ctx context.Context, jti string) (uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.jtis[jti]
+	if !ok || r.consumed[jti] {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return id, nil
+}
+
+func (r *fakeRepo) ConsumeAccessTokenContext(ctx context.Context, jti string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.jtis[jti]; !ok || r.consumed[jti] {
+		return errors.New("access token JTI was already used")
+	}
+	r.consumed[jti] = true
+	return nil
+}
+
+func (r *fakeRepo) UpsertUserContext(ctx context.Context, user *models.User, columns ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *user
+	r.users[user.ID] = &copied
+	r.upserts = append(r.upserts, upsert{user: copied, columns: columns})
+	return nil
+}
+
+func (r *fakeRepo) GetLastSshKeys(limit int) ([]models.SshKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]models.SshKey, 0, limit)
+	for i := len(r.sshKeys) - 1; i >= 0 && len(keys) < limit; i-- {
+		keys = append(keys, r.sshKeys[i])
+	}
+	return keys, nil
+}
+
+func (r *fakeRepo) GetLastSshPublicKeys(limit int) ([]models.SshPublicKey, error) {
+	return nil, errors.New("not used")
+}
+
+// addKey stores a signing key created age ago and returns its kid.
+func (r *fakeRepo) addKey(t *testing.T, signer crypto.Signer, age time.Duration) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := uint(len(r.sshKeys) + 1)
+	r.sshKeys = append(r.sshKeys, models.SshKey{
+		ID:            id,
+		PrivateRsaKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:     time.Now().Add(-age),
+	})
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// countingRecorder counts IncCounter calls by metric name.
+type countingRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (r *countingRecorder) IncCounter(name string, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.counts == nil {
+		r.counts = map[string]int{}
+	}
+	r.counts[name]++
+}
+
+func (r *countingRecorder) count(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.counts[name]
+}
+
+type testService struct {
+	*AuthService
+	repo    *fakeRepo
+	redis   *miniredis.Miniredis
+	metrics *countingRecorder
+}
+
+func newTestConfig(redisAddr string) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + redisAddr
+	cfg.IsRedisSecure = false
+	return cfg
+}
+
+// newTestService builds an AuthService backed by miniredis and a fakeRepo.
+// configure may adjust the config before the service is created.
+func newTestService(t *testing.T, configure func(*config.Config)) *testService {
+	t.Helper()
+
+	redisServer := miniredis.RunT(t)
+	cfg := newTestConfig(redisServer.Addr())
+	if configure != nil {
+		configure(cfg)
+	}
+
+	sessionStore, err := store.NewRedisSessionStore(cfg.RedisURI, cfg.SessionKey, cfg.IsRedisSecure, cfg.SessionMaxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessionStore.Close() })
+
+	repo := newFakeRepo()
+	service, err := NewAuthService(repo, cfg, sessionStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &countingRecorder{}
+	service.SetMetricsRecorder(recorder)
+
+	return &testService{AuthService: service, repo: repo, redis: redisServer, metrics: recorder}
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// sign returns a token signed with key; kid is omitted from the header when
+// empty.
+func sign(t *testing.T, method jwt.SigningMethod, key crypto.Signer, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims(jti string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"jti": jti,
+		"sub": "user",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+// withCookies adds the cookies set in recorder to r.
+func withCookies(r *http.Request, recorder *httptest.ResponseRecorder) *http.Request {
+	for _, cookie := range recorder.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+// signedInUser registers user 1 with a callback JTI and returns an ID token
+// for it signed with a fresh key.
+func (s *testService) signedInUser(t *testing.T, jti string) string {
+	t.Helper()
+
+	key := newRSAKey(t)
+	kid := s.repo.addKey(t, key, 0)
+	s.repo.mu.Lock()
+	s.repo.users[1] = &models.User{ID: 1, Email: "user@example.com", Name: "User"}
+	s.repo.jtis[jti] = 1
+	s.repo.mu.Unlock()
+
+	return sign(t, jwt.SigningMethodRS256, key, kid, validClaims(jti))
+}
+
+// serve runs a single handler on a fresh gin engine.
+func serve(handler gin.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Any("/*path", handler)
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, r)
+	return recorder
+}
+
+// signIn completes a query-mode callback for user 1 and returns the response
+// carrying the session cookie.
+func (s *testService) signIn(t *testing.T, handler *Handler) *httptest.ResponseRecorder {
+	t.Helper()
+
+	idToken := s.signedInUser(t, "jti-"+strconv.Itoa(len(s.repo.jtis)))
+	recorder := serve(handler.Callback, httptest.NewRequest(http.MethodGet, "/callback?id_token="+idToken, nil))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("callback status = %d: %s", recorder.Code, recorder.Body)
+	}
+	return recorder
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/oauth"
+	"github.com/jarvisconsulting/sso-client-go/pkg/singleflight"
+)
+
+const (
+	BearerValidationJWT           = "jwt"
+	BearerValidationIntrospection = "introspection"
+
+	IntrospectionFailClosed = "fail_closed"
+	IntrospectionFailOpen   = "fail_open"
+
+	defaultNegativeCacheTTL    = 10 * time.Second
+	defaultPositiveCacheTTL    = 60 * time.Second // for active tokens without exp
+	maxIntrospectionCacheItems = 10000
+	introspectionTimeout       = 5 * time.Second
+
+	introspectionFallbackMetric = "introspection_fallbacks"
+)
+
+var (
+	ErrTokenInactive         = errors.New("invalid token: token is not active")
+	ErrIntrospectionDown     = errors.New("token introspection endpoint is unavailable")
+	errIntrospectionNotReady = errors.New("token introspection is not configured: introspection_url is empty")
+)
+
+type introspectionResult struct {
+	claims  jwt.MapClaims
+	err     error
+	expires time.Time
+}
+
+// introspectionVerifier validates bearer tokens through the SSO server's
+// introspection endpoint. Active tokens are cached until they expire and
+// inactive ones briefly; concurrent lookups of one token share a single call.
+type introspectionVerifier struct {
+	service      *AuthService
+	introspector *oauth.Introspector
+	negativeTTL  time.Duration
+	failOpen     bool
+
+	mu    sync.Mutex
+	cache map[string]*introspectionResult
+	group singleflight.Group
+}
+
+func newIntrospectionVerifier(s *AuthService) *introspectionVerifier {
+	negativeTTL := defaultNegativeCacheTTL
+	if s.config.IntrospectionNegativeCacheTTL > 0 {
+		negativeTTL = time.Duration(s.config.IntrospectionNegativeCacheTTL) * time.Second
+	}
+
+	return &introspectionVerifier{
+		service:      s,
+		introspector: oauth.NewIntrospector(s.config.IntrospectionURL, s.config.ClientID, s.config.ClientSecret),
+		negativeTTL:  negativeTTL,
+		failOpen:     s.config.IntrospectionFailurePolicy == IntrospectionFailOpen,
+		cache:        make(map[string]*introspectionResult),
+	}
+}
+
+func (v *introspectionVerifier) verify(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+	if v.service.config.IntrospectionURL == "" {
+		return nil, errIntrospectionNotReady
+	}
+	if v.service.config.BearerAudience == "" {
+		return nil, ErrNoBearerAudience
+	}
+
+	sum := sha256.Sum256([]byte(accessToken))
+	key := hex.EncodeToString(sum[:])
+
+	if result := v.cached(key); result != nil {
+		return result.response()
+	}
+
+	// The shared call is detached from ctx so one caller giving up does not
+	// fail the others.
+	value, err := v.group.DoContext(ctx, key, func() (any, error) {
+		if result := v.cached(key); result != nil {
+			return result, nil
+		}
+		return v.introspect(key, accessToken)
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return v.fallback(accessToken, err)
+	}
+
+	return value.(*introspectionResult).response()
+}
+
+// response returns a copy of the cached claims, so callers cannot change what
+// later requests with the same token see.
+func (r *introspectionResult) response() (jwt.MapClaims, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	claims := make(jwt.MapClaims, len(r.claims))
+	for name, value := range r.claims {
+		claims[name] = value
+	}
+	return claims, nil
+}
+
+func (v *introspectionVerifier) introspect(key, accessToken string) (*introspectionResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), introspectionTimeout)
+	defer cancel()
+
+	response, err := v.introspector.Introspect(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := &introspectionResult{}
+	if active, _ := response["active"].(bool); !active {
+		result.err = ErrTokenInactive
+		result.expires = now.Add(v.negativeTTL)
+	} else {
+		result.claims = jwt.MapClaims(response)
+		result.expires = now.Add(defaultPositiveCacheTTL)
+		if exp, err := result.claims.GetExpirationTime(); err == nil && exp != nil {
+			if !exp.After(now) {
+				result.claims, result.err = nil, ErrTokenInactive
+				result.expires = now.Add(v.negativeTTL)
+			} else {
+				result.expires = exp.Time
+			}
+		}
+		if result.err == nil {
+			if err := v.checkClaims(result.claims); err != nil {
+				result.claims, result.err = nil, err
+			}
+		}
+	}
+
+	v.store(key, result)
+	return result, nil
+}
+
+// checkClaims applies the checks local verification makes to an active
+// token: it must be an access token whose aud includes BearerAudience.
+func (v *introspectionVerifier) checkClaims(claims jwt.MapClaims) error {
+	if tokenType, ok := claims["token_type"].(string); ok {
+		switch strings.ToLower(tokenType) {
+		case "access_token", "bearer":
+		default:
+			return fmt.Errorf("invalid token: token_type %q is not an access token", tokenType)
+		}
+	}
+
+	audience, err := claims.GetAudience()
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	found := false
+	for _, aud := range audience {
+		if aud == v.service.config.BearerAudience {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("invalid token: aud does not include bearer_audience")
+	}
+
+	_, err = ActorFromClaims(claims)
+	return err
+}
+
+// fallback applies the failure policy when the endpoint gave no answer:
+// fail-open verifies the token locally as a signed JWT, fail-closed rejects it.
+// Any other failure, such as the endpoint refusing our client credentials,
+// rejects the token whatever the policy.
+func (v *introspectionVerifier) fallback(accessToken string, cause error) (jwt.MapClaims, error) {
+	log.Printf("Token introspection failed: %v", cause)
+	if !unreachable(cause) {
+		return nil, fmt.Errorf("error introspecting token: %w", cause)
+	}
+	if !v.failOpen {
+		return nil, ErrIntrospectionDown
+	}
+
+	v.service.metrics.IncCounter(introspectionFallbackMetric, nil)
+	return v.service.verifyJWTAccessToken(accessToken)
+}
+
+// unreachable reports whether err means the endpoint could not be reached or
+// failed with a server error.
+func unreachable(err error) bool {
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		return oauthErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+func (v *introspectionVerifier) cached(key string) *introspectionResult {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	result, ok := v.cache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(result.expires) {
+		delete(v.cache, key)
+		return nil
+	}
+	return result
+}
+
+func (v *introspectionVerifier) store(key string, result *introspectionResult) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.cache) >= maxIntrospectionCacheItems {
+		now := time.Now()
+		for k, cached := range v.cache {
+			if now.After(cached.expires) {
+				delete(v.cache, k)
+			}
+		}
+		if len(v.cache) >= maxIntrospectionCacheItems {
+			v.cache = make(map[string]*introspectionResult)
+		}
+	}
+	v.cache[key] = result
+}
@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+)
+
+// introspectionServer answers RFC 7662 requests from responses, keyed by
+// token; unknown tokens are inactive. While down is set it answers 503, and
+// release, when set, holds every response until it is closed.
+type introspectionServer struct {
+	*httptest.Server
+	requests  atomic.Int32
+	down      atomic.Bool
+	release   chan struct{}
+	responses map[string]map[string]any
+}
+
+func newIntrospectionServer(t *testing.T, responses map[string]map[string]any) *introspectionServer {
+	t.Helper()
+
+	is := &introspectionServer{responses: responses}
+	is.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if is.release != nil {
+			<-is.release
+		}
+		is.requests.Add(1)
+		if is.down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if user, password, _ := r.BasicAuth(); user != "api" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		response, ok := is.responses[r.PostFormValue("token")]
+		if !ok {
+			response = map[string]any{"active": false}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(is.Close)
+	return is
+}
+
+func newIntrospectionService(t *testing.T, is *introspectionServer, configure func(*config.Config)) *testService {
+	t.Helper()
+
+	return newTestService(t, func(cfg *config.Config) {
+		cfg.ClientID = "api"
+		cfg.ClientSecret = "secret"
+		cfg.BearerValidationMode = BearerValidationIntrospection
+		cfg.IntrospectionURL = is.URL
+		cfg.BearerAudience = "orders"
+		if configure != nil {
+			configure(cfg)
+		}
+	})
+}
+
+// cacheExpiry returns when the cached result for token expires.
+func (s *testService) cacheExpiry(t *testing.T, token string) time.Time {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(token))
+	s.introspection.mu.Lock()
+	defer s.introspection.mu.Unlock()
+	result, ok := s.introspection.cache[hex.EncodeToString(sum[:])]
+	if !ok {
+		t.Fatalf("no cached result for %q", token)
+	}
+	return result.expires
+}
+
+func TestIntrospectionCachesActiveTokenUntilExpiry(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	is := newIntrospectionServer(t, map[string]map[string]any{
+		"opaque": {"active": true, "aud": "orders", "sub": "user", "exp": exp.Unix(), "act": map[string]any{"sub": "service-a"}},
+	})
+	s := newIntrospectionService(t, is, nil)
+
+	for i := 0; i < 3; i++ {
+		claims, err := s.VerifyAccessTokenContext(context.Background(), "opaque")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims["sub"] != "user" {
+			t.Errorf("claims = %v", claims)
+		}
+	}
+	if got := is.requests.Load(); got != 1 {
+		t.Errorf("introspection requests = %d, want 1", got)
+	}
+	if got := s.cacheExpiry(t, "opaque"); !got.Equal(exp) {
+		t.Errorf("cached until %v, want token expiry %v", got, exp)
+	}
+}
+
+func TestIntrospectionCachesActiveTokenWithoutExpiryBriefly(t *testing.T) {
+	is := newIntrospectionServer(t, map[string]map[string]any{
+		"opaque": {"active": true, "aud": "orders", "sub": "user"},
+	})
+	s := newIntrospectionService(t, is, nil)
+
+	if _, err := s.VerifyAccessTokenContext(context.Background(), "opaque"); err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Until(s.cacheExpiry(t, "opaque")); got > defaultPositiveCacheTTL || got < defaultPositiveCacheTTL-time.Second {
+		t.Errorf("cached for %v, want %v", got, defaultPositiveCacheTTL)
+	}
+}
+
+func TestIntrospectionInactiveTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		response map[string]any
+		wantErr  error
+	}{
+		{"inactive", map[string]any{"active": false}, ErrTokenInactive},
+		{"expired", map[string]any{"active": true, "exp": time.Now().Add(-time.Minute).Unix()}, ErrTokenInactive},
+		{"bad act claim", map[string]any{"active": true, "aud": "orders", "act": "service-a"}, nil},
+		{"no audience", map[string]any{"active": true}, nil},
+		{"other audience", map[string]any{"active": true, "aud": []string{"billing"}}, nil},
+		{"refresh token", map[string]any{"active": true, "aud": "orders", "token_type": "refresh_token"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := newIntrospectionServer(t, map[string]map[string]any{"opaque": tt.response})
+			s := newIntrospectionService(t, is, func(cfg *config.Config) {
+				cfg.IntrospectionNegativeCacheTTL = 30
+			})
+
+			for i := 0; i < 2; i++ {
+				_, err := s.VerifyAccessTokenContext(context.Background(), "opaque")
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("VerifyAccessTokenContext() error = %v, want %v", err, tt.wantErr)
+				}
+			}
+			if got := is.requests.Load(); got != 1 {
+				t.Errorf("introspection requests = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestIntrospectionReturnsCopies(t *testing.T) {
+	is := newIntrospectionServer(t, map[string]map[string]any{
+		"opaque": {"active": true, "aud": "orders", "sub": "user", "token_type": "Bearer"},
+	})
+	s := newIntrospectionService(t, is, nil)
+
+	claims, err := s.VerifyAccessTokenContext(context.Background(), "opaque")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims["sub"] = "admin"
+
+	if claims, err := s.VerifyAccessTokenContext(context.Background(), "opaque"); err != nil || claims["sub"] != "user" {
+		t.Errorf("VerifyAccessTokenContext() = %v, %v, want the cached sub unchanged", claims, err)
+	}
+}
+
+func TestIntrospectionRequiresBearerAudience(t *testing.T) {
+	is := newIntrospectionServer(t, map[string]map[string]any{
+		"opaque": {"active": true, "aud": "orders"},
+	})
+	s := newIntrospectionService(t, is, func(cfg *config.Config) {
+		cfg.BearerAudience = ""
+	})
+
+	if _, err := s.VerifyAccessTokenContext(context.Background(), "opaque"); !errors.Is(err, ErrNoBearerAudience) {
+		t.Errorf("VerifyAccessTokenContext() error = %v, want ErrNoBearerAudience", err)
+	}
+}
+
+func TestIntrospectionNegativeCacheTTL(t *testing.T) {
+	is := newIntrospectionServer(t, nil)
+	s := newIntrospectionService(t, is, func(cfg *config.Config) {
+		cfg.IntrospectionNegativeCacheTTL = 30
+	})
+
+	if _, err := s.VerifyAccessTokenContext(context.Background(), "revoked"); !errors.Is(err, ErrTokenInactive) {
+		t.Fatalf("VerifyAccessTokenContext() error = %v, want ErrTokenInactive", err)
+	}
+	if got := time.Until(s.cacheExpiry(t, "revoked")); got > 30*time.Second || got < 29*time.Second {
+		t.Errorf("inactive token cached for %v, want 30s", got)
+	}
+}
+
+func TestIntrospectionSharesConcurrentLookups(t *testing.T) {
+	is := newIntrospectionServer(t, map[string]map[string]any{
+		"opaque": {"active": true, "aud": "orders", "sub": "user", "exp": time.Now().Add(time.Hour).Unix()},
+	})
+	is.release = make(chan struct{})
+	s := newIntrospectionService(t, is, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.VerifyAccessTokenContext(context.Background(), "opaque"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(is.release)
+	wg.Wait()
+
+	if got := is.requests.Load(); got != 1 {
+		t.Errorf("introspection requests = %d, want 1", got)
+	}
+}
+
+func TestIntrospectionCanceledCallerDoesNotFailOthers(t *testing.T) {
+	is := newIntrospectionServer(t, map[string]map[string]any{
+		"opaque": {"active": true, "aud": "orders", "sub": "user", "exp": time.Now().Add(time.Hour).Unix()},
+	})
+	is.release = make(chan struct{})
+	s := newIntrospectionService(t, is, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := s.VerifyAccessTokenContext(ctx, "opaque")
+		first <- err
+	}()
+	second := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, err := s.VerifyAccessTokenContext(context.Background(), "opaque")
+		second <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller error = %v, want context.Canceled", err)
+	}
+
+	close(is.release)
+	if err := <-second; err != nil {
+		t.Errorf("waiting caller error = %v", err)
+	}
+}
+
+func TestIntrospectionFailClosed(t *testing.T) {
+	is := newIntrospectionServer(t, nil)
+	is.down.Store(true)
+	s := newIntrospectionService(t, is, nil)
+	key := newRSAKey(t)
+	token := sign(t, jwt.SigningMethodRS256, key, s.repo.addKey(t, key, 0), accessClaims("orders"))
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.VerifyAccessTokenContext(context.Background(), token); !errors.Is(err, ErrIntrospectionDown) {
+			t.Fatalf("VerifyAccessTokenContext() error = %v, want ErrIntrospectionDown", err)
+		}
+	}
+	// Failures are not cached: every request asks again.
+	if got := is.requests.Load(); got != 2 {
+		t.Errorf("introspection requests = %d, want 2", got)
+	}
+	if got := s.metrics.count(introspectionFallbackMetric); got != 0 {
+		t.Errorf("fallback metric = %d, want 0", got)
+	}
+}
+
+func TestIntrospectionFailOpenVerifiesLocally(t *testing.T) {
+	is := newIntrospectionServer(t, nil)
+	is.down.Store(true)
+	s := newIntrospectionService(t, is, func(cfg *config.Config) {
+		cfg.IntrospectionFailurePolicy = IntrospectionFailOpen
+	})
+	key := newRSAKey(t)
+	kid := s.repo.addKey(t, key, 0)
+
+	claims, err := s.VerifyAccessTokenContext(context.Background(), sign(t, jwt.SigningMethodRS256, key, kid, accessClaims("orders")))
+	if err != nil || claims["sub"] != "user" {
+		t.Fatalf("VerifyAccessTokenContext() = %v, %v", claims, err)
+	}
+	if got := s.metrics.count(introspectionFallbackMetric); got != 1 {
+		t.Errorf("fallback metric = %d, want 1", got)
+	}
+
+	// The local checks still apply.
+	if _, err := s.VerifyAccessTokenContext(context.Background(), sign(t, jwt.SigningMethodRS256, key, kid, accessClaims("billing"))); err == nil {
+		t.Error("fail-open accepted a token for another audience")
+	}
+	if _, err := s.VerifyAccessTokenContext(context.Background(), "opaque"); err == nil {
+		t.Error("fail-open accepted an opaque token")
+	}
+}
+
+func TestIntrospectionFailOpenOnlyWhenUnreachable(t *testing.T) {
+	is := newIntrospectionServer(t, nil)
+	s := newIntrospectionService(t, is, func(cfg *config.Config) {
+		cfg.IntrospectionFailurePolicy = IntrospectionFailOpen
+		cfg.ClientSecret = "wrong"
+	})
+	key := newRSAKey(t)
+	token := sign(t, jwt.SigningMethodRS256, key, s.repo.addKey(t, key, 0), accessClaims("orders"))
+
+	// The endpoint answered 401: it is up, so the token is not verified locally.
+	if _, err := s.VerifyAccessTokenContext(context.Background(), token); err == nil || errors.Is(err, ErrIntrospectionDown) {
+		t.Errorf("VerifyAccessTokenContext() error = %v, want the endpoint's refusal", err)
+	}
+	if got := s.metrics.count(introspectionFallbackMetric); got != 0 {
+		t.Errorf("fallback metric = %d, want 0", got)
+	}
+
+	is.Close()
+	if _, err := s.VerifyAccessTokenContext(context.Background(), token); err != nil {
+		t.Errorf("VerifyAccessTokenContext() with the endpoint unreachable error = %v", err)
+	}
+	if got := s.metrics.count(introspectionFallbackMetric); got != 1 {
+		t.Errorf("fallback metric = %d, want 1", got)
+	}
+}
+
+func TestIntrospectionWithoutURL(t *testing.T) {
+	is := newIntrospectionServer(t, nil)
+	s := newIntrospectionService(t, is, func(cfg *config.Config) {
+		cfg.IntrospectionURL = ""
+	})
+
+	if _, err := s.VerifyAccessTokenContext(context.Background(), "opaque"); !errors.Is(err, errIntrospectionNotReady) {
+		t.Errorf("VerifyAccessTokenContext() error = %v, want errIntrospectionNotReady", err)
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrLogoutStateMismatch = errors.New("logout state does not match")
+
+// BeginLogout signs the user out locally and returns the SSO server's end
+// session URL. The ID token hint stays in the session until the SSO server
+// redirects back, so only the state check in FinishLogout can clear it.
+func (s *AuthService) BeginLogout(w http.ResponseWriter, r *http.Request, endSessionURL, postLogoutRedirectURL, clientID string) (string, error) {
+	session, err := s.sessionStore.GetStore().Get(r, s.config.SessionName)
+	if err != nil {
+		return "", err
+	}
+
+	logoutURL, err := url.Parse(endSessionURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid end session URL: %w", err)
+	}
+
+	state, err := randomState()
+	if err != nil {
+		return "", err
+	}
+
+	query := logoutURL.Query()
+	if idToken, ok := session.Values[SessionIDTokenKey].(string); ok && idToken != "" {
+		query.Set("id_token_hint", idToken)
+	}
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	if postLogoutRedirectURL != "" {
+		query.Set("post_logout_redirect_uri", postLogoutRedirectURL)
+	}
+	query.Set("state", state)
+	logoutURL.RawQuery = query.Encode()
+
+	delete(session.Values, SessionUserIDKey)
+	clearTokens(session)
+	session.Values[SessionLogoutStateKey] = state
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+
+	return logoutURL.String(), nil
+}
+
+// FinishLogout checks the state the SSO server returned after sign-out and
+// deletes whatever is left of the session.
+func (s *AuthService) FinishLogout(w http.ResponseWriter, r *http.Request, state string) error {
+	session, err := s.sessionStore.GetStore().Get(r, s.config.SessionName)
+	if err != nil {
+		return err
+	}
+
+	expected, _ := session.Values[SessionLogoutStateKey].(string)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(state)) != 1 {
+		return ErrLogoutStateMismatch
+	}
+
+	for key := range session.Values {
+		delete(session.Values, key)
+	}
+	session.Options.MaxAge = -1
+	return session.Save(r, w)
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating state: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// endSession redirects to the SSO server's end_session_endpoint so the SSO
+// session ends along with the local one.
+func (h *Handler) endSession(c *gin.Context) {
+	logoutURL, err := h.authService.BeginLogout(c.Writer, c.Request, h.config.EndSessionURL, h.config.PostLogoutRedirectURL, h.config.ClientID)
+	if err != nil {
+		log.Printf("Failed to sign out: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out"})
+		return
+	}
+
+	log.Printf("Redirecting to end session endpoint after signout")
+	c.Redirect(http.StatusFound, logoutURL)
+}
+
+// SignOutCallback handles the SSO server's redirect to post_logout_redirect_uri.
+func (h *Handler) SignOutCallback(c *gin.Context) {
+	err := h.authService.FinishLogout(c.Writer, c.Request, c.Query("state"))
+	if errors.Is(err, ErrLogoutStateMismatch) {
+		log.Printf("Rejected sign-out callback: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sign-out state"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to finish sign out: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out"})
+		return
+	}
+
+	log.Printf("Redirecting to frontend after signout: %s", h.config.SignInURL)
+	c.Redirect(http.StatusFound, h.config.SignInURL)
+}
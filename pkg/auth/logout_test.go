@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSignOutEndsSSOSession(t *testing.T) {
+	s := newTestService(t, nil)
+	handler := NewHandler(s.AuthService, &Config{
+		RootURL:               "/",
+		SignInURL:             "/signin",
+		EndSessionURL:         "https://sso.example.com/logout?ui=1",
+		PostLogoutRedirectURL: "https://app.example.com/signed-out",
+		ClientID:              "client",
+	})
+	signedIn := s.signIn(t, handler)
+
+	signOut := serve(handler.SignOut, withCookies(httptest.NewRequest(http.MethodGet, "/signout", nil), signedIn))
+	if signOut.Code != http.StatusFound {
+		t.Fatalf("sign-out status = %d, want %d", signOut.Code, http.StatusFound)
+	}
+	location, err := url.Parse(signOut.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if location.Host != "sso.example.com" || query.Get("ui") != "1" {
+		t.Errorf("logout URL = %s, want the end session URL with its query kept", location)
+	}
+	if query.Get("id_token_hint") == "" || query.Get("client_id") != "client" ||
+		query.Get("post_logout_redirect_uri") != "https://app.example.com/signed-out" {
+		t.Errorf("logout URL query = %v", query)
+	}
+	state := query.Get("state")
+	if state == "" {
+		t.Fatal("logout URL has no state")
+	}
+
+	if s.IsUserSignedIn(withCookies(httptest.NewRequest(http.MethodGet, "/", nil), signedIn)) {
+		t.Error("user is still signed in locally after sign-out")
+	}
+
+	wrongState := serve(handler.SignOutCallback, withCookies(httptest.NewRequest(http.MethodGet, "/signed-out?state=forged", nil), signedIn))
+	if wrongState.Code != http.StatusBadRequest {
+		t.Errorf("callback with wrong state status = %d, want %d", wrongState.Code, http.StatusBadRequest)
+	}
+
+	finished := serve(handler.SignOutCallback, withCookies(httptest.NewRequest(http.MethodGet, "/signed-out?state="+url.QueryEscape(state), nil), signedIn))
+	if finished.Code != http.StatusFound || finished.Header().Get("Location") != "/signin" {
+		t.Errorf("callback status = %d, location = %q", finished.Code, finished.Header().Get("Location"))
+	}
+
+	replayed := serve(handler.SignOutCallback, withCookies(httptest.NewRequest(http.MethodGet, "/signed-out?state="+url.QueryEscape(state), nil), signedIn))
+	if replayed.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback status = %d, want %d", replayed.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSignOutWithoutEndSessionURL(t *testing.T) {
+	s := newTestService(t, nil)
+	handler := NewHandler(s.AuthService, &Config{RootURL: "/", SignInURL: "/signin"})
+	signedIn := s.signIn(t, handler)
+
+	signOut := serve(handler.SignOut, withCookies(httptest.NewRequest(http.MethodGet, "/signout", nil), signedIn))
+	if signOut.Code != http.StatusFound || signOut.Header().Get("Location") != "/signin" {
+		t.Errorf("sign-out status = %d, location = %q", signOut.Code, signOut.Header().Get("Location"))
+	}
+	if s.IsUserSignedIn(withCookies(httptest.NewRequest(http.MethodGet, "/", nil), signedIn)) {
+		t.Error("user is still signed in after sign-out")
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+const (
+	UserFieldEmail = "email"
+	UserFieldName  = "name"
+
+	userInfoTimeout = 5 * time.Second
+)
+
+var (
+	errNoSubject               = errors.New("ID token has no sub to match userinfo against")
+	errUserInfoSubjectMismatch = errors.New("userinfo sub does not match the ID token")
+)
+
+// userFields lists the users columns a claim can be mapped to.
+var userFields = []string{UserFieldEmail, UserFieldName}
+
+// provisionUser creates or updates the local users row from the signed-in
+// user's claims. Userinfo is preferred when configured and the callback
+// delivered an access token; the ID token claims are used otherwise.
+func (s *AuthService) provisionUser(ctx context.Context, userID uint, idClaims jwt.MapClaims, tokens *Tokens) error {
+	claims := map[string]any(idClaims)
+	if s.userInfo != nil && tokens != nil && tokens.AccessToken != "" {
+		userInfo, err := s.fetchUserInfo(ctx, idClaims, tokens.AccessToken)
+		if err != nil {
+			log.Printf("Failed to fetch userinfo, using ID token claims: %v", err)
+		} else {
+			claims = userInfo
+		}
+	}
+
+	user := &models.User{ID: userID}
+	var columns []string
+	for _, field := range userFields {
+		value, ok := claims[s.claimFor(field)].(string)
+		if !ok {
+			continue
+		}
+		switch field {
+		case UserFieldEmail:
+			user.Email = value
+		case UserFieldName:
+			user.Name = value
+		}
+		columns = append(columns, field)
+	}
+
+	if user.Email == "" {
+		return fmt.Errorf("claim %q is missing or empty", s.claimFor(UserFieldEmail))
+	}
+
+	return s.userRepo.UpsertUserContext(ctx, user, columns...)
+}
+
+// fetchUserInfo reads the userinfo endpoint. As OIDC requires, the response is
+// only trusted when its sub matches the ID token's.
+func (s *AuthService) fetchUserInfo(ctx context.Context, idClaims jwt.MapClaims, accessToken string) (map[string]any, error) {
+	sub, _ := idClaims["sub"].(string)
+	if sub == "" {
+		return nil, errNoSubject
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, userInfoTimeout)
+	defer cancel()
+
+	userInfo, err := s.userInfo.Fetch(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if userInfoSub, _ := userInfo["sub"].(string); userInfoSub != sub {
+		return nil, errUserInfoSubjectMismatch
+	}
+	return userInfo, nil
+}
+
+func (s *AuthService) claimFor(field string) string {
+	if claim := s.config.UserClaimMapping[field]; claim != "" {
+		return claim
+	}
+	return field
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+// newUserInfoServer serves claims to requests carrying the "access" token.
+// A nil claims map answers 500.
+func newUserInfoServer(t *testing.T, claims map[string]any) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if claims == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(claims)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// callbackToken registers a callback JTI for user 1 and returns an ID token
+// carrying the extra claims.
+func (s *testService) callbackToken(t *testing.T, extra jwt.MapClaims) string {
+	t.Helper()
+
+	key := newRSAKey(t)
+	kid := s.repo.addKey(t, key, 0)
+	s.repo.mu.Lock()
+	s.repo.jtis["jti"] = 1
+	s.repo.mu.Unlock()
+
+	claims := validClaims("jti")
+	for name, value := range extra {
+		claims[name] = value
+	}
+	return sign(t, jwt.SigningMethodRS256, key, kid, claims)
+}
+
+func TestProvisionUser(t *testing.T) {
+	idClaims := jwt.MapClaims{"email": "id@example.com", "name": "ID Token", "preferred_username": "idtoken"}
+	userInfo := map[string]any{"sub": "user", "email": "info@example.com", "name": "Userinfo", "preferred_username": "userinfo"}
+
+	tests := []struct {
+		name        string
+		idClaims    jwt.MapClaims
+		userInfo    map[string]any
+		accessToken string
+		mapping     map[string]string
+		want        *upsert
+	}{
+		{
+			name:     "ID token claims",
+			idClaims: idClaims,
+			want:     &upsert{models.User{ID: 1, Email: "id@example.com", Name: "ID Token"}, []string{"email", "name"}},
+		},
+		{
+			name:     "claim mapping",
+			idClaims: idClaims,
+			mapping:  map[string]string{"name": "preferred_username"},
+			want:     &upsert{models.User{ID: 1, Email: "id@example.com", Name: "idtoken"}, []string{"email", "name"}},
+		},
+		{
+			name:     "only mapped claims present are updated",
+			idClaims: jwt.MapClaims{"email": "id@example.com"},
+			want:     &upsert{models.User{ID: 1, Email: "id@example.com"}, []string{"email"}},
+		},
+		{
+			name:     "missing email",
+			idClaims: jwt.MapClaims{"name": "ID Token"},
+		},
+		{
+			name:        "userinfo",
+			idClaims:    idClaims,
+			userInfo:    userInfo,
+			accessToken: "access",
+			mapping:     map[string]string{"name": "preferred_username"},
+			want:        &upsert{models.User{ID: 1, Email: "info@example.com", Name: "userinfo"}, []string{"email", "name"}},
+		},
+		{
+			name:     "userinfo needs an access token",
+			idClaims: idClaims,
+			userInfo: userInfo,
+			want:     &upsert{models.User{ID: 1, Email: "id@example.com", Name: "ID Token"}, []string{"email", "name"}},
+		},
+		{
+			name:        "userinfo for another subject",
+			idClaims:    idClaims,
+			userInfo:    map[string]any{"sub": "someone-else", "email": "other@example.com"},
+			accessToken: "access",
+			want:        &upsert{models.User{ID: 1, Email: "id@example.com", Name: "ID Token"}, []string{"email", "name"}},
+		},
+		{
+			name:        "userinfo without sub",
+			idClaims:    idClaims,
+			userInfo:    map[string]any{"email": "other@example.com"},
+			accessToken: "access",
+			want:        &upsert{models.User{ID: 1, Email: "id@example.com", Name: "ID Token"}, []string{"email", "name"}},
+		},
+		{
+			name:        "ID token without sub",
+			idClaims:    jwt.MapClaims{"sub": "", "email": "id@example.com", "name": "ID Token"},
+			userInfo:    map[string]any{"sub": "", "email": "other@example.com"},
+			accessToken: "access",
+			want:        &upsert{models.User{ID: 1, Email: "id@example.com", Name: "ID Token"}, []string{"email", "name"}},
+		},
+		{
+			name:        "userinfo unavailable",
+			idClaims:    idClaims,
+			accessToken: "access",
+			want:        &upsert{models.User{ID: 1, Email: "id@example.com", Name: "ID Token"}, []string{"email", "name"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userInfoServer := newUserInfoServer(t, tt.userInfo)
+			s := newTestService(t, func(cfg *config.Config) {
+				cfg.ProvisionUsers = true
+				cfg.UserInfoURL = userInfoServer.URL
+				cfg.UserClaimMapping = tt.mapping
+			})
+
+			params := map[string]string{"id_token": s.callbackToken(t, tt.idClaims)}
+			if tt.accessToken != "" {
+				params["access_token"] = tt.accessToken
+			}
+			result, err := s.ProcessCallbackContext(context.Background(), params)
+			if err != nil {
+				t.Fatalf("ProcessCallbackContext() error = %v", err)
+			}
+			if result.UserID != 1 {
+				t.Errorf("UserID = %d, want 1", result.UserID)
+			}
+
+			if tt.want == nil {
+				if len(s.repo.upserts) != 0 {
+					t.Errorf("upserted %+v, want nothing", s.repo.upserts)
+				}
+				return
+			}
+			if len(s.repo.upserts) != 1 {
+				t.Fatalf("upserted %d times, want 1", len(s.repo.upserts))
+			}
+			if got := s.repo.upserts[0]; !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("upserted %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestProvisionUsersDisabled(t *testing.T) {
+	s := newTestService(t, nil)
+
+	params := map[string]string{"id_token": s.callbackToken(t, jwt.MapClaims{"email": "id@example.com"})}
+	if _, err := s.ProcessCallbackContext(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.repo.upserts) != 0 {
+		t.Errorf("upserted %+v without ProvisionUsers", s.repo.upserts)
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ResponseModeQuery    = "query"     // id_token in the callback URL query
+	ResponseModeFormPost = "form_post" // id_token in a POSTed form body
+	ResponseModeFragment = "fragment"  // id_token in the URL fragment, relayed by a small page
+)
+
+var fragmentRelayPage = template.Must(template.New("relay").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<title>Signing in</title>
+</head>
+<body>
+<form id="relay" method="post" action="{{.Action}}"></form>
+<noscript>JavaScript is required to complete sign in.</noscript>
+<script nonce="{{.Nonce}}">
+(function () {
+  var params = new URLSearchParams(window.location.hash.slice(1));
+  new URLSearchParams(window.location.search).forEach(function (value, name) {
+    if (!params.has(name)) params.set(name, value);
+  });
+  history.replaceState(null, "", window.location.pathname);
+  var form = document.getElementById("relay");
+  params.forEach(function (value, name) {
+    var input = document.createElement("input");
+    input.type = "hidden";
+    input.name = name;
+    input.value = value;
+    form.appendChild(input);
+  });
+  form.submit();
+})();
+</script>
+</body>
+</html>
+`))
+
+// callbackParams picks where the callback reads its parameters from, based on
+// the request and the enabled response modes. When it returns false a
+// response has already been written.
+func (h *Handler) callbackParams(c *gin.Context) (func(string) string, bool) {
+	switch {
+	case c.Request.Method == http.MethodPost:
+		// The fragment relay page posts the fragment back as a form.
+		if !h.responseModeEnabled(ResponseModeFormPost) && !h.responseModeEnabled(ResponseModeFragment) {
+			c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "form_post response mode is disabled"})
+			return nil, false
+		}
+		return c.PostForm, true
+	case c.Query("id_token") != "" || c.Query("py_id_token") != "":
+		if !h.responseModeEnabled(ResponseModeQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "query response mode is disabled"})
+			return nil, false
+		}
+		return c.Query, true
+	case h.responseModeEnabled(ResponseModeFragment):
+		h.fragmentRelay(c)
+		return nil, false
+	case !h.responseModeEnabled(ResponseModeQuery):
+		// Only form_post is enabled, so the token can only arrive in a POST.
+		c.Header("Allow", http.MethodPost)
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "query response mode is disabled"})
+		return nil, false
+	}
+
+	return c.Query, true
+}
+
+func (h *Handler) responseModeEnabled(mode string) bool {
+	modes := h.config.ResponseModes
+	if len(modes) == 0 {
+		modes = []string{ResponseModeQuery}
+	}
+
+	for _, enabled := range modes {
+		if enabled == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// fragmentRelay serves a page that reads the token from the URL fragment,
+// which never reaches the server, and posts it back to the callback.
+func (h *Handler) fragmentRelay(c *gin.Context) {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		log.Printf("Failed to generate relay page nonce: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process callback"})
+		return
+	}
+	nonce := base64.StdEncoding.EncodeToString(nonceBytes)
+
+	c.Header("Content-Security-Policy", "default-src 'none'; script-src 'nonce-"+nonce+"'; form-action 'self'; frame-ancestors 'none'")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	err := fragmentRelayPage.Execute(c.Writer, struct {
+		Action string
+		Nonce  string
+	}{
+		Action: c.Request.URL.Path,
+		Nonce:  nonce,
+	})
+	if err != nil {
+		log.Printf("Failed to render fragment relay page: %v", err)
+	}
+}
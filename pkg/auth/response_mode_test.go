@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCallbackResponseModes(t *testing.T) {
+	tests := []struct {
+		name       string
+		modes      []string
+		method     string
+		idToken    bool
+		wantStatus int
+	}{
+		{"query", nil, http.MethodGet, true, http.StatusFound},
+		{"query without token", nil, http.MethodGet, false, http.StatusBadRequest},
+		{"query rejects POST", nil, http.MethodPost, true, http.StatusMethodNotAllowed},
+		{"form_post", []string{ResponseModeFormPost}, http.MethodPost, true, http.StatusFound},
+		{"form_post without token", []string{ResponseModeFormPost}, http.MethodPost, false, http.StatusBadRequest},
+		{"form_post rejects query token", []string{ResponseModeFormPost}, http.MethodGet, true, http.StatusBadRequest},
+		{"form_post rejects bare GET", []string{ResponseModeFormPost}, http.MethodGet, false, http.StatusMethodNotAllowed},
+		{"fragment relay", []string{ResponseModeFragment}, http.MethodGet, false, http.StatusOK},
+		{"fragment post back", []string{ResponseModeFragment}, http.MethodPost, true, http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, nil)
+			handler := NewHandler(s.AuthService, &Config{RootURL: "/", ResponseModes: tt.modes})
+
+			values := url.Values{}
+			if tt.idToken {
+				values.Set("id_token", s.signedInUser(t, "jti"))
+			}
+
+			var r *http.Request
+			if tt.method == http.MethodPost {
+				r = httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(values.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				r = httptest.NewRequest(http.MethodGet, "/callback?"+values.Encode(), nil)
+			}
+
+			recorder := serve(handler.Callback, r)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestFragmentRelayPage(t *testing.T) {
+	s := newTestService(t, nil)
+	handler := NewHandler(s.AuthService, &Config{ResponseModes: []string{ResponseModeFragment}})
+
+	recorder := serve(handler.Callback, httptest.NewRequest(http.MethodGet, "/auth/callback", nil))
+
+	csp := recorder.Header().Get("Content-Security-Policy")
+	if !strings.Contains(csp, "script-src 'nonce-") || !strings.Contains(recorder.Body.String(), `action="/auth/callback"`) {
+		t.Errorf("relay page CSP = %q, body = %s", csp, recorder.Body)
+	}
+	if got := recorder.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+}
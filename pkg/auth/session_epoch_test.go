@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+)
+
+// session loads the session that the recorded response's cookies point to.
+func (s *testService) session(t *testing.T, signedIn *httptest.ResponseRecorder) *sessions.Session {
+	t.Helper()
+
+	session, err := s.sessionStore.GetStore().Get(withCookies(httptest.NewRequest(http.MethodGet, "/", nil), signedIn), s.config.SessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestCheckSessionEpochs(t *testing.T) {
+	tests := []struct {
+		name        string
+		bump        func(s *AuthService) error
+		wantRevoked bool
+	}{
+		{"no bump", nil, false},
+		{"user bumped", func(s *AuthService) error { return s.BumpSessionEpoch(1) }, true},
+		{"other user bumped", func(s *AuthService) error { return s.BumpSessionEpoch(2) }, false},
+		{"user bumped by key", func(s *AuthService) error { return s.BumpSessionEpochByKey("1") }, true},
+		{"global bump", func(s *AuthService) error { return s.BumpGlobalSessionEpoch() }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, func(cfg *config.Config) {
+				cfg.EnableSessionEpochs = true
+			})
+			handler := NewHandler(s.AuthService, &Config{RootURL: "/", SignInURL: "/signin"})
+			// Epochs bumped before sign-in do not affect the new session.
+			if err := s.BumpSessionEpoch(1); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.BumpGlobalSessionEpoch(); err != nil {
+				t.Fatal(err)
+			}
+			session := s.session(t, s.signIn(t, handler))
+
+			if tt.bump != nil {
+				if err := tt.bump(s.AuthService); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := s.CheckSession(context.Background(), session, "1")
+			if errors.Is(err, ErrSessionRevoked) != tt.wantRevoked {
+				t.Fatalf("CheckSession() error = %v, want revoked %v", err, tt.wantRevoked)
+			}
+			want := 0
+			if tt.wantRevoked {
+				want = 1
+			}
+			if got := s.metrics.count("revoked_sessions_rejected"); got != want {
+				t.Errorf("rejected metric = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckSessionEpochsOfUnstampedSessions(t *testing.T) {
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.EnableSessionEpochs = true
+	})
+	// A session from before epochs were enabled counts as epoch zero.
+	session := sessions.NewSession(s.sessionStore.GetStore(), s.config.SessionName)
+	session.Values[SessionUserIDKey] = uint(1)
+
+	if err := s.CheckSession(context.Background(), session, "1"); err != nil {
+		t.Fatalf("CheckSession() before a bump error = %v", err)
+	}
+	if err := s.BumpSessionEpoch(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSession(context.Background(), session, "1"); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("CheckSession() after a bump error = %v, want ErrSessionRevoked", err)
+	}
+}
+
+func TestCheckSessionEpochsDisabled(t *testing.T) {
+	s := newTestService(t, nil)
+	handler := NewHandler(s.AuthService, &Config{RootURL: "/", SignInURL: "/signin"})
+	session := s.session(t, s.signIn(t, handler))
+
+	if _, ok := session.Values[SessionEpochKey]; ok {
+		t.Error("session was stamped with an epoch while epochs are disabled")
+	}
+	if err := s.BumpGlobalSessionEpoch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSession(context.Background(), session, "1"); err != nil {
+		t.Errorf("CheckSession() error = %v, want the session kept", err)
+	}
+}
+
+func TestCheckSessionEpochsWithoutRedis(t *testing.T) {
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.EnableSessionEpochs = true
+	})
+	handler := NewHandler(s.AuthService, &Config{RootURL: "/", SignInURL: "/signin"})
+	session := s.session(t, s.signIn(t, handler))
+
+	// Sessions are kept while the epochs cannot be read.
+	s.redis.SetError("unavailable")
+	defer s.redis.SetError("")
+	if err := s.CheckSession(context.Background(), session, "1"); err != nil {
+		t.Errorf("CheckSession() error = %v, want the session kept", err)
+	}
+}
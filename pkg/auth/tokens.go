@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/sessions"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/oauth"
+	"github.com/jarvisconsulting/sso-client-go/pkg/store"
+)
+
+const (
+	SessionAccessTokenKey  = "access_token"
+	SessionRefreshTokenKey = "refresh_token"
+	SessionTokenExpiryKey  = "access_token_expiry" // Unix seconds; absent when the lifetime is unknown
+
+	defaultRefreshLeeway = 60 * time.Second
+	refreshLockTTL       = 30 * time.Second
+	refreshLockWait      = 10 * time.Second
+	refreshLockPrefix    = "sso:token_refresh:"
+)
+
+var (
+	ErrNoAccessToken = errors.New("no access token in session")
+	ErrRefreshFailed = errors.New("access token refresh was rejected; user signed out")
+)
+
+// Tokens are the OAuth tokens kept in the server-side session. They never
+// reach the session cookie, which only carries the session ID.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// tokensFromParams reads tokens delivered alongside the ID token.
+func tokensFromParams(params map[string]string) *Tokens {
+	if params["access_token"] == "" {
+		return nil
+	}
+
+	tokens := &Tokens{
+		AccessToken:  params["access_token"],
+		RefreshToken: params["refresh_token"],
+	}
+	if expiresIn, err := strconv.ParseInt(params["expires_in"], 10, 64); err == nil && expiresIn > 0 {
+		tokens.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return tokens
+}
+
+func tokensFromSession(session *sessions.Session) *Tokens {
+	tokens := &Tokens{}
+	tokens.AccessToken, _ = session.Values[SessionAccessTokenKey].(string)
+	tokens.RefreshToken, _ = session.Values[SessionRefreshTokenKey].(string)
+	if expiry, ok := session.Values[SessionTokenExpiryKey].(int64); ok {
+		tokens.Expiry = time.Unix(expiry, 0)
+	}
+	return tokens
+}
+
+func storeTokens(session *sessions.Session, tokens *Tokens) {
+	session.Values[SessionAccessTokenKey] = tokens.AccessToken
+	if tokens.RefreshToken != "" {
+		session.Values[SessionRefreshTokenKey] = tokens.RefreshToken
+	}
+	if tokens.Expiry.IsZero() {
+		delete(session.Values, SessionTokenExpiryKey)
+	} else {
+		session.Values[SessionTokenExpiryKey] = tokens.Expiry.Unix()
+	}
+}
+
+func clearTokens(session *sessions.Session) {
+	delete(session.Values, SessionAccessTokenKey)
+	delete(session.Values, SessionRefreshTokenKey)
+	delete(session.Values, SessionTokenExpiryKey)
+}
+
+func (s *AuthService) refreshLeeway() time.Duration {
+	if s.config.AccessTokenRefreshLeeway > 0 {
+		return time.Duration(s.config.AccessTokenRefreshLeeway) * time.Second
+	}
+	return defaultRefreshLeeway
+}
+
+func (s *AuthService) nearExpiry(tokens *Tokens) bool {
+	return !tokens.Expiry.IsZero() && time.Until(tokens.Expiry) < s.refreshLeeway()
+}
+
+// AccessToken returns the signed-in user's access token, refreshing it first
+// if it expires within the configured leeway.
+func (s *AuthService) AccessToken(r *http.Request) (string, error) {
+	return s.accessToken(r, "")
+}
+
+// RefreshAccessToken returns a token other than rejected, typically one an
+// API has just answered 401 to. If another request already replaced it, the
+// replacement is returned without calling the token endpoint again.
+func (s *AuthService) RefreshAccessToken(r *http.Request, rejected string) (string, error) {
+	return s.accessToken(r, rejected)
+}
+
+func (s *AuthService) accessToken(r *http.Request, rejected string) (string, error) {
+	session, err := s.sessionStore.GetStore().Get(r, s.config.SessionName)
+	if err != nil {
+		return "", err
+	}
+
+	tokens := tokensFromSession(session)
+	if tokens.AccessToken == "" {
+		return "", ErrNoAccessToken
+	}
+	if tokens.AccessToken != rejected && !s.nearExpiry(tokens) {
+		return tokens.AccessToken, nil
+	}
+
+	cookie, err := r.Cookie(s.config.SessionName)
+	if err != nil || session.ID == "" {
+		return "", ErrNoAccessToken
+	}
+
+	// Requests sharing a session in this process wait for one refresh; the
+	// Redis lock in refreshTokens does the same across replicas. The refresh
+	// outlives a caller that gives up, so it gets its own deadline and only
+	// the session cookie from the request that started it.
+	sessionID := session.ID
+	ctx := withoutCancel(r.Context())
+	value, err := s.refreshGroup.DoContext(r.Context(), sessionID, func() (any, error) {
+		ctx, cancel := context.WithTimeout(ctx, refreshLockTTL)
+		defer cancel()
+		return s.refreshTokens(ctx, sessionID, cookie, rejected)
+	})
+	if err != nil {
+		if errors.Is(err, ErrRefreshFailed) {
+			delete(session.Values, SessionUserIDKey)
+			clearTokens(session)
+		}
+		return "", err
+	}
+
+	refreshed := value.(*Tokens)
+	storeTokens(session, refreshed)
+	return refreshed.AccessToken, nil
+}
+
+func (s *AuthService) refreshTokens(ctx context.Context, sessionID string, cookie *http.Cookie, rejected string) (*Tokens, error) {
+	if s.tokenClient == nil {
+		return nil, errors.New("token refresh is not configured: token_url is empty")
+	}
+
+	// The request-scoped session may predate a refresh on another replica, so
+	// take the lock before reading the session straight from Redis.
+	lock, err := store.WaitLock(ctx, s.pool, refreshLockPrefix+sessionID, refreshLockTTL, refreshLockWait)
+	if err != nil {
+		return nil, fmt.Errorf("error taking token refresh lock: %w", err)
+	}
+	if lock != nil {
+		defer func() {
+			if err := lock.Release(); err != nil {
+				log.Printf("Failed to release token refresh lock: %v", err)
+			}
+		}()
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+	r.AddCookie(cookie)
+	session, err := s.sessionStore.GetStore().New(r, s.config.SessionName)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := tokensFromSession(session)
+	if tokens.AccessToken == "" {
+		return nil, ErrNoAccessToken
+	}
+	if tokens.AccessToken != rejected && !s.nearExpiry(tokens) {
+		return tokens, nil
+	}
+	if lock == nil {
+		return nil, errors.New("timed out waiting for another token refresh")
+	}
+
+	if tokens.RefreshToken == "" {
+		return nil, s.failRefresh(r, session, errors.New("no refresh token"))
+	}
+
+	token, err := s.tokenClient.Refresh(ctx, tokens.RefreshToken)
+	if err != nil {
+		if oauth.IsInvalidGrant(err) {
+			return nil, s.failRefresh(r, session, err)
+		}
+		// Server errors, network errors and timeouts leave the session
+		// alone; the next request will try again.
+		return nil, fmt.Errorf("error refreshing access token: %w", err)
+	}
+
+	// Keep the old refresh token unless the server rotated it.
+	refreshed := &Tokens{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = tokens.RefreshToken
+	}
+
+	storeTokens(session, refreshed)
+	if err := session.Save(r, discardResponseWriter{}); err != nil {
+		return nil, fmt.Errorf("error saving refreshed tokens: %w", err)
+	}
+
+	return refreshed, nil
+}
+
+// failRefresh signs the user out after the SSO server rejected the refresh.
+func (s *AuthService) failRefresh(r *http.Request, session *sessions.Session, cause error) error {
+	log.Printf("Signing user out after failed token refresh: %v", cause)
+
+	delete(session.Values, SessionUserIDKey)
+	clearTokens(session)
+	if err := session.Save(r, discardResponseWriter{}); err != nil {
+		log.Printf("Failed to save session after failed token refresh: %v", err)
+	}
+	return ErrRefreshFailed
+}
+
+// discardResponseWriter lets a session be written back to Redis outside of a
+// response. The session ID is unchanged, so the cookie does not need resending.
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header         { return http.Header{} }
+func (discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponseWriter) WriteHeader(int)             {}
+
+// detachedContext keeps the values of its parent but not its cancellation or
+// deadline, like context.WithoutCancel in Go 1.21.
+type detachedContext struct {
+	parent context.Context
+}
+
+func withoutCancel(parent context.Context) context.Context {
+	return detachedContext{parent: parent}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any         { return c.parent.Value(key) }
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/store"
+)
+
+// tokenServer answers refresh_token grants. Each refresh returns
+// "access-<n>" and rotates the refresh token; release, when set, holds the
+// response until it is closed, and status, when set, fails it without a body.
+type tokenServer struct {
+	*httptest.Server
+	refreshes atomic.Int32
+	reject    bool
+	status    atomic.Int32
+	release   chan struct{}
+}
+
+func newTokenServer(t *testing.T) *tokenServer {
+	t.Helper()
+
+	ts := &tokenServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ts.release != nil {
+			<-ts.release
+		}
+		n := ts.refreshes.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if ts.reject {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		if status := ts.status.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "access-" + strconv.Itoa(int(n)),
+			"refresh_token": "refresh-" + strconv.Itoa(int(n)),
+			"expires_in":    3600,
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// signInWithTokens signs user 1 in with tokens and returns the response
+// carrying the session cookie.
+func signInWithTokens(t *testing.T, s *testService, tokens *Tokens) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	if err := s.SignInUserWithTokens(recorder, httptest.NewRequest(http.MethodGet, "/", nil), 1, false, tokens); err != nil {
+		t.Fatal(err)
+	}
+	return recorder
+}
+
+func newRequest(signedIn *httptest.ResponseRecorder) *http.Request {
+	return withCookies(httptest.NewRequest(http.MethodGet, "/", nil), signedIn)
+}
+
+func TestCallbackOnlyStoresPostedTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		wantToken bool
+	}{
+		{"form_post", http.MethodPost, true},
+		{"query", http.MethodGet, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, nil)
+			handler := NewHandler(s.AuthService, &Config{
+				RootURL:       "/",
+				ResponseModes: []string{ResponseModeQuery, ResponseModeFormPost},
+			})
+
+			values := url.Values{
+				"id_token":      {s.signedInUser(t, "jti")},
+				"access_token":  {"access"},
+				"refresh_token": {"refresh"},
+				"expires_in":    {"3600"},
+			}
+			var r *http.Request
+			if tt.method == http.MethodPost {
+				r = httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(values.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				r = httptest.NewRequest(http.MethodGet, "/callback?"+values.Encode(), nil)
+			}
+			signedIn := serve(handler.Callback, r)
+			if signedIn.Code != http.StatusFound {
+				t.Fatalf("callback status = %d: %s", signedIn.Code, signedIn.Body)
+			}
+
+			token, err := s.AccessToken(newRequest(signedIn))
+			if tt.wantToken && (err != nil || token != "access") {
+				t.Errorf("AccessToken() = %q, %v, want access", token, err)
+			}
+			if !tt.wantToken && !errors.Is(err, ErrNoAccessToken) {
+				t.Errorf("AccessToken() = %q, %v, want ErrNoAccessToken", token, err)
+			}
+		})
+	}
+}
+
+func TestSignInUserWithTokens(t *testing.T) {
+	s := newTestService(t, nil)
+	signedIn := signInWithTokens(t, s, &Tokens{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)})
+
+	token, err := s.AccessToken(newRequest(signedIn))
+	if err != nil || token != "access" {
+		t.Errorf("AccessToken() = %q, %v, want access", token, err)
+	}
+
+	// Signing in again without tokens must not leave the old ones behind.
+	recorder := httptest.NewRecorder()
+	if err := s.SignInUser(recorder, newRequest(signedIn), 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AccessToken(newRequest(signedIn)); !errors.Is(err, ErrNoAccessToken) {
+		t.Errorf("AccessToken() after SignInUser error = %v, want ErrNoAccessToken", err)
+	}
+}
+
+func TestAccessTokenRefreshesOnceNearExpiry(t *testing.T) {
+	tokenServer := newTokenServer(t)
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.TokenURL = tokenServer.URL
+	})
+	signedIn := signInWithTokens(t, s, &Tokens{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(10 * time.Second)})
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		r := newRequest(signedIn)
+		go func(i int) {
+			defer wg.Done()
+			token, err := s.AccessToken(r)
+			if err != nil {
+				t.Error(err)
+			}
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	if got := tokenServer.refreshes.Load(); got != 1 {
+		t.Errorf("refreshes = %d, want 1", got)
+	}
+	for _, token := range tokens {
+		if token != "access-1" {
+			t.Errorf("AccessToken() = %q, want access-1", token)
+		}
+	}
+
+	// The rotated tokens were saved to Redis for later requests.
+	session, err := s.sessionStore.GetStore().New(newRequest(signedIn), s.config.SessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := tokensFromSession(session); stored.AccessToken != "access-1" || stored.RefreshToken != "refresh-1" {
+		t.Errorf("stored tokens = %+v", stored)
+	}
+}
+
+func TestRefreshAccessTokenReplacesRejectedToken(t *testing.T) {
+	tokenServer := newTokenServer(t)
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.TokenURL = tokenServer.URL
+	})
+	signedIn := signInWithTokens(t, s, &Tokens{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(time.Hour)})
+
+	token, err := s.RefreshAccessToken(newRequest(signedIn), "access-0")
+	if err != nil || token != "access-1" {
+		t.Fatalf("RefreshAccessToken() = %q, %v, want access-1", token, err)
+	}
+
+	// A second caller holding the same rejected token gets the replacement.
+	token, err = s.RefreshAccessToken(newRequest(signedIn), "access-0")
+	if err != nil || token != "access-1" || tokenServer.refreshes.Load() != 1 {
+		t.Errorf("RefreshAccessToken() = %q, %v after %d refreshes", token, err, tokenServer.refreshes.Load())
+	}
+}
+
+func TestRejectedRefreshSignsUserOut(t *testing.T) {
+	tokenServer := newTokenServer(t)
+	tokenServer.reject = true
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.TokenURL = tokenServer.URL
+	})
+	signedIn := signInWithTokens(t, s, &Tokens{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(time.Second)})
+
+	if _, err := s.AccessToken(newRequest(signedIn)); !errors.Is(err, ErrRefreshFailed) {
+		t.Fatalf("AccessToken() error = %v, want ErrRefreshFailed", err)
+	}
+	if s.IsUserSignedIn(newRequest(signedIn)) {
+		t.Error("user is still signed in after a rejected refresh")
+	}
+}
+
+func TestFailedRefreshKeepsSession(t *testing.T) {
+	tokenServer := newTokenServer(t)
+	tokenServer.status.Store(http.StatusServiceUnavailable)
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.TokenURL = tokenServer.URL
+	})
+	signedIn := signInWithTokens(t, s, &Tokens{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(time.Second)})
+
+	_, err := s.AccessToken(newRequest(signedIn))
+	if err == nil || errors.Is(err, ErrRefreshFailed) {
+		t.Fatalf("AccessToken() error = %v, want a retryable error", err)
+	}
+	if !s.IsUserSignedIn(newRequest(signedIn)) {
+		t.Fatal("user was signed out after a server error")
+	}
+
+	tokenServer.status.Store(0)
+	if token, err := s.AccessToken(newRequest(signedIn)); err != nil || token != "access-2" {
+		t.Errorf("AccessToken() after the server recovered = %q, %v, want access-2", token, err)
+	}
+}
+
+func TestRefreshOutlivesCanceledCaller(t *testing.T) {
+	tokenServer := newTokenServer(t)
+	tokenServer.release = make(chan struct{})
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.TokenURL = tokenServer.URL
+	})
+	signedIn := signInWithTokens(t, s, &Tokens{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(time.Second)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstRequest, secondRequest := newRequest(signedIn).WithContext(ctx), newRequest(signedIn)
+	first := make(chan error, 1)
+	go func() {
+		_, err := s.AccessToken(firstRequest)
+		first <- err
+	}()
+	second := make(chan string, 1)
+	go func() {
+		// Give the first caller time to start the refresh.
+		time.Sleep(50 * time.Millisecond)
+		token, _ := s.AccessToken(secondRequest)
+		second <- token
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller error = %v, want context.Canceled", err)
+	}
+
+	close(tokenServer.release)
+	if token := <-second; token != "access-1" {
+		t.Errorf("waiting caller got %q, want access-1", token)
+	}
+}
+
+// poolessStore hides the Redis pool of a session store.
+type poolessStore struct {
+	store.SessionStore
+}
+
+func TestSessionStoreWithoutPool(t *testing.T) {
+	s := newTestService(t, nil)
+	service, err := NewAuthService(s.repo, s.config, poolessStore{s.sessionStore})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.RevokeUserSessions(1); !errors.Is(err, store.ErrNoPool) {
+		t.Errorf("RevokeUserSessions() error = %v, want ErrNoPool", err)
+	}
+
+	// Sign-in still works; the session just cannot be revoked early.
+	recorder := httptest.NewRecorder()
+	if err := service.SignInUser(recorder, httptest.NewRequest(http.MethodGet, "/", nil), 1, false); err != nil {
+		t.Errorf("SignInUser() error = %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNoSessionUser = errors.New("user ID not found in session")
+
+// UserIDResolver maps a verified ID token to the application's own user ID,
+// already encoded as the string kept in the session. It replaces
+// UserRepository.FindByJTI for applications whose users are not models.User.
+type UserIDResolver func(jti string, claims jwt.MapClaims) (string, error)
+
+// SetUserIDResolver makes callbacks resolve users with resolver. Sessions then
+// hold the encoded ID instead of a uint, and just-in-time provisioning, which
+// writes models.User rows, is skipped.
+func (s *AuthService) SetUserIDResolver(resolver UserIDResolver) {
+	s.userIDResolver = resolver
+}
+
+// SessionUserKey returns the signed-in user's ID as stored in the session:
+// the decimal ID of a models.User, or the string from a UserIDResolver.
+func (s *AuthService) SessionUserKey(r *http.Request) (string, error) {
+	session, err := s.sessionStore.GetStore().Get(r, s.config.SessionName)
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := UserKey(session.Values[SessionUserIDKey])
+	if !ok {
+		return "", ErrNoSessionUser
+	}
+	return key, nil
+}
+
+// UserKey converts a session's stored user ID to its string form.
+func UserKey(value any) (string, bool) {
+	switch id := value.(type) {
+	case uint:
+		return strconv.FormatUint(uint64(id), 10), true
+	case string:
+		return id, id != ""
+	}
+	return "", false
+}
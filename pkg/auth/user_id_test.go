@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+func TestUserKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  any
+		want   string
+		wantOK bool
+	}{
+		{"uint", uint(42), "42", true},
+		{"string", "7f7c5b1e-uuid", "7f7c5b1e-uuid", true},
+		{"empty string", "", "", false},
+		{"int", 42, "", false},
+		{"missing", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := UserKey(tt.value)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("UserKey(%v) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestUserIDResolver(t *testing.T) {
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.ProvisionUsers = true
+	})
+	var resolved []string
+	s.SetUserIDResolver(func(jti string, claims jwt.MapClaims) (string, error) {
+		resolved = append(resolved, jti)
+		return "user-" + claims["sub"].(string), nil
+	})
+	handler := NewHandler(s.AuthService, &Config{RootURL: "/", SignInURL: "/signin"})
+
+	signedIn := s.signIn(t, handler)
+	if len(resolved) != 1 {
+		t.Fatalf("resolver called %d times, want 1", len(resolved))
+	}
+
+	r := withCookies(httptest.NewRequest(http.MethodGet, "/", nil), signedIn)
+	key, err := s.SessionUserKey(r)
+	if err != nil || key != "user-user" {
+		t.Fatalf("SessionUserKey() = %q, %v, want user-user", key, err)
+	}
+	if _, err := s.GetUserIDFromSession(r); !errors.Is(err, ErrNoSessionUser) {
+		t.Errorf("GetUserIDFromSession() error = %v, want ErrNoSessionUser", err)
+	}
+	// Provisioning writes models.User rows, which resolved users are not.
+	if len(s.repo.upserts) != 0 {
+		t.Errorf("upserted %+v for a resolved user", s.repo.upserts)
+	}
+
+	if err := s.RevokeSessionsByKey("user-user"); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsUserSignedIn(withCookies(httptest.NewRequest(http.MethodGet, "/", nil), signedIn)) {
+		t.Error("user is still signed in after their sessions were revoked")
+	}
+}
+
+func TestUserIDResolverFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		err  error
+	}{
+		{"error", "", errors.New("no such user")},
+		{"empty ID", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, nil)
+			s.SetUserIDResolver(func(string, jwt.MapClaims) (string, error) {
+				return tt.key, tt.err
+			})
+
+			params := map[string]string{"id_token": s.callbackToken(t, nil)}
+			if result, err := s.ProcessCallbackContext(context.Background(), params); err == nil {
+				t.Errorf("ProcessCallbackContext() = %+v, want an error", result)
+			}
+		})
+	}
+}
+
+func TestSessionUserKeyWithoutSession(t *testing.T) {
+	s := newTestService(t, nil)
+
+	if _, err := s.SessionUserKey(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrNoSessionUser) {
+		t.Errorf("SessionUserKey() error = %v, want ErrNoSessionUser", err)
+	}
+}
+
+func TestUserJSON(t *testing.T) {
+	s := newTestService(t, nil)
+	handler := NewHandler(s.AuthService, &Config{RootURL: "/", SignInURL: "/signin"})
+	signedIn := s.signIn(t, handler)
+
+	tests := []struct {
+		name   string
+		render func(*models.User) any
+		want   map[string]any
+	}{
+		{"default", nil, map[string]any{"id": float64(1), "email": "user@example.com", "name": "User"}},
+		{"custom", func(user *models.User) any {
+			return map[string]any{"userId": user.ID, "displayName": user.Name}
+		}, map[string]any{"userId": float64(1), "displayName": "User"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler.SetUserJSON(tt.render)
+
+			recorder := serve(handler.User, withCookies(httptest.NewRequest(http.MethodGet, "/user", nil), signedIn))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("body = %v, want %v", got, tt.want)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("body = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+	"github.com/jarvisconsulting/sso-client-go/pkg/store"
+)
+
+const (
+	UserRevalidationRequest  = "request"
+	UserRevalidationInterval = "interval"
+	UserRevalidationPush     = "push"
+
+	defaultUserRevalidationInterval = 5 * time.Minute
+)
+
+var ErrUserDisabled = errors.New("user is disabled")
+
+// UserStatusFunc reports whether the user with the given session key (see
+// SessionUserKey) may still sign in. It replaces the models.User check for
+// applications that set a UserIDResolver.
+type UserStatusFunc func(userKey string) (active bool, err error)
+
+// uncachedUserFinder is implemented by repositories with a user cache, such
+// as ssoclient.UserRepository. Status checks read past the cache so a
+// disabled user is not let in on a stale copy.
+type uncachedUserFinder interface {
+	FindByIDUncachedContext(ctx context.Context, id uint) (*models.User, error)
+}
+
+func (s *AuthService) SetUserStatusFunc(status UserStatusFunc) {
+	s.userStatus = status
+}
+
+// CheckSessionUser applies UserRevalidation to the user signed in to a
+// session. A user who is no longer allowed in is signed out everywhere and
+// ErrUserDisabled is returned. If the user cannot be loaded the error is
+// logged and the session kept.
+func (s *AuthService) CheckSessionUser(userKey string) error {
+	return s.CheckSessionUserContext(context.Background(), userKey)
+}
+
+func (s *AuthService) CheckSessionUserContext(ctx context.Context, userKey string) error {
+	var active bool
+	var err error
+
+	switch s.config.UserRevalidation {
+	case UserRevalidationRequest:
+		active, err = s.userActive(ctx, userKey)
+	case UserRevalidationInterval:
+		active, err = s.cachedUserActive(ctx, userKey)
+	default:
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to check status of user %s: %v", userKey, err)
+		return nil
+	}
+	if active {
+		return nil
+	}
+
+	s.metrics.IncCounter("disabled_user_sessions_rejected", nil)
+	if err := s.RevokeSessionsByKeyContext(ctx, userKey); err != nil {
+		log.Printf("Failed to sign out disabled user %s: %v", userKey, err)
+	}
+	return ErrUserDisabled
+}
+
+// checkSignIn refuses a callback for a disabled user whenever revalidation
+// is configured.
+func (s *AuthService) checkSignIn(ctx context.Context, userKey string) error {
+	if s.config.UserRevalidation == "" {
+		return nil
+	}
+
+	active, err := s.userActive(ctx, userKey)
+	if err != nil {
+		log.Printf("Failed to check status of user %s: %v", userKey, err)
+		return nil
+	}
+	if !active {
+		return fmt.Errorf("error signing in user %s: %w", userKey, ErrUserDisabled)
+	}
+	return nil
+}
+
+// userActive loads the user's status from the UserStatusFunc or, for
+// models.User, from the database. A deleted row counts as disabled.
+func (s *AuthService) userActive(ctx context.Context, userKey string) (bool, error) {
+	if s.userStatus != nil {
+		return s.userStatus(userKey)
+	}
+	// Custom user types have no status unless a UserStatusFunc provides one.
+	if s.userIDResolver != nil {
+		return true, nil
+	}
+
+	id, err := strconv.ParseUint(userKey, 10, 0)
+	if err != nil {
+		return false, fmt.Errorf("invalid user ID %q: %w", userKey, err)
+	}
+
+	var user *models.User
+	if finder, ok := s.userRepo.(uncachedUserFinder); ok {
+		user, err = finder.FindByIDUncachedContext(ctx, uint(id))
+	} else {
+		user, err = s.userRepo.FindByIDContext(ctx, uint(id))
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !user.Disabled(), nil
+}
+
+func (s *AuthService) cachedUserActive(ctx context.Context, userKey string) (bool, error) {
+	active, found, err := store.GetUserStatus(ctx, s.pool, userKey)
+	if err != nil {
+		log.Printf("Failed to read cached status of user %s: %v", userKey, err)
+	} else if found {
+		return active, nil
+	}
+
+	active, err = s.userActive(ctx, userKey)
+	if err != nil {
+		return false, err
+	}
+
+	interval := defaultUserRevalidationInterval
+	if s.config.UserRevalidationInterval > 0 {
+		interval = time.Duration(s.config.UserRevalidationInterval) * time.Second
+	}
+	if err := store.SetUserStatus(ctx, s.pool, userKey, active, interval); err != nil {
+		log.Printf("Failed to cache status of user %s: %v", userKey, err)
+	}
+	return active, nil
+}
+
+// forgetUserStatus drops the cached status, so a user who was re-enabled and
+// signs in again is not rejected by a stale entry.
+func (s *AuthService) forgetUserStatus(ctx context.Context, userKey string) {
+	if s.config.UserRevalidation != UserRevalidationInterval {
+		return
+	}
+	if err := store.ClearUserStatus(ctx, s.pool, userKey); err != nil {
+		log.Printf("Failed to clear cached status of user %s: %v", userKey, err)
+	}
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+// setUser stores user 1 with the given status, or removes it when user is
+// nil.
+func (s *testService) setUser(user *models.User) {
+	s.repo.mu.Lock()
+	defer s.repo.mu.Unlock()
+
+	if user == nil {
+		delete(s.repo.users, 1)
+		return
+	}
+	user.ID = 1
+	s.repo.users[1] = user
+}
+
+func TestCheckSessionUser(t *testing.T) {
+	now := time.Now()
+	users := []struct {
+		name     string
+		user     *models.User
+		disabled bool
+	}{
+		{"active", &models.User{}, false},
+		{"inactive", &models.User{Active: new(bool)}, true},
+		{"locked", &models.User{LockedAt: &now}, true},
+		{"deleted", &models.User{DeletedAt: &now}, true},
+		{"row gone", nil, true},
+	}
+	modes := []struct {
+		mode   string
+		checks bool
+	}{
+		{UserRevalidationRequest, true},
+		{UserRevalidationInterval, true},
+		{UserRevalidationPush, false},
+		{"", false},
+	}
+
+	for _, mode := range modes {
+		for _, tt := range users {
+			t.Run(mode.mode+"/"+tt.name, func(t *testing.T) {
+				s := newTestService(t, func(cfg *config.Config) {
+					cfg.UserRevalidation = mode.mode
+				})
+				s.setUser(tt.user)
+
+				err := s.CheckSessionUser("1")
+				wantDisabled := mode.checks && tt.disabled
+				if errors.Is(err, ErrUserDisabled) != wantDisabled {
+					t.Fatalf("CheckSessionUser() error = %v, want disabled %v", err, wantDisabled)
+				}
+				want := 0
+				if wantDisabled {
+					want = 1
+				}
+				if got := s.metrics.count("disabled_user_sessions_rejected"); got != want {
+					t.Errorf("rejected metric = %d, want %d", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestCheckSessionUserReadsPastUserCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		cached       *models.User
+		stored       *models.User
+		wantDisabled bool
+	}{
+		{"disabled since cached", &models.User{ID: 1}, &models.User{Active: new(bool)}, true},
+		{"enabled since cached", &models.User{ID: 1, Active: new(bool)}, &models.User{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, func(cfg *config.Config) {
+				cfg.UserRevalidation = UserRevalidationRequest
+			})
+			s.setUser(tt.stored)
+			s.repo.cached[1] = tt.cached
+
+			if err := s.CheckSessionUser("1"); errors.Is(err, ErrUserDisabled) != tt.wantDisabled {
+				t.Errorf("CheckSessionUser() error = %v, want disabled %v", err, tt.wantDisabled)
+			}
+		})
+	}
+}
+
+func TestIntervalRevalidationCachesStatus(t *testing.T) {
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.UserRevalidation = UserRevalidationInterval
+		cfg.UserRevalidationInterval = 60
+	})
+	s.setUser(&models.User{})
+
+	if err := s.CheckSessionUser("1"); err != nil {
+		t.Fatal(err)
+	}
+	s.setUser(&models.User{Active: new(bool)})
+	if err := s.CheckSessionUser("1"); err != nil {
+		t.Errorf("CheckSessionUser() within the interval error = %v, want the cached answer", err)
+	}
+
+	s.redis.FastForward(61 * time.Second)
+	if err := s.CheckSessionUser("1"); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("CheckSessionUser() after the interval error = %v, want ErrUserDisabled", err)
+	}
+}
+
+func TestRevokeUserSessionsDropsCachedStatus(t *testing.T) {
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.UserRevalidation = UserRevalidationInterval
+		cfg.UserRevalidationInterval = 60
+	})
+	s.setUser(&models.User{})
+	if err := s.CheckSessionUser("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.setUser(&models.User{Active: new(bool)})
+	if err := s.RevokeUserSessionsContext(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSessionUser("1"); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("CheckSessionUser() after a push error = %v, want ErrUserDisabled", err)
+	}
+}
+
+func TestSignInRefusedForDisabledUser(t *testing.T) {
+	for _, mode := range []string{UserRevalidationRequest, UserRevalidationInterval, UserRevalidationPush} {
+		t.Run(mode, func(t *testing.T) {
+			s := newTestService(t, func(cfg *config.Config) {
+				cfg.UserRevalidation = mode
+			})
+			handler := NewHandler(s.AuthService, &Config{RootURL: "/", SignInURL: "/signin"})
+			idToken := s.signedInUser(t, "jti")
+			s.setUser(&models.User{Email: "user@example.com", Active: new(bool)})
+
+			recorder := serve(handler.Callback, httptest.NewRequest(http.MethodGet, "/callback?id_token="+idToken, nil))
+			if recorder.Code != http.StatusForbidden {
+				t.Errorf("callback status = %d, want %d", recorder.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
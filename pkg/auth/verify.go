@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/jwe"
+	"github.com/jarvisconsulting/sso-client-go/pkg/keys"
+)
+
+const retiredKeyMetric = "id_token_retired_key_verifications"
+
+// decryptIDToken unwraps a nested JWE into the signed token inside it. Plain
+// signed tokens pass through unless encryption is required.
+func (s *AuthService) decryptIDToken(idToken string) (string, error) {
+	if !jwe.IsEncrypted(idToken) {
+		if s.config.RequireEncryptedIDToken {
+			return "", errors.New("invalid token: encrypted ID token required")
+		}
+		return idToken, nil
+	}
+
+	if s.decrypter == nil {
+		return "", errors.New("invalid token: encrypted ID token received but no decryption key is configured")
+	}
+
+	signed, err := s.decrypter.DecryptNested(idToken)
+	if err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+	return signed, nil
+}
+
+func (s *AuthService) parseIDToken(idToken string) (*jwt.Token, error) {
+	return s.parseToken(idToken)
+}
+
+// parseToken verifies a token signed by the SSO server against the cached
+// signing keys.
+//
+// When the token names its key in the kid header only that key is tried.
+// Otherwise every key inside the validity window is tried, newest first. In
+// both cases the cache is reloaded once before giving up, in case the SSO
+// server rotated its key since the last refresh.
+func (s *AuthService) parseToken(idToken string, options ...jwt.ParserOption) (*jwt.Token, error) {
+	kid, alg := peekHeader(idToken)
+	if !s.algorithmAllowed(alg) {
+		return nil, fmt.Errorf("invalid token: signing method %q is not allowed", alg)
+	}
+
+	signingKeys, err := s.keyProvider.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("error getting signing keys: %w", err)
+	}
+
+	candidates := s.candidateKeys(signingKeys, kid, alg)
+	if len(candidates) == 0 {
+		signingKeys, err = s.keyProvider.Refresh(true)
+		if err != nil {
+			return nil, fmt.Errorf("error getting signing keys: %w", err)
+		}
+		candidates = s.candidateKeys(signingKeys, kid, alg)
+		if len(candidates) == 0 && kid != "" {
+			return nil, fmt.Errorf("invalid token: no active signing key matches kid %q", kid)
+		}
+		if len(candidates) == 0 {
+			return nil, errors.New("invalid token: no active signing key")
+		}
+	}
+
+	token, key, err := s.verifyWithKeys(idToken, candidates, options)
+	if err != nil && kid == "" && errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		refreshed, refreshErr := s.keyProvider.Refresh(true)
+		if refreshErr != nil || refreshed[0].KID == signingKeys[0].KID {
+			return nil, err
+		}
+		signingKeys = refreshed
+		token, key, err = s.verifyWithKeys(idToken, s.candidateKeys(signingKeys, kid, alg), options)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key.KID != signingKeys[0].KID && !key.CreatedAt.IsZero() {
+		log.Printf("ID token verified with retired signing key %s", key.KID)
+		s.metrics.IncCounter(retiredKeyMetric, map[string]string{"kid": key.KID})
+	}
+
+	return token, nil
+}
+
+func (s *AuthService) algorithmAllowed(alg string) bool {
+	for _, allowed := range keys.AllowedAlgorithms(s.config.SigningAlgorithms) {
+		if alg == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateKeys narrows the cached keys to those a token may be verified with.
+// The newest key is always active unless its certificate has expired; older
+// keys stay active for the configured validity window after they were created,
+// or indefinitely when their creation time is unknown, as for JWKS and file
+// keys. Keys whose type does not fit the token's alg header are skipped.
+func (s *AuthService) candidateKeys(signingKeys []*keys.Key, kid, alg string) []*keys.Key {
+	window := time.Duration(s.config.SigningKeyValidityWindow) * time.Hour
+	now := time.Now()
+	candidates := make([]*keys.Key, 0, len(signingKeys))
+
+	for i, key := range signingKeys {
+		if key.Expired(now) {
+			continue
+		}
+		if i > 0 && window > 0 && !key.CreatedAt.IsZero() && now.Sub(key.CreatedAt) > window {
+			continue
+		}
+		if kid != "" && !key.Matches(kid) {
+			continue
+		}
+		if !key.Accepts(alg) {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+
+	return candidates
+}
+
+// verifyWithKeys tries each key in turn. The parser only accepts the
+// configured asymmetric algorithms, so "none" and HMAC tokens are rejected
+// before any key is consulted.
+func (s *AuthService) verifyWithKeys(idToken string, signingKeys []*keys.Key, options []jwt.ParserOption) (*jwt.Token, *keys.Key, error) {
+	options = append([]jwt.ParserOption{jwt.WithValidMethods(keys.AllowedAlgorithms(s.config.SigningAlgorithms))}, options...)
+	parser := jwt.NewParser(options...)
+	lastErr := errors.New("no signing key accepts the token algorithm")
+
+	for _, key := range signingKeys {
+		key := key
+		token, err := parser.Parse(idToken, func(token *jwt.Token) (any, error) {
+			if !key.Accepts(token.Method.Alg()) {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return key.PublicKey, nil
+		})
+		if err == nil && token.Valid {
+			return token, key, nil
+		}
+
+		lastErr = err
+		// Only a signature mismatch can be fixed by trying an older key;
+		// expired or malformed tokens fail the same way against every key.
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			break
+		}
+	}
+
+	return nil, nil, fmt.Errorf("invalid token: %w", lastErr)
+}
+
+// peekHeader reads the kid and alg headers without verifying the token.
+func peekHeader(idToken string) (kid, alg string) {
+	token, _, err := jwt.NewParser().ParseUnverified(idToken, jwt.MapClaims{})
+	if err != nil {
+		return "", ""
+	}
+
+	kid, _ = token.Header["kid"].(string)
+	alg, _ = token.Header["alg"].(string)
+	return kid, alg
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/keys"
+)
+
+func TestParseTokenUsesKeyNamedByKID(t *testing.T) {
+	s := newTestService(t, nil)
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	oldKID := s.repo.addKey(t, oldKey, time.Hour)
+	s.repo.addKey(t, newKey, 0)
+
+	token := sign(t, jwt.SigningMethodRS256, oldKey, oldKID, validClaims("a"))
+	if _, err := s.parseToken(token); err != nil {
+		t.Fatalf("parseToken() error = %v", err)
+	}
+	if got := s.metrics.count(retiredKeyMetric); got != 1 {
+		t.Errorf("retired key metric = %d, want 1", got)
+	}
+
+	forged := sign(t, jwt.SigningMethodRS256, newKey, oldKID, validClaims("b"))
+	if _, err := s.parseToken(forged); err == nil {
+		t.Error("parseToken() accepted a token signed by a key other than its kid")
+	}
+}
+
+func TestParseTokenWithoutKIDTriesKeysInWindow(t *testing.T) {
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.SigningKeyValidityWindow = 24
+	})
+	expiredKey, olderKey, newestKey := newRSAKey(t), newRSAKey(t), newRSAKey(t)
+	s.repo.addKey(t, expiredKey, 48*time.Hour)
+	s.repo.addKey(t, olderKey, time.Hour)
+	s.repo.addKey(t, newestKey, 0)
+
+	if _, err := s.parseToken(sign(t, jwt.SigningMethodRS256, newestKey, "", validClaims("a"))); err != nil {
+		t.Fatalf("parseToken() with newest key error = %v", err)
+	}
+	if got := s.metrics.count(retiredKeyMetric); got != 0 {
+		t.Errorf("retired key metric = %d after newest key, want 0", got)
+	}
+
+	if _, err := s.parseToken(sign(t, jwt.SigningMethodRS256, olderKey, "", validClaims("b"))); err != nil {
+		t.Fatalf("parseToken() with key inside window error = %v", err)
+	}
+	if got := s.metrics.count(retiredKeyMetric); got != 1 {
+		t.Errorf("retired key metric = %d, want 1", got)
+	}
+
+	if _, err := s.parseToken(sign(t, jwt.SigningMethodRS256, expiredKey, "", validClaims("c"))); err == nil {
+		t.Error("parseToken() accepted a key outside the validity window")
+	}
+}
+
+func TestParseTokenNewestKeyIgnoresWindow(t *testing.T) {
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.SigningKeyValidityWindow = 1
+	})
+	key := newRSAKey(t)
+	kid := s.repo.addKey(t, key, 72*time.Hour)
+
+	if _, err := s.parseToken(sign(t, jwt.SigningMethodRS256, key, kid, validClaims("a"))); err != nil {
+		t.Fatalf("parseToken() error = %v", err)
+	}
+}
+
+func TestParseTokenRejectsUnknownKID(t *testing.T) {
+	s := newTestService(t, nil)
+	s.repo.addKey(t, newRSAKey(t), 0)
+
+	_, err := s.parseToken(sign(t, jwt.SigningMethodRS256, newRSAKey(t), "99", validClaims("a")))
+	if err == nil || !strings.Contains(err.Error(), `kid "99"`) {
+		t.Errorf("parseToken() error = %v, want unknown kid", err)
+	}
+}
+
+func TestParseTokenRejectsExpiredToken(t *testing.T) {
+	s := newTestService(t, nil)
+	key := newRSAKey(t)
+	kid := s.repo.addKey(t, key, 0)
+
+	claims := validClaims("a")
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	if _, err := s.parseToken(sign(t, jwt.SigningMethodRS256, key, kid, claims)); err == nil {
+		t.Error("parseToken() accepted an expired token")
+	}
+}
+
+func TestCandidateKeysKeepsKeysWithoutCreationTime(t *testing.T) {
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.SigningKeyValidityWindow = 1
+	})
+	published := []*keys.Key{
+		{KID: "current", PublicKey: &newRSAKey(t).PublicKey},
+		{KID: "published", PublicKey: &newRSAKey(t).PublicKey},
+		{KID: "old", PublicKey: &newRSAKey(t).PublicKey, CreatedAt: time.Now().Add(-2 * time.Hour)},
+	}
+
+	candidates := s.candidateKeys(published, "", "RS256")
+	if len(candidates) != 2 || candidates[1].KID != "published" {
+		t.Errorf("candidateKeys() = %d keys, want current and published", len(candidates))
+	}
+}
+
+func TestParseTokenJWKSKeysWithOldCertificates(t *testing.T) {
+	var published []map[string]any
+	var roots []byte
+	signers := map[string]*rsa.PrivateKey{}
+	for _, kid := range []string{"current", "previous"} {
+		key := newRSAKey(t)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(len(signers) + 1)),
+			Subject:      pkix.Name{CommonName: kid},
+			NotBefore:    time.Now().Add(-72 * time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers[kid] = key
+		roots = append(roots, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+		published = append(published, map[string]any{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			"x5c": []string{base64.StdEncoding.EncodeToString(der)},
+		})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": published})
+	}))
+	t.Cleanup(server.Close)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, roots, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestService(t, func(cfg *config.Config) {
+		cfg.SigningKeySource = keys.SourceJWKS
+		cfg.SigningKeyJWKSURL = server.URL
+		cfg.SigningKeyCAFile = caFile
+		cfg.SigningKeyValidityWindow = 24
+	})
+
+	// Certificates issued before the validity window do not retire the keys.
+	for kid, key := range signers {
+		if _, err := s.parseToken(sign(t, jwt.SigningMethodRS256, key, "", validClaims(kid))); err != nil {
+			t.Errorf("parseToken() with the %s key error = %v", kid, err)
+		}
+	}
+	if got := s.metrics.count(retiredKeyMetric); got != 0 {
+		t.Errorf("retired key metric = %d, want 0", got)
+	}
+}
+
+func TestParseTokenAlgorithms(t *testing.T) {
+	rsaKey, ecKey, edKey := newRSAKey(t), newECKey(t), newEd25519Key(t)
+
+	tests := []struct {
+		name    string
+		allowed []string
+		method  jwt.SigningMethod
+		key     crypto.Signer
+		wantErr bool
+	}{
+		{"RS256", nil, jwt.SigningMethodRS256, rsaKey, false},
+		{"PS256", nil, jwt.SigningMethodPS256, rsaKey, false},
+		{"ES256", nil, jwt.SigningMethodES256, ecKey, false},
+		{"EdDSA", nil, jwt.SigningMethodEdDSA, edKey, false},
+		{"allowed", []string{"ES256", "EdDSA"}, jwt.SigningMethodEdDSA, edKey, false},
+		{"not allowed", []string{"ES256", "EdDSA"}, jwt.SigningMethodRS256, rsaKey, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, func(cfg *config.Config) {
+				cfg.SigningAlgorithms = tt.allowed
+			})
+			kid := s.repo.addKey(t, tt.key, 0)
+
+			_, err := s.parseToken(sign(t, tt.method, tt.key, kid, validClaims("a")))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTokenRejectsUnsignedAndHMACTokens(t *testing.T) {
+	s := newTestService(t, nil)
+	kid := s.repo.addKey(t, newRSAKey(t), 0)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims("a")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims("b"))
+	hmac.Header["kid"] = kid
+	signed, err := hmac.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for alg, token := range map[string]string{"none": unsigned, "HS256": signed} {
+		if _, err := s.parseToken(token); err == nil {
+			t.Errorf("parseToken() accepted a %s token", alg)
+		}
+	}
+}
@@ -1,24 +1,160 @@
 package config
 
 type Config struct {
+	// Optional: "production" makes Validate and Load refuse the secrets
+	// DefaultConfig ships with.
+	Environment string `json:"environment,omitempty" validate:"omitempty,oneof=development production"`
+
 	// Core SSO URLs
 	CallbackURL string `json:"callback_url" validate:"required"`
 	SignInURL   string `json:"sign_in_url" validate:"required"`
 	RootURL     string `json:"root_url" validate:"required"`
 
+	// Optional: how the SSO server may deliver the ID token to the callback.
+	// Any of "query", "form_post" and "fragment"; defaults to query only.
+	CallbackResponseModes []string `json:"callback_response_modes,omitempty" validate:"omitempty,dive,oneof=query form_post fragment"`
+
+	// Optional: client credentials and token endpoint, used to refresh the
+	// access tokens stored in the session.
+	ClientID                 string `json:"client_id,omitempty"`
+	ClientSecret             string `json:"client_secret,omitempty"`
+	TokenURL                 string `json:"token_url,omitempty" validate:"omitempty,url"`
+	AccessTokenRefreshLeeway int    `json:"access_token_refresh_leeway,omitempty" validate:"omitempty,min=0"` // seconds before expiry to refresh
+
+	// Optional: client_credentials grant for service-to-service calls. Setting
+	// ClientAssertionKey (PEM private key) authenticates with private_key_jwt
+	// instead of ClientSecret.
+	ClientCredentialsScopes   []string `json:"client_credentials_scopes,omitempty"`
+	ClientCredentialsAudience string   `json:"client_credentials_audience,omitempty"`
+	ClientAssertionKey        string   `json:"client_assertion_key,omitempty"`
+	ClientAssertionKeyID      string   `json:"client_assertion_key_id,omitempty"`
+
+	// Optional: bearer token validation for RequireBearer. "jwt" (default)
+	// verifies tokens locally; "introspection" asks IntrospectionURL and, if it
+	// is unreachable, either rejects the token ("fail_closed", default) or falls
+	// back to local JWT verification ("fail_open").
+	BearerAudience                string `json:"bearer_audience,omitempty"` // required aud in bearer tokens
+	BearerValidationMode          string `json:"bearer_validation_mode,omitempty" validate:"omitempty,oneof=jwt introspection"`
+	IntrospectionURL              string `json:"introspection_url,omitempty" validate:"required_if=BearerValidationMode introspection,omitempty,url"`
+	IntrospectionFailurePolicy    string `json:"introspection_failure_policy,omitempty" validate:"omitempty,oneof=fail_closed fail_open"`
+	IntrospectionNegativeCacheTTL int    `json:"introspection_negative_cache_ttl,omitempty" validate:"omitempty,min=1"` // seconds inactive tokens stay cached
+
+	// Optional: device authorization grant for CLI tools.
+	DeviceAuthorizationURL string   `json:"device_authorization_url,omitempty" validate:"omitempty,url"`
+	DeviceScopes           []string `json:"device_scopes,omitempty"`           // defaults to openid
+	DeviceCredentialsFile  string   `json:"device_credentials_file,omitempty"` // where DeviceLogin saves tokens
+
+	// Optional: RP-initiated logout. When EndSessionURL is set, sign-out also
+	// ends the SSO session and the provider redirects to PostLogoutRedirectURL.
+	EndSessionURL         string `json:"end_session_url,omitempty" validate:"omitempty,url"`
+	PostLogoutRedirectURL string `json:"post_logout_redirect_url,omitempty" validate:"omitempty,url"`
+
+	// Optional: OIDC Front-Channel Logout. The SSO server frames the logout page
+	// from its own site, so enabling it sends the session cookie with
+	// SameSite=None and Secure. The origin is the only one allowed to frame the
+	// logout page; defaults to the origin of SignInURL.
+	EnableFrontChannelLogout bool   `json:"enable_front_channel_logout"`
+	FrontChannelLogoutOrigin string `json:"front_channel_logout_origin,omitempty" validate:"omitempty,url"`
+
+	// Optional: just-in-time user provisioning. On sign-in the users row is
+	// created or updated from UserInfoURL, when the callback delivered an access
+	// token, or else from the ID token claims. UserClaimMapping maps user fields
+	// ("email", "name") to claim names; unmapped fields read the claim of the
+	// same name.
+	ProvisionUsers   bool              `json:"provision_users"`
+	UserInfoURL      string            `json:"user_info_url,omitempty" validate:"omitempty,url"`
+	UserClaimMapping map[string]string `json:"user_claim_mapping,omitempty" validate:"omitempty,dive,keys,oneof=email name,endkeys,required"`
+
+	// Optional: SCIM 2.0 provisioning server. The identity provider
+	// authenticates with SCIMBearerToken or, when it is empty, with an access
+	// token carrying SCIMScope (default "scim").
+	SCIMBearerToken string `json:"scim_bearer_token,omitempty"`
+	SCIMScope       string `json:"scim_scope,omitempty"`
+	SCIMBaseURL     string `json:"scim_base_url,omitempty" validate:"omitempty,url"` // absolute URL the server is mounted at
+
+	// Optional: database layout, shared by the repository and pkg/migrate.
+	// DBSchema holds every table ("auth" gives "auth.users"); DBTableNames
+	// renames tables by their default name, e.g. {"users": "accounts"}.
+	DBSchema     string            `json:"db_schema,omitempty"`
+	DBTableNames map[string]string `json:"db_table_names,omitempty" validate:"omitempty,dive,keys,oneof=users ssh_keys ssh_public_keys user_access_tokens groups group_members schema_migrations,endkeys,required"`
+
+	// Optional: background cleanup started with client.StartJanitor. Every
+	// JanitorInterval seconds one replica deletes JTIs AccessTokenRetention
+	// seconds after they were issued or ConsumedAccessTokenRetention seconds
+	// after a callback used them, and signing keys outside the newest
+	// SigningKeyRetainCount once they are RetiredKeyGracePeriod hours old.
+	JanitorInterval              int `json:"janitor_interval,omitempty" validate:"omitempty,min=60"`               // default 3600
+	JanitorBatchSize             int `json:"janitor_batch_size,omitempty" validate:"omitempty,min=1"`              // rows per DELETE; default 500
+	AccessTokenRetention         int `json:"access_token_retention,omitempty" validate:"omitempty,min=60"`         // default 86400
+	ConsumedAccessTokenRetention int `json:"consumed_access_token_retention,omitempty" validate:"omitempty,min=1"` // default 300
+	RetiredKeyGracePeriod        int `json:"retired_key_grace_period,omitempty" validate:"omitempty,min=1"`        // default 168
+
+	// Optional: keep disabled users out (inactive, locked or deleted; see
+	// models.User.Disabled). "request" checks the user on every RequireAuth,
+	// "interval" caches the answer in Redis for UserRevalidationInterval
+	// seconds (default 300), and "push" relies on RevokeUserSessions alone.
+	// Every mode also refuses sign-in. Disabled users are signed out everywhere.
+	UserRevalidation         string `json:"user_revalidation,omitempty" validate:"omitempty,oneof=request interval push"`
+	UserRevalidationInterval int    `json:"user_revalidation_interval,omitempty" validate:"omitempty,min=1"`
+
+	// Optional: session epochs. Sessions record the user's and the global epoch
+	// at sign-in, and RequireAuth rejects them once either has been bumped
+	// since, at the cost of one Redis read per request.
+	EnableSessionEpochs bool `json:"enable_session_epochs"`
+
+	// Optional: read-through cache for the repository's FindByID and
+	// FindByEmail. Each replica keeps up to UserCacheSize users for
+	// UserCacheLocalTTL seconds (default 30) in front of a Redis copy that
+	// lives UserCacheTTL seconds (default 300). Writes through the repository
+	// invalidate every replica over Redis pub/sub.
+	EnableUserCache   bool `json:"enable_user_cache"`
+	UserCacheSize     int  `json:"user_cache_size,omitempty" validate:"omitempty,min=1"` // default 10000
+	UserCacheLocalTTL int  `json:"user_cache_local_ttl,omitempty" validate:"omitempty,min=1"`
+	UserCacheTTL      int  `json:"user_cache_ttl,omitempty" validate:"omitempty,min=1"`
+
 	// Redis configuration for session storage
 	RedisURI      string `json:"redis_uri" validate:"required"`
 	SessionKey    string `json:"session_key" validate:"required"`
 	SessionName   string `json:"session_name" validate:"required"`
 	IsRedisSecure bool   `json:"is_redis_secure"`
 
+	// Optional: timeouts in milliseconds. The Redis ones apply to every
+	// connection; DBTimeout bounds each database call. Calls made through the
+	// Context methods also stop at the context's deadline.
+	RedisDialTimeout  int `json:"redis_dial_timeout,omitempty" validate:"omitempty,min=1"`  // default 5000
+	RedisReadTimeout  int `json:"redis_read_timeout,omitempty" validate:"omitempty,min=1"`  // default 3000
+	RedisWriteTimeout int `json:"redis_write_timeout,omitempty" validate:"omitempty,min=1"` // default 3000
+	DBTimeout         int `json:"db_timeout,omitempty" validate:"omitempty,min=1"`          // default 5000
+
 	// Session configuration
 	SessionMaxAge int `json:"session_max_age" validate:"required,min=300"` // minimum 5 minutes
 
 	// Optional: Sliding window configuration
 	EnableSlidingWindow       bool `json:"enable_sliding_window"`
-	SessionExtensionDuration  int  `json:"session_extension_duration,omitempty" validate:"required_if=EnableSlidingWindow true,min=300"`
-	SessionExtensionThreshold int  `json:"session_extension_threshold,omitempty" validate:"required_if=EnableSlidingWindow true,min=60"`
+	SessionExtensionDuration  int  `json:"session_extension_duration,omitempty" validate:"required_if=EnableSlidingWindow true,omitempty,min=300"`
+	SessionExtensionThreshold int  `json:"session_extension_threshold,omitempty" validate:"required_if=EnableSlidingWindow true,omitempty,min=60"`
+
+	// Signing key cache configuration
+	SigningKeyRefreshInterval int `json:"signing_key_refresh_interval,omitempty" validate:"omitempty,min=1"` // seconds between reloads of ssh_keys
+	SigningKeyRetainCount     int `json:"signing_key_retain_count,omitempty" validate:"omitempty,min=1"`     // newest keys kept for verification
+	SigningKeyValidityWindow  int `json:"signing_key_validity_window,omitempty" validate:"omitempty,min=1"`  // hours an older key stays valid; defaults to 24, 0 keeps every retained key
+
+	// Optional: where verification keys come from. Defaults to "database", which
+	// derives them from the private keys in ssh_keys; "public_key_table", "file"
+	// and "jwks" only ever handle public keys or certificates.
+	SigningKeySource  string `json:"signing_key_source,omitempty" validate:"omitempty,oneof=database public_key_table file jwks"`
+	SigningKeyFile    string `json:"signing_key_file,omitempty" validate:"required_if=SigningKeySource file"`
+	SigningKeyJWKSURL string `json:"signing_key_jwks_url,omitempty" validate:"required_if=SigningKeySource jwks,omitempty,url"`
+	SigningKeyCAFile  string `json:"signing_key_ca_file,omitempty"` // CA bundle for certificate chains; system roots when empty
+
+	// Optional: JWS algorithms accepted for ID tokens. Empty allows every
+	// supported RS*, PS*, ES* and EdDSA algorithm; HMAC and "none" are never accepted.
+	SigningAlgorithms []string `json:"signing_algorithms,omitempty" validate:"omitempty,dive,oneof=RS256 RS384 RS512 PS256 PS384 PS512 ES256 ES384 ES512 EdDSA"`
+
+	// Optional: encrypted (JWE) ID tokens. IDTokenDecryptionKey is the client's
+	// PEM private key (RSA or EC) the SSO server encrypts tokens to.
+	IDTokenDecryptionKey    string `json:"id_token_decryption_key,omitempty" validate:"required_if=RequireEncryptedIDToken true"`
+	RequireEncryptedIDToken bool   `json:"require_encrypted_id_token"` // reject plain signed tokens
 }
 
 func DefaultConfig() *Config {
@@ -38,5 +174,9 @@ func DefaultConfig() *Config {
 		EnableSlidingWindow:       false,
 		SessionExtensionDuration:  1800, // 30 minutes
 		SessionExtensionThreshold: 1200, // 20 minutes
+
+		SigningKeyRefreshInterval: 300, // 5 minutes
+		SigningKeyRetainCount:     3,
+		SigningKeyValidityWindow:  24, // 1 day
 	}
 }
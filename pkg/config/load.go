@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envPrefix     = "SSO_"
+	envFileSuffix = "_FILE"
+	envConfigFile = "SSO_CONFIG_FILE"
+)
+
+// Load builds a Config from, lowest priority first: DefaultConfig, the file
+// named by SSO_CONFIG_FILE, SSO_* environment variables and SSO_*_FILE
+// secret files. See LoadFile.
+func Load() (*Config, error) {
+	return LoadFile(os.Getenv(envConfigFile))
+}
+
+// LoadFile is Load with the file given explicitly; an empty path skips it.
+// The file is YAML, JSON or TOML, chosen by extension, and uses the same keys
+// as the json tags. An environment variable is the key upper-cased with the
+// SSO_ prefix, e.g. SSO_SESSION_MAX_AGE; lists are comma-separated and maps are
+// comma-separated key=value pairs. SSO_SESSION_KEY_FILE=/run/secrets/key sets
+// session_key to the file's contents. The result is validated, and every
+// problem is returned at once in a *ValidationError.
+func LoadFile(path string) (*Config, error) {
+	return load(path, os.LookupEnv)
+}
+
+func load(path string, lookup func(string) (string, bool)) (*Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	problems := cfg.applyEnv(lookup)
+	problems = append(problems, cfg.problems()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// readFile decodes the file over cfg. YAML and TOML are converted to JSON
+// first so that every format uses the json tags.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	var values map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("error reading config file: unsupported extension %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	if values != nil {
+		if data, err = json.Marshal(values); err != nil {
+			return fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv sets every field that has an SSO_* variable or, for strings, an
+// SSO_*_FILE secret, and returns the values it could not use.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) []string {
+	var problems []string
+
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := envPrefix + strings.ToUpper(jsonName(field))
+
+		value, ok := lookup(name)
+		if secretPath, isSet := lookup(name + envFileSuffix); isSet {
+			if field.Type.Kind() != reflect.String {
+				problems = append(problems, fmt.Sprintf("%s%s: only string settings can be read from a file", name, envFileSuffix))
+				continue
+			}
+			if ok {
+				problems = append(problems, fmt.Sprintf("%s: set both %s and %s%s", name, name, name, envFileSuffix))
+				continue
+			}
+			secret, err := os.ReadFile(secretPath)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s%s: %v", name, envFileSuffix, err))
+				continue
+			}
+			value, ok = strings.TrimRight(string(secret), "\r\n"), true
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setField(v.Field(i), value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	return problems
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		pairs := make(map[string]string)
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, val, found := strings.Cut(pair, "=")
+			if !found {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+			pairs[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		field.Set(reflect.ValueOf(pairs))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// env is a lookup backed by a map, in place of os.LookupEnv.
+type env map[string]string
+
+func (e env) lookup(name string) (string, bool) {
+	value, ok := e[name]
+	return value, ok
+}
+
+// writeFile writes content to name in a temporary directory and returns its
+// path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load("", env{}.lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, DefaultConfig()) {
+		t.Errorf("load() = %+v, want DefaultConfig()", cfg)
+	}
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	files := map[string]string{
+		"sso.yaml": `
+session_max_age: 600
+callback_response_modes: [query, form_post]
+db_table_names:
+  users: accounts
+`,
+		"sso.yml": `
+session_max_age: 600
+callback_response_modes: [query, form_post]
+db_table_names: {users: accounts}
+`,
+		"sso.json": `{
+  "session_max_age": 600,
+  "callback_response_modes": ["query", "form_post"],
+  "db_table_names": {"users": "accounts"}
+}`,
+		"sso.toml": `
+session_max_age = 600
+callback_response_modes = ["query", "form_post"]
+
+[db_table_names]
+users = "accounts"
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := load(writeFile(t, name, content), env{}.lookup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.SessionMaxAge != 600 ||
+				!reflect.DeepEqual(cfg.CallbackResponseModes, []string{"query", "form_post"}) ||
+				!reflect.DeepEqual(cfg.DBTableNames, map[string]string{"users": "accounts"}) {
+				t.Errorf("load() = %+v", cfg)
+			}
+			// Settings the file leaves out keep their defaults.
+			if cfg.SessionName != DefaultConfig().SessionName {
+				t.Errorf("session_name = %q, want the default", cfg.SessionName)
+			}
+		})
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"unknown key", "sso.yaml", "session_max_ages: 600\n"},
+		{"wrong type", "sso.json", `{"session_max_age": "long"}`},
+		{"malformed", "sso.toml", "session_max_age = \n"},
+		{"unsupported extension", "sso.ini", "session_max_age=600\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cfg, err := load(writeFile(t, tt.file, tt.content), env{}.lookup); err == nil {
+				t.Errorf("load() = %+v, want an error", cfg)
+			}
+		})
+	}
+
+	if _, err := load(filepath.Join(t.TempDir(), "missing.yaml"), env{}.lookup); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("load() of a missing file error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	path := writeFile(t, "sso.yaml", "session_max_age: 600\nsession_name: from_file\n")
+	secret := writeFile(t, "session_key", "s3cret\n")
+
+	cfg, err := load(path, env{
+		"SSO_SESSION_MAX_AGE":             "7200",
+		"SSO_ENABLE_USER_CACHE":           "true",
+		"SSO_SIGNING_ALGORITHMS":          "RS256, ES256,",
+		"SSO_DB_TABLE_NAMES":              "users=accounts, groups = teams",
+		"SSO_SESSION_KEY_FILE":            secret,
+		"SSO_UNRELATED_SETTING":           "ignored",
+		"SESSION_NAME":                    "unprefixed",
+		"SSO_POST_LOGOUT_REDIRECT_URL":    "https://app.example.com/",
+		"SSO_SIGNING_KEY_VALIDITY_WINDOW": "0",
+	}.lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DefaultConfig()
+	want.SessionMaxAge = 7200
+	want.SessionName = "from_file"
+	want.EnableUserCache = true
+	want.SigningAlgorithms = []string{"RS256", "ES256"}
+	want.DBTableNames = map[string]string{"users": "accounts", "groups": "teams"}
+	want.SessionKey = "s3cret"
+	want.PostLogoutRedirectURL = "https://app.example.com/"
+	want.SigningKeyValidityWindow = 0
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("load() = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	secret := writeFile(t, "secret", "value")
+
+	_, err := load("", env{
+		"SSO_SESSION_MAX_AGE":               "long",
+		"SSO_IS_REDIS_SECURE":               "maybe",
+		"SSO_DB_TABLE_NAMES":                "users",
+		"SSO_SESSION_KEY":                   "key",
+		"SSO_SESSION_KEY_FILE":              secret,
+		"SSO_SIGNING_KEY_RETAIN_COUNT_FILE": secret,
+		"SSO_CLIENT_SECRET_FILE":            filepath.Join(t.TempDir(), "missing"),
+		"SSO_ENABLE_SLIDING_WINDOW":         "true",
+		"SSO_SESSION_EXTENSION_DURATION":    "0",
+		"SSO_BEARER_VALIDATION_MODE":        "introspection",
+	}.lookup)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("load() error = %v, want a *ValidationError", err)
+	}
+	want := []string{
+		`SSO_SESSION_MAX_AGE: "long" is not an integer`,
+		`SSO_IS_REDIS_SECURE: "maybe" is not a boolean`,
+		`SSO_DB_TABLE_NAMES: "users" is not a key=value pair`,
+		"SSO_SESSION_KEY: set both SSO_SESSION_KEY and SSO_SESSION_KEY_FILE",
+		"SSO_SIGNING_KEY_RETAIN_COUNT_FILE: only string settings can be read from a file",
+		"SSO_CLIENT_SECRET_FILE: ",
+		"session_extension_duration: is required when enable_sliding_window is true",
+		"introspection_url: is required when bearer_validation_mode is introspection",
+	}
+	for _, problem := range want {
+		if !containsPrefix(validationErr.Problems, problem) {
+			t.Errorf("problems %q lack %q", validationErr.Problems, problem)
+		}
+	}
+	if len(validationErr.Problems) != len(want) {
+		t.Errorf("got %d problems, want %d: %q", len(validationErr.Problems), len(want), validationErr.Problems)
+	}
+}
+
+func containsPrefix(problems []string, prefix string) bool {
+	for _, problem := range problems {
+		if strings.HasPrefix(problem, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoadFromOSEnvironment(t *testing.T) {
+	t.Setenv(envConfigFile, writeFile(t, "sso.json", `{"session_name": "from_file"}`))
+	t.Setenv("SSO_SESSION_MAX_AGE", "900")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SessionName != "from_file" || cfg.SessionMaxAge != 900 {
+		t.Errorf("Load() = %+v, want the file's session_name and the environment's session_max_age", cfg)
+	}
+}
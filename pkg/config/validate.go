@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const EnvironmentProduction = "production"
+
+// ValidationError lists every problem found while loading or validating a
+// Config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the validate tags of every field and, in production,
+// refuses the secrets DefaultConfig ships with. All problems are reported in
+// one *ValidationError.
+func (c *Config) Validate() error {
+	problems := c.problems()
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (c *Config) problems() []string {
+	var problems []string
+
+	validate := validator.New()
+	validate.RegisterTagNameFunc(jsonName)
+	if err := validate.Struct(c); err != nil {
+		errs, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return []string{err.Error()}
+		}
+		for _, fieldErr := range errs {
+			problems = append(problems, describe(fieldErr))
+		}
+	}
+
+	if c.BearerAudience != "" && c.BearerAudience == c.ClientID {
+		problems = append(problems, "bearer_audience: must differ from client_id, which ID tokens are issued to")
+	}
+
+	if c.Environment == EnvironmentProduction {
+		defaults := DefaultConfig()
+		if c.SessionKey == defaults.SessionKey {
+			problems = append(problems, "session_key: the default key must not be used in production")
+		}
+		defaultPassword, _ := redisPassword(defaults.RedisURI)
+		if password, ok := redisPassword(c.RedisURI); ok && password == defaultPassword {
+			problems = append(problems, "redis_uri: the default password must not be used in production")
+		}
+	}
+
+	return problems
+}
+
+// redisPassword returns the password in a redis:// URI, if it has one.
+func redisPassword(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil {
+		return "", false
+	}
+	return u.User.Password()
+}
+
+// describe turns a failed validate tag into a message naming the JSON field.
+func describe(fieldErr validator.FieldError) string {
+	field := strings.TrimPrefix(fieldErr.Namespace(), "Config.")
+	param := fieldErr.Param()
+
+	switch fieldErr.Tag() {
+	case "required":
+		return field + ": is required"
+	case "required_if":
+		parts := strings.Fields(param)
+		if len(parts) == 2 {
+			return fmt.Sprintf("%s: is required when %s is %s", field, jsonFieldName(parts[0]), parts[1])
+		}
+		return field + ": is required"
+	case "min":
+		return fmt.Sprintf("%s: must be at least %s", field, param)
+	case "oneof":
+		return fmt.Sprintf("%s: must be one of %s", field, strings.ReplaceAll(param, " ", ", "))
+	case "url":
+		return field + ": must be a URL"
+	}
+	return fmt.Sprintf("%s: failed the %s check", field, fieldErr.Tag())
+}
+
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func jsonFieldName(goName string) string {
+	field, ok := reflect.TypeOf(Config{}).FieldByName(goName)
+	if !ok {
+		return goName
+	}
+	return jsonName(field)
+}
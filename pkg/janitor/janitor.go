@@ -0,0 +1,179 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/store"
+)
+
+const (
+	lockKey = "sso:janitor"
+
+	defaultInterval          = time.Hour
+	defaultBatchSize         = 500
+	defaultTokenRetention    = 24 * time.Hour
+	defaultConsumedRetention = 5 * time.Minute
+	defaultKeyGracePeriod    = 7 * 24 * time.Hour
+)
+
+// Repository deletes rows in batches of at most limit and reports how many
+// it deleted.
+type Repository interface {
+	DeleteAccessTokensContext(ctx context.Context, createdBefore, consumedBefore time.Time, limit int) (int64, error)
+	DeleteRetiredSshKeysContext(ctx context.Context, keep int, createdBefore time.Time, limit int) (int64, error)
+	DeleteRetiredSshPublicKeysContext(ctx context.Context, keep int, createdBefore time.Time, limit int) (int64, error)
+}
+
+// Config holds the janitor's schedule and retention periods. Zero values take
+// the defaults.
+type Config struct {
+	Interval  time.Duration // time between runs
+	BatchSize int           // rows deleted per statement
+
+	TokenRetention    time.Duration // age at which an unused JTI is deleted
+	ConsumedRetention time.Duration // time a JTI is kept after a callback used it
+
+	KeepKeys       int           // newest signing keys that are never deleted
+	KeyGracePeriod time.Duration // age at which an older key is deleted
+}
+
+// Janitor periodically deletes used and expired JTIs and retired signing
+// keys. Each run takes a Redis lock for the whole interval, so only one
+// replica does the work per interval.
+type Janitor struct {
+	repo   Repository
+	pool   *redis.Pool
+	config Config
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(repo Repository, pool *redis.Pool, config Config) *Janitor {
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.TokenRetention <= 0 {
+		config.TokenRetention = defaultTokenRetention
+	}
+	if config.ConsumedRetention <= 0 {
+		config.ConsumedRetention = defaultConsumedRetention
+	}
+	// The newest key signs new tokens and is never deleted.
+	if config.KeepKeys < 1 {
+		config.KeepKeys = 1
+	}
+	if config.KeyGracePeriod <= 0 {
+		config.KeyGracePeriod = defaultKeyGracePeriod
+	}
+
+	return &Janitor{
+		repo:   repo,
+		pool:   pool,
+		config: config,
+	}
+}
+
+// Start runs the janitor now and then every interval until Stop is called.
+func (j *Janitor) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	j.done = make(chan struct{})
+
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(j.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			if err := j.Run(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Janitor run failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends the background loop and waits for a run in progress to finish
+// its current batch.
+func (j *Janitor) Stop() {
+	if j.cancel == nil {
+		return
+	}
+	j.cancel()
+	<-j.done
+}
+
+// Run does one cleanup pass unless another replica already ran within the
+// interval. Canceling ctx stops it between batches and aborts the batch in flight.
+func (j *Janitor) Run(ctx context.Context) error {
+	// The lock is left to expire rather than released, so the other replicas
+	// skip this interval.
+	lock, err := store.TryLock(ctx, j.pool, lockKey, j.config.Interval)
+	if err != nil {
+		return fmt.Errorf("error taking janitor lock: %w", err)
+	}
+	if lock == nil {
+		return nil
+	}
+
+	now := time.Now()
+	keysBefore := now.Add(-j.config.KeyGracePeriod)
+
+	sweeps := []struct {
+		name   string
+		delete func(limit int) (int64, error)
+	}{
+		{"access tokens", func(limit int) (int64, error) {
+			return j.repo.DeleteAccessTokensContext(ctx, now.Add(-j.config.TokenRetention), now.Add(-j.config.ConsumedRetention), limit)
+		}},
+		{"signing keys", func(limit int) (int64, error) {
+			return j.repo.DeleteRetiredSshKeysContext(ctx, j.config.KeepKeys, keysBefore, limit)
+		}},
+		{"public signing keys", func(limit int) (int64, error) {
+			return j.repo.DeleteRetiredSshPublicKeysContext(ctx, j.config.KeepKeys, keysBefore, limit)
+		}},
+	}
+
+	for _, sweep := range sweeps {
+		deleted, err := j.sweep(ctx, sweep.delete)
+		if deleted > 0 {
+			log.Printf("Janitor deleted %d %s", deleted, sweep.name)
+		}
+		if err != nil {
+			return fmt.Errorf("error deleting %s: %w", sweep.name, err)
+		}
+	}
+
+	return nil
+}
+
+// sweep calls delete until a batch comes back short.
+func (j *Janitor) sweep(ctx context.Context, delete func(limit int) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		deleted, err := delete(j.config.BatchSize)
+		total += deleted
+		if err != nil || deleted < int64(j.config.BatchSize) {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeRepo holds counts of deletable rows and records the cutoffs it was
+// called with.
+type fakeRepo struct {
+	mu         sync.Mutex
+	tokens     int64
+	keys       int64
+	publicKeys int64
+	calls      int
+	err        error
+
+	createdBefore, consumedBefore, keysBefore time.Time
+	keep                                      int
+}
+
+func (r *fakeRepo) take(rows *int64, limit int) int64 {
+	r.calls++
+	deleted := *rows
+	if deleted > int64(limit) {
+		deleted = int64(limit)
+	}
+	*rows -= deleted
+	return deleted
+}
+
+func (r *fakeRepo) DeleteAccessTokensContext(ctx context.Context, createdBefore, consumedBefore time.Time, limit int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.createdBefore, r.consumedBefore = createdBefore, consumedBefore
+	return r.take(&r.tokens, limit), nil
+}
+
+func (r *fakeRepo) DeleteRetiredSshKeysContext(ctx context.Context, keep int, createdBefore time.Time, limit int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keep, r.keysBefore = keep, createdBefore
+	return r.take(&r.keys, limit), nil
+}
+
+func (r *fakeRepo) DeleteRetiredSshPublicKeysContext(ctx context.Context, keep int, createdBefore time.Time, limit int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.take(&r.publicKeys, limit), nil
+}
+
+func newTestPool(t *testing.T) *redis.Pool {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", server.Addr()) }}
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func TestRun(t *testing.T) {
+	repo := &fakeRepo{tokens: 5, keys: 2, publicKeys: 0}
+	pool := newTestPool(t)
+	j := New(repo, pool, Config{
+		Interval:          time.Hour,
+		BatchSize:         2,
+		TokenRetention:    time.Hour,
+		ConsumedRetention: time.Minute,
+		KeepKeys:          3,
+		KeyGracePeriod:    24 * time.Hour,
+	})
+
+	start := time.Now()
+	if err := j.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if repo.tokens != 0 || repo.keys != 0 {
+		t.Errorf("left %d tokens and %d keys, want none", repo.tokens, repo.keys)
+	}
+	// Tokens take three batches, the last one short; keys take a full batch
+	// and an empty one; public keys one empty batch.
+	if repo.calls != 6 {
+		t.Errorf("made %d delete calls, want 6", repo.calls)
+	}
+	for name, got := range map[string]struct {
+		cutoff time.Time
+		age    time.Duration
+	}{
+		"created":  {repo.createdBefore, time.Hour},
+		"consumed": {repo.consumedBefore, time.Minute},
+		"keys":     {repo.keysBefore, 24 * time.Hour},
+	} {
+		if want := start.Add(-got.age); got.cutoff.Before(want.Add(-time.Second)) || got.cutoff.After(want.Add(time.Second)) {
+			t.Errorf("%s cutoff = %v, want about %v", name, got.cutoff, want)
+		}
+	}
+	if repo.keep != 3 {
+		t.Errorf("kept %d keys, want 3", repo.keep)
+	}
+
+	// Another run within the interval, here or on another replica, does nothing.
+	repo.tokens = 1
+	if err := New(repo, pool, Config{Interval: time.Hour}).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if repo.tokens != 1 {
+		t.Error("a run within the interval deleted tokens")
+	}
+}
+
+func TestRunStopsOnError(t *testing.T) {
+	errDown := errors.New("database down")
+	repo := &fakeRepo{keys: 1, err: errDown}
+
+	if err := New(repo, newTestPool(t), Config{}).Run(context.Background()); !errors.Is(err, errDown) {
+		t.Errorf("Run() error = %v, want %v", err, errDown)
+	}
+	if repo.keys != 1 {
+		t.Error("Run() went on to delete keys after a failed sweep")
+	}
+}
+
+func TestRunWithCanceledContext(t *testing.T) {
+	repo := &fakeRepo{tokens: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := New(repo, newTestPool(t), Config{}).Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want context.Canceled", err)
+	}
+	if repo.calls != 0 {
+		t.Errorf("made %d delete calls after cancellation, want 0", repo.calls)
+	}
+}
+
+func TestStartAndStop(t *testing.T) {
+	repo := &fakeRepo{tokens: 1}
+	j := New(repo, newTestPool(t), Config{Interval: time.Hour})
+	j.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		repo.mu.Lock()
+		tokens := repo.tokens
+		repo.mu.Unlock()
+		if tokens == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the first run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	j.Stop()
+
+	// Stop without Start is a no-op.
+	New(repo, newTestPool(t), Config{}).Stop()
+}
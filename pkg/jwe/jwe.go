@@ -0,0 +1,324 @@
+package jwe
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrNotEncrypted = errors.New("token is not a compact JWE")
+	ErrDecryption   = errors.New("error decrypting token")
+)
+
+type header struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Cty string `json:"cty"`
+	Kid string `json:"kid"`
+	Epk *struct {
+		Kty string `json:"kty"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"epk"`
+	Apu string `json:"apu"`
+	Apv string `json:"apv"`
+}
+
+// Decrypter opens compact JWE tokens addressed to the client. It supports
+// RSA-OAEP, RSA-OAEP-256, ECDH-ES and ECDH-ES+A{128,192,256}KW key management
+// with A{128,192,256}GCM content encryption.
+type Decrypter struct {
+	privateKey crypto.PrivateKey
+}
+
+func NewDecrypter(privateKey crypto.PrivateKey) (*Decrypter, error) {
+	switch privateKey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return &Decrypter{privateKey: privateKey}, nil
+	}
+	return nil, fmt.Errorf("unsupported decryption key type %T", privateKey)
+}
+
+// NewDecrypterFromPEM accepts a PKCS#1 RSA, SEC 1 EC or PKCS#8 private key.
+func NewDecrypterFromPEM(data []byte) (*Decrypter, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in decryption key")
+	}
+
+	var (
+		privateKey crypto.PrivateKey
+		err        error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing decryption key: %w", err)
+	}
+
+	return NewDecrypter(privateKey)
+}
+
+// IsEncrypted reports whether token has the five segments of a compact JWE.
+func IsEncrypted(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// DecryptNested decrypts a JWE whose payload is a signed JWT and returns that
+// JWT. Tokens without a "JWT" content type are rejected.
+func (d *Decrypter) DecryptNested(token string) (string, error) {
+	plaintext, hdr, err := d.decrypt(token)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.EqualFold(hdr.Cty, "JWT") {
+		return "", fmt.Errorf("unexpected JWE content type %q: expected a nested JWT", hdr.Cty)
+	}
+	return string(plaintext), nil
+}
+
+func (d *Decrypter) decrypt(token string) ([]byte, *header, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, nil, ErrNotEncrypted
+	}
+
+	rawHeader, err := decode(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding JWE header: %w", err)
+	}
+	var hdr header
+	if err := json.Unmarshal(rawHeader, &hdr); err != nil {
+		return nil, nil, fmt.Errorf("error decoding JWE header: %w", err)
+	}
+
+	keySize, err := contentKeySize(hdr.Enc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	segments := make([][]byte, 4)
+	for i, part := range parts[1:] {
+		if segments[i], err = decode(part); err != nil {
+			return nil, nil, fmt.Errorf("error decoding JWE segment: %w", err)
+		}
+	}
+	encryptedKey, iv, ciphertext, tag := segments[0], segments[1], segments[2], segments[3]
+
+	cek, err := d.contentKey(&hdr, encryptedKey, keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, ErrDecryption
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil || len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return nil, nil, ErrDecryption
+	}
+
+	// The additional authenticated data is the encoded protected header.
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, nil, ErrDecryption
+	}
+
+	return plaintext, &hdr, nil
+}
+
+// contentKey recovers the content encryption key. Failures are reported as
+// ErrDecryption without detail so the key management cannot be probed, and a
+// failed RSA-OAEP decryption is only detected when the content fails to open.
+func (d *Decrypter) contentKey(hdr *header, encryptedKey []byte, keySize int) ([]byte, error) {
+	var cek []byte
+
+	switch hdr.Alg {
+	case "RSA-OAEP", "RSA-OAEP-256":
+		privateKey, ok := d.privateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("JWE algorithm %s requires an RSA decryption key", hdr.Alg)
+		}
+
+		hash := sha1.New()
+		if hdr.Alg == "RSA-OAEP-256" {
+			hash = sha256.New()
+		}
+		key, err := rsa.DecryptOAEP(hash, rand.Reader, privateKey, encryptedKey, nil)
+		if err != nil || len(key) != keySize {
+			// RFC 7516 section 11.5: carry on with a random key so that a bad
+			// encrypted key fails the same way, and takes as long, as a bad tag.
+			key = make([]byte, keySize)
+			if _, err := rand.Read(key); err != nil {
+				return nil, ErrDecryption
+			}
+		}
+		cek = key
+	case "ECDH-ES", "ECDH-ES+A128KW", "ECDH-ES+A192KW", "ECDH-ES+A256KW":
+		privateKey, ok := d.privateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("JWE algorithm %s requires an EC decryption key", hdr.Alg)
+		}
+
+		z, err := sharedSecret(privateKey, hdr)
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Alg == "ECDH-ES" {
+			if len(encryptedKey) != 0 {
+				return nil, ErrDecryption
+			}
+			return concatKDF(z, hdr.Enc, hdr.Apu, hdr.Apv, keySize)
+		}
+
+		kekSize := map[string]int{"ECDH-ES+A128KW": 16, "ECDH-ES+A192KW": 24, "ECDH-ES+A256KW": 32}[hdr.Alg]
+		kek, err := concatKDF(z, hdr.Alg, hdr.Apu, hdr.Apv, kekSize)
+		if err != nil {
+			return nil, err
+		}
+		if cek, err = unwrapKey(kek, encryptedKey); err != nil {
+			return nil, ErrDecryption
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWE algorithm %q", hdr.Alg)
+	}
+
+	if len(cek) != keySize {
+		return nil, ErrDecryption
+	}
+	return cek, nil
+}
+
+func contentKeySize(enc string) (int, error) {
+	switch enc {
+	case "A128GCM":
+		return 16, nil
+	case "A192GCM":
+		return 24, nil
+	case "A256GCM":
+		return 32, nil
+	}
+	return 0, fmt.Errorf("unsupported JWE content encryption %q", enc)
+}
+
+// sharedSecret computes the ECDH shared secret with the sender's ephemeral key.
+// crypto/ecdh would do the point validation for us but needs Go 1.20, and this
+// module still supports Go 1.19, so the key is checked against the curve here.
+func sharedSecret(privateKey *ecdsa.PrivateKey, hdr *header) ([]byte, error) {
+	epk := hdr.Epk
+	if epk == nil || epk.Kty != "EC" || epk.Crv != privateKey.Curve.Params().Name {
+		return nil, errors.New("JWE ephemeral key does not match the decryption key curve")
+	}
+
+	x, errX := decode(epk.X)
+	y, errY := decode(epk.Y)
+	if errX != nil || errY != nil {
+		return nil, errors.New("error decoding JWE ephemeral key")
+	}
+
+	curve := privateKey.Curve
+	px, py := new(big.Int).SetBytes(x), new(big.Int).SetBytes(y)
+	if !curve.IsOnCurve(px, py) {
+		return nil, errors.New("JWE ephemeral key is not on the curve")
+	}
+
+	sx, _ := curve.ScalarMult(px, py, privateKey.D.Bytes())
+	return sx.FillBytes(make([]byte, (curve.Params().BitSize+7)/8)), nil
+}
+
+// concatKDF derives keySize bytes from the shared secret as described in
+// NIST SP 800-56A section 5.8.1, using the parameters from RFC 7518 section 4.6.
+func concatKDF(z []byte, algorithmID, apu, apv string, keySize int) ([]byte, error) {
+	partyU, err := decode(apu)
+	if err != nil {
+		return nil, errors.New("error decoding JWE apu")
+	}
+	partyV, err := decode(apv)
+	if err != nil {
+		return nil, errors.New("error decoding JWE apv")
+	}
+
+	var otherInfo []byte
+	for _, field := range [][]byte{[]byte(algorithmID), partyU, partyV} {
+		otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(field)))
+		otherInfo = append(otherInfo, field...)
+	}
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(keySize*8))
+
+	var key []byte
+	for counter := uint32(1); len(key) < keySize; counter++ {
+		hash := sha256.New()
+		_ = binary.Write(hash, binary.BigEndian, counter)
+		hash.Write(z)
+		hash.Write(otherInfo)
+		key = hash.Sum(key)
+	}
+
+	return key[:keySize], nil
+}
+
+// unwrapKey implements the AES key unwrap algorithm from RFC 3394.
+func unwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, errors.New("invalid wrapped key length")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	copy(a, wrapped[:8])
+	r := make([]byte, n*8)
+	copy(r, wrapped[8:])
+
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(a)^t)
+			copy(buf[8:], r[(i-1)*8:i*8])
+			block.Decrypt(buf, buf)
+			copy(a, buf[:8])
+			copy(r[(i-1)*8:i*8], buf[8:])
+		}
+	}
+
+	defaultIV := []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+	if subtle.ConstantTimeCompare(a, defaultIV) != 1 {
+		return nil, errors.New("key unwrap integrity check failed")
+	}
+	return r, nil
+}
+
+func decode(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(segment)
+}
@@ -0,0 +1,81 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+)
+
+// SupportedAlgorithms lists every JWS algorithm a verification key can be used
+// with. HMAC and "none" are deliberately absent: tokens are only ever verified
+// with asymmetric keys.
+var SupportedAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// CheckAlgorithms reports entries in an allowlist that are not asymmetric JWS
+// algorithms this package supports.
+func CheckAlgorithms(algorithms []string) error {
+	for _, alg := range algorithms {
+		if !isSupportedAlgorithm(alg) {
+			return fmt.Errorf("unsupported signing algorithm %q", alg)
+		}
+	}
+	return nil
+}
+
+// AllowedAlgorithms returns the configured allowlist, or every supported
+// algorithm when it is empty.
+func AllowedAlgorithms(configured []string) []string {
+	if len(configured) == 0 {
+		return SupportedAlgorithms
+	}
+	return configured
+}
+
+// Accepts reports whether a token signed with alg can be verified by this key.
+// The algorithm family must match the key type, ECDSA curves must match the
+// hash size, and a key published with an explicit alg only accepts that one.
+func (k *Key) Accepts(alg string) bool {
+	if k.Algorithm != "" && k.Algorithm != alg {
+		return false
+	}
+
+	switch publicKey := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		return alg == curveAlgorithm(publicKey.Curve)
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+
+	return false
+}
+
+func curveAlgorithm(curve elliptic.Curve) string {
+	switch curve {
+	case elliptic.P256():
+		return "ES256"
+	case elliptic.P384():
+		return "ES384"
+	case elliptic.P521():
+		return "ES512"
+	}
+	return ""
+}
+
+func isSupportedAlgorithm(alg string) bool {
+	for _, supported := range SupportedAlgorithms {
+		if alg == supported {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+const jwksFetchTimeout = 10 * time.Second
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid"`
+	Use string   `json:"use"`
+	Alg string   `json:"alg"`
+	N   string   `json:"n"`
+	E   string   `json:"e"`
+	Crv string   `json:"crv"`
+	X   string   `json:"x"`
+	Y   string   `json:"y"`
+	D   string   `json:"d"`
+	X5c []string `json:"x5c"`
+}
+
+// jwksSource fetches verification keys from a JWKS document. Every key the
+// document lists is active for as long as it is published, so the retain count
+// does not apply and CreatedAt is left zero.
+type jwksSource struct {
+	url        string
+	verifier   *certVerifier
+	httpClient *http.Client
+}
+
+func newJWKSSource(url string, verifier *certVerifier) *jwksSource {
+	return &jwksSource{
+		url:        url,
+		verifier:   verifier,
+		httpClient: &http.Client{Timeout: jwksFetchTimeout},
+	}
+}
+
+func (s *jwksSource) LoadKeys(int) ([]*Key, error) {
+	resp, err := s.httpClient.Get(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set jsonWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("error decoding JWKS: %w", err)
+	}
+
+	parsed := make([]*Key, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.key(s.verifier)
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v", jwk.Kid, err)
+			continue
+		}
+		parsed = append(parsed, key)
+	}
+
+	return parsed, nil
+}
+
+func (k jsonWebKey) key(verifier *certVerifier) (*Key, error) {
+	if k.D != "" {
+		return nil, errors.New("private keys are not accepted as verification keys")
+	}
+	if k.Alg != "" && !isSupportedAlgorithm(k.Alg) {
+		return nil, fmt.Errorf("unsupported algorithm %q", k.Alg)
+	}
+
+	publicKey, err := k.publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var key *Key
+	if len(k.X5c) == 0 {
+		key, err = newKey(publicKey, k.Kid)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		key, err = k.certificateKey(verifier)
+		if err != nil {
+			return nil, err
+		}
+		if !publicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.PublicKey) {
+			return nil, errors.New("x5c certificate does not match the key parameters")
+		}
+		if k.Kid != "" {
+			key.KID = k.Kid
+		}
+	}
+
+	key.Algorithm = k.Alg
+	return key, nil
+}
+
+func (k jsonWebKey) certificateKey(verifier *certVerifier) (*Key, error) {
+	chain := make([]*x509.Certificate, 0, len(k.X5c))
+	for _, encoded := range k.X5c {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding x5c: %w", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing x5c: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+
+	return certificateKey(chain[0], chain[1:], verifier)
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		return k.rsaPublicKey()
+	case "EC":
+		return k.ecdsaPublicKey()
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key parameters")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA key parameters")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+func (k jsonWebKey) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding x coordinate: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding y coordinate: %w", err)
+	}
+
+	publicKey := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("invalid EC key parameters")
+	}
+	return publicKey, nil
+}
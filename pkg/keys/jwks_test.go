@@ -0,0 +1,172 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func encodeJWK(t *testing.T, publicKey crypto.PublicKey, kid string) map[string]string {
+	t.Helper()
+
+	encode := base64.RawURLEncoding.EncodeToString
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": encode(key.N.Bytes()), "e": encode(big.NewInt(int64(key.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": key.Curve.Params().Name, "x": encode(key.X.Bytes()), "y": encode(key.Y.Bytes())}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": encode(key)}
+	}
+	t.Fatalf("unsupported key type %T", publicKey)
+	return nil
+}
+
+func serveJWKS(t *testing.T, keys ...map[string]string) *jwksSource {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+
+	return newJWKSSource(server.URL, &certVerifier{})
+}
+
+func generateRSA(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestJWKSLoadsEveryPublishedKey(t *testing.T) {
+	var published []map[string]string
+	for _, kid := range []string{"a", "b", "c", "d"} {
+		published = append(published, encodeJWK(t, &generateRSA(t, 2048).PublicKey, kid))
+	}
+
+	loaded, err := serveJWKS(t, published...).LoadKeys(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(published) {
+		t.Fatalf("loaded %d keys, want all %d", len(loaded), len(published))
+	}
+	for i, key := range loaded {
+		if key.KID != published[i]["kid"] {
+			t.Errorf("key %d kid = %q, want %q", i, key.KID, published[i]["kid"])
+		}
+		if !key.CreatedAt.IsZero() {
+			t.Errorf("key %q CreatedAt = %v, want zero", key.KID, key.CreatedAt)
+		}
+	}
+}
+
+func TestJWKSKeyTypes(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := encodeJWK(t, &generateRSA(t, 2048).PublicKey, "rsa")
+	pinned["alg"] = "PS256"
+
+	loaded, err := serveJWKS(t, pinned, encodeJWK(t, &ecKey.PublicKey, "ec"), encodeJWK(t, edKey, "ed")).LoadKeys(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("loaded %d keys, want 3", len(loaded))
+	}
+
+	tests := []struct {
+		key      *Key
+		accepts  string
+		rejected string
+	}{
+		{loaded[0], "PS256", "RS256"},
+		{loaded[1], "ES384", "ES256"},
+		{loaded[2], "EdDSA", "ES256"},
+	}
+	for _, tt := range tests {
+		if !tt.key.Accepts(tt.accepts) {
+			t.Errorf("key %q does not accept %s", tt.key.KID, tt.accepts)
+		}
+		if tt.key.Accepts(tt.rejected) {
+			t.Errorf("key %q accepts %s", tt.key.KID, tt.rejected)
+		}
+	}
+}
+
+func TestJWKSSkipsUnusableKeys(t *testing.T) {
+	weak := encodeJWK(t, &generateRSA(t, 1024).PublicKey, "weak")
+
+	private := encodeJWK(t, &generateRSA(t, 2048).PublicKey, "private")
+	private["d"] = "AQAB"
+
+	encryption := encodeJWK(t, &generateRSA(t, 2048).PublicKey, "encryption")
+	encryption["use"] = "enc"
+
+	hmac := map[string]string{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"}
+
+	good := encodeJWK(t, &generateRSA(t, 2048).PublicKey, "good")
+
+	loaded, err := serveJWKS(t, weak, private, encryption, hmac, good).LoadKeys(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].KID != "good" {
+		kids := make([]string, 0, len(loaded))
+		for _, key := range loaded {
+			kids = append(kids, key.KID)
+		}
+		t.Fatalf("loaded %v, want only good", kids)
+	}
+}
+
+func TestNewKeyRejectsShortRSAKeys(t *testing.T) {
+	if _, err := newKey(&generateRSA(t, 1024).PublicKey, ""); err == nil {
+		t.Error("newKey() accepted a 1024-bit RSA key")
+	}
+	if _, err := newKey(&generateRSA(t, 2048).PublicKey, ""); err != nil {
+		t.Errorf("newKey() with a 2048-bit RSA key error = %v", err)
+	}
+}
+
+// TestThumbprint uses the example from RFC 7638 section 3.1.
+func TestThumbprint(t *testing.T) {
+	jwk := jsonWebKey{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	publicKey, err := jwk.publicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thumbprint, err := Thumbprint(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; thumbprint != want {
+		t.Errorf("Thumbprint() = %q, want %q", thumbprint, want)
+	}
+}
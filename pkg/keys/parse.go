@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const MinRSAKeyBits = 2048
+
+// newKey wraps a public key, using its thumbprint as the key ID unless one is given.
+// RSA keys shorter than MinRSAKeyBits are rejected.
+func newKey(publicKey crypto.PublicKey, kid string) (*Key, error) {
+	if rsaKey, ok := publicKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < MinRSAKeyBits {
+		return nil, fmt.Errorf("RSA key is %d bits, at least %d are required", rsaKey.N.BitLen(), MinRSAKeyBits)
+	}
+
+	thumbprint, err := Thumbprint(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if kid == "" {
+		kid = thumbprint
+	}
+
+	return &Key{
+		KID:        kid,
+		Thumbprint: thumbprint,
+		PublicKey:  publicKey,
+	}, nil
+}
+
+// publicKeyOf returns the public half of an RSA, ECDSA or Ed25519 private key.
+func publicKeyOf(privateKey crypto.PrivateKey) (crypto.PublicKey, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey, nil
+	case ed25519.PrivateKey:
+		return key.Public(), nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+}
+
+// parsePEMKeys reads every public key and certificate in a PEM bundle.
+// Certificates marked as CAs are treated as intermediates for the leaf
+// certificates in the same bundle. Private keys are always rejected.
+func parsePEMKeys(data []byte, verifier *certVerifier) ([]*Key, error) {
+	var (
+		parsed        []*Key
+		leaves        []*x509.Certificate
+		intermediates []*x509.Certificate
+	)
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "PUBLIC KEY":
+			publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing public key: %w", err)
+			}
+			key, err := newKey(publicKey, "")
+			if err != nil {
+				return nil, err
+			}
+			parsed = append(parsed, key)
+		case "RSA PUBLIC KEY":
+			rsaKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing public key: %w", err)
+			}
+			key, err := newKey(rsaKey, "")
+			if err != nil {
+				return nil, err
+			}
+			parsed = append(parsed, key)
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing certificate: %w", err)
+			}
+			if cert.IsCA {
+				intermediates = append(intermediates, cert)
+			} else {
+				leaves = append(leaves, cert)
+			}
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+			return nil, errors.New("private keys are not accepted as verification keys")
+		}
+	}
+
+	for _, leaf := range leaves {
+		key, err := certificateKey(leaf, intermediates, verifier)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, key)
+	}
+
+	if len(parsed) == 0 {
+		return nil, ErrNoKeys
+	}
+	return parsed, nil
+}
+
+// certificateKey verifies a leaf certificate's chain and validity period and
+// returns its public key. CreatedAt is left zero: a certificate's NotBefore
+// says nothing about when the key was rotated out.
+func certificateKey(leaf *x509.Certificate, intermediates []*x509.Certificate, verifier *certVerifier) (*Key, error) {
+	if err := verifier.verify(leaf, intermediates); err != nil {
+		return nil, err
+	}
+
+	key, err := newKey(leaf.PublicKey, "")
+	if err != nil {
+		return nil, err
+	}
+	key.NotAfter = leaf.NotAfter
+	return key, nil
+}
+
+// Expired reports whether the key came from a certificate that is no longer valid.
+func (k *Key) Expired(now time.Time) bool {
+	return !k.NotAfter.IsZero() && now.After(k.NotAfter)
+}
@@ -0,0 +1,5 @@
+//go:build !production
+
+package keys
+
+const privateKeysAllowed = true
@@ -0,0 +1,173 @@
+package keys
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRefreshInterval = 5 * time.Minute
+	DefaultRetainCount     = 3
+
+	// minForcedRefreshInterval bounds how often a failed verification may force
+	// a reload, so a stream of bad tokens cannot turn into a stream of queries.
+	minForcedRefreshInterval = 10 * time.Second
+)
+
+var ErrNoKeys = errors.New("no signing keys available")
+
+// Key is a parsed verification key. ID and CreatedAt come from the database
+// row it was loaded from, if any; NotAfter is set for keys taken from
+// certificates and Algorithm for JWKS keys that pin a single alg. JWKS and file
+// keys have a zero CreatedAt and stay active for as long as they are published.
+type Key struct {
+	ID         uint
+	KID        string
+	Thumbprint string
+	Algorithm  string
+	PublicKey  crypto.PublicKey
+	CreatedAt  time.Time
+	NotAfter   time.Time
+}
+
+// Matches reports whether a JWT kid header refers to this key, either by its
+// key ID or by its RFC 7638 thumbprint.
+func (k *Key) Matches(kid string) bool {
+	return kid != "" && (kid == k.KID || kid == k.Thumbprint)
+}
+
+// Provider caches the most recent signing keys in process. Keys are reloaded
+// once the refresh interval has elapsed or after Invalidate is called, and the
+// previous keys are kept so tokens signed just before a rotation still verify.
+type Provider struct {
+	source          Source
+	refreshInterval time.Duration
+	retainCount     int
+
+	mu        sync.RWMutex
+	keys      []*Key
+	fetchedAt time.Time
+	failedAt  time.Time
+	stale     bool
+
+	refreshMu sync.Mutex
+	hooksMu   sync.Mutex
+	hooks     []func([]*Key)
+}
+
+func NewProvider(source Source, refreshInterval time.Duration, retainCount int) *Provider {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultRefreshInterval
+	}
+	if retainCount <= 0 {
+		retainCount = DefaultRetainCount
+	}
+
+	return &Provider{
+		source:          source,
+		refreshInterval: refreshInterval,
+		retainCount:     retainCount,
+	}
+}
+
+// Keys returns the cached keys, newest first, reloading them if they are stale.
+func (p *Provider) Keys() ([]*Key, error) {
+	p.mu.RLock()
+	keys, fresh := p.keys, !p.stale && time.Since(p.fetchedAt) < p.refreshInterval
+	p.mu.RUnlock()
+
+	if fresh && len(keys) > 0 {
+		return keys, nil
+	}
+
+	return p.refresh(false)
+}
+
+// Current returns the newest cached key.
+func (p *Provider) Current() (*Key, error) {
+	keys, err := p.Keys()
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// Invalidate marks the cache stale so the next call to Keys reloads it.
+func (p *Provider) Invalidate() {
+	p.mu.Lock()
+	p.stale = true
+	p.mu.Unlock()
+}
+
+// Refresh reloads the keys unless they were reloaded within the refresh
+// interval. force shortens that to minForcedRefreshInterval (10s), so even a
+// forced refresh reuses keys that were loaded moments ago.
+func (p *Provider) Refresh(force bool) ([]*Key, error) {
+	return p.refresh(force)
+}
+
+// OnRefresh registers a hook that is called with the new key set after every
+// successful reload.
+func (p *Provider) OnRefresh(hook func([]*Key)) {
+	p.hooksMu.Lock()
+	defer p.hooksMu.Unlock()
+	p.hooks = append(p.hooks, hook)
+}
+
+func (p *Provider) refresh(force bool) ([]*Key, error) {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	// Another caller may have reloaded while we were waiting for the lock.
+	p.mu.RLock()
+	keys, fetchedAt, failedAt, stale := p.keys, p.fetchedAt, p.failedAt, p.stale
+	p.mu.RUnlock()
+	if len(keys) > 0 && !stale {
+		age := time.Since(fetchedAt)
+		if (!force && age < p.refreshInterval) || age < minForcedRefreshInterval {
+			return keys, nil
+		}
+	}
+	// After a failed reload, the cached keys are served without asking the
+	// source again until minForcedRefreshInterval has passed.
+	if len(keys) > 0 && time.Since(failedAt) < minForcedRefreshInterval {
+		return keys, nil
+	}
+
+	parsed, err := p.source.LoadKeys(p.retainCount)
+	if err != nil {
+		if len(keys) > 0 {
+			// Keep serving the previous keys rather than failing every callback
+			// while the key source is unavailable.
+			log.Printf("Failed to refresh signing keys, using cached keys: %v", err)
+			p.mu.Lock()
+			p.failedAt = time.Now()
+			p.mu.Unlock()
+			return keys, nil
+		}
+		return nil, fmt.Errorf("error loading signing keys: %w", err)
+	}
+
+	if len(parsed) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	p.mu.Lock()
+	p.keys = parsed
+	p.fetchedAt = time.Now()
+	p.stale = false
+	p.mu.Unlock()
+
+	p.hooksMu.Lock()
+	hooks := append([]func([]*Key){}, p.hooks...)
+	p.hooksMu.Unlock()
+	for _, hook := range hooks {
+		hook(parsed)
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,156 @@
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type countingSource struct {
+	loads int
+	keys  []*Key
+	err   error
+}
+
+func (s *countingSource) LoadKeys(limit int) ([]*Key, error) {
+	s.loads++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.keys, nil
+}
+
+func newCountingSource() *countingSource {
+	return &countingSource{keys: []*Key{{KID: "1"}}}
+}
+
+func TestProviderCachesKeys(t *testing.T) {
+	source := newCountingSource()
+	provider := NewProvider(source, time.Minute, 3)
+
+	for i := 0; i < 3; i++ {
+		if _, err := provider.Keys(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if source.loads != 1 {
+		t.Fatalf("loads = %d, want 1", source.loads)
+	}
+
+	provider.Invalidate()
+	if _, err := provider.Keys(); err != nil {
+		t.Fatal(err)
+	}
+	if source.loads != 2 {
+		t.Fatalf("loads after Invalidate = %d, want 2", source.loads)
+	}
+}
+
+func TestProviderReloadsAfterInterval(t *testing.T) {
+	source := newCountingSource()
+	provider := NewProvider(source, time.Minute, 3)
+
+	if _, err := provider.Keys(); err != nil {
+		t.Fatal(err)
+	}
+	provider.fetchedAt = time.Now().Add(-2 * time.Minute)
+	if _, err := provider.Keys(); err != nil {
+		t.Fatal(err)
+	}
+	if source.loads != 2 {
+		t.Fatalf("loads = %d, want 2", source.loads)
+	}
+}
+
+func TestProviderThrottlesForcedRefresh(t *testing.T) {
+	source := newCountingSource()
+	provider := NewProvider(source, time.Hour, 3)
+
+	if _, err := provider.Refresh(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Refresh(true); err != nil {
+		t.Fatal(err)
+	}
+	if source.loads != 1 {
+		t.Fatalf("loads = %d, want 1 within minForcedRefreshInterval", source.loads)
+	}
+
+	provider.fetchedAt = time.Now().Add(-minForcedRefreshInterval - time.Second)
+	if _, err := provider.Refresh(false); err != nil {
+		t.Fatal(err)
+	}
+	if source.loads != 1 {
+		t.Fatalf("loads = %d, want 1 for an unforced refresh within the interval", source.loads)
+	}
+	if _, err := provider.Refresh(true); err != nil {
+		t.Fatal(err)
+	}
+	if source.loads != 2 {
+		t.Fatalf("loads = %d, want 2 for a forced refresh", source.loads)
+	}
+}
+
+func TestProviderKeepsKeysWhenSourceFails(t *testing.T) {
+	source := newCountingSource()
+	provider := NewProvider(source, time.Minute, 3)
+
+	if _, err := provider.Keys(); err != nil {
+		t.Fatal(err)
+	}
+	source.err = errors.New("database down")
+	provider.Invalidate()
+
+	keys, err := provider.Keys()
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("Keys() = %v, %v; want the cached key", keys, err)
+	}
+
+	// Retries wait out minForcedRefreshInterval rather than querying the
+	// failing source on every call.
+	for i := 0; i < 3; i++ {
+		if _, err := provider.Refresh(true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := provider.Keys(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if source.loads != 2 {
+		t.Fatalf("loads = %d, want 2 within minForcedRefreshInterval of the failure", source.loads)
+	}
+
+	source.err = nil
+	provider.failedAt = time.Now().Add(-minForcedRefreshInterval - time.Second)
+	if _, err := provider.Keys(); err != nil {
+		t.Fatal(err)
+	}
+	if source.loads != 3 || provider.stale {
+		t.Fatalf("loads = %d, stale = %v; want a reload once the backoff passed", source.loads, provider.stale)
+	}
+}
+
+func TestProviderFailsWithoutKeys(t *testing.T) {
+	provider := NewProvider(&countingSource{}, time.Minute, 3)
+	if _, err := provider.Keys(); !errors.Is(err, ErrNoKeys) {
+		t.Fatalf("err = %v, want ErrNoKeys", err)
+	}
+
+	provider = NewProvider(&countingSource{err: errors.New("down")}, time.Minute, 3)
+	if _, err := provider.Keys(); err == nil {
+		t.Fatal("want an error when nothing was ever loaded")
+	}
+}
+
+func TestProviderCallsHooks(t *testing.T) {
+	provider := NewProvider(newCountingSource(), time.Minute, 3)
+
+	var got []*Key
+	provider.OnRefresh(func(keys []*Key) { got = keys })
+	if _, err := provider.Keys(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].KID != "1" {
+		t.Fatalf("hook got %v", got)
+	}
+}
@@ -0,0 +1,271 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+const (
+	SourceDatabase       = "database"         // private keys in ssh_keys
+	SourcePublicKeyTable = "public_key_table" // public keys or certificates in ssh_public_keys
+	SourceFile           = "file"             // public keys or certificates in a local PEM file
+	SourceJWKS           = "jwks"             // JWKS document served by the SSO server
+)
+
+var ErrPrivateKeysDisabled = errors.New("loading private signing keys is disabled in production builds")
+
+// Source loads the verification keys the provider caches.
+type Source interface {
+	LoadKeys(limit int) ([]*Key, error)
+}
+
+// Repository is the subset of the user repository the key sources read from.
+type Repository interface {
+	GetLastSshKeys(limit int) ([]models.SshKey, error)
+	GetLastSshPublicKeys(limit int) ([]models.SshPublicKey, error)
+}
+
+// PrivateKeysAllowed reports whether this build may load the identity
+// provider's private keys. It is false when built with the production tag.
+func PrivateKeysAllowed() bool {
+	return privateKeysAllowed
+}
+
+// CheckConfig reports configuration that would keep the key source from ever
+// loading a key.
+func CheckConfig(cfg *config.Config) error {
+	switch sourceName(cfg) {
+	case SourceDatabase:
+		if !privateKeysAllowed {
+			return fmt.Errorf("signing key source %q: %w", SourceDatabase, ErrPrivateKeysDisabled)
+		}
+	case SourcePublicKeyTable:
+	case SourceFile:
+		if cfg.SigningKeyFile == "" {
+			return errors.New("signing_key_file is required for the file signing key source")
+		}
+	case SourceJWKS:
+		if cfg.SigningKeyJWKSURL == "" {
+			return errors.New("signing_key_jwks_url is required for the jwks signing key source")
+		}
+	default:
+		return fmt.Errorf("unknown signing key source %q", cfg.SigningKeySource)
+	}
+
+	if cfg.SigningKeyCAFile != "" {
+		if _, err := loadCertPool(cfg.SigningKeyCAFile); err != nil {
+			return err
+		}
+	}
+
+	return CheckAlgorithms(cfg.SigningAlgorithms)
+}
+
+// NewSource builds the key source selected in the configuration. Problems that
+// CheckConfig would report surface as errors from LoadKeys.
+func NewSource(cfg *config.Config, repo Repository) Source {
+	verifier := &certVerifier{caFile: cfg.SigningKeyCAFile}
+
+	switch sourceName(cfg) {
+	case SourceDatabase:
+		return &sshKeySource{repo: repo}
+	case SourcePublicKeyTable:
+		return &publicKeyTableSource{repo: repo, verifier: verifier}
+	case SourceFile:
+		return &fileSource{path: cfg.SigningKeyFile, verifier: verifier}
+	case SourceJWKS:
+		return newJWKSSource(cfg.SigningKeyJWKSURL, verifier)
+	}
+
+	return sourceFunc(func(int) ([]*Key, error) {
+		return nil, CheckConfig(cfg)
+	})
+}
+
+func sourceName(cfg *config.Config) string {
+	if cfg.SigningKeySource == "" {
+		return SourceDatabase
+	}
+	return cfg.SigningKeySource
+}
+
+type sourceFunc func(limit int) ([]*Key, error)
+
+func (f sourceFunc) LoadKeys(limit int) ([]*Key, error) {
+	return f(limit)
+}
+
+// sshKeySource derives public keys from the private keys in ssh_keys.
+type sshKeySource struct {
+	repo Repository
+}
+
+func (s *sshKeySource) LoadKeys(limit int) ([]*Key, error) {
+	if !privateKeysAllowed {
+		return nil, ErrPrivateKeysDisabled
+	}
+
+	rows, err := s.repo.GetLastSshKeys(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make([]*Key, 0, len(rows))
+	for _, row := range rows {
+		publicKey, err := parsePrivateKeyPEM([]byte(row.PrivateRsaKey))
+		if err != nil {
+			log.Printf("Skipping signing key %d: error parsing private key: %v", row.ID, err)
+			continue
+		}
+
+		key, err := newKey(publicKey, strconv.FormatUint(uint64(row.ID), 10))
+		if err != nil {
+			log.Printf("Skipping signing key %d: %v", row.ID, err)
+			continue
+		}
+		key.ID = row.ID
+		key.CreatedAt = row.CreatedAt
+		parsed = append(parsed, key)
+	}
+
+	return parsed, nil
+}
+
+// parsePrivateKeyPEM accepts PKCS#1 RSA, SEC 1 EC and PKCS#8 private keys and
+// returns the matching public key.
+func parsePrivateKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &privateKey.PublicKey, nil
+	case "EC PRIVATE KEY":
+		privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &privateKey.PublicKey, nil
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return publicKeyOf(privateKey)
+}
+
+// publicKeyTableSource reads PEM public keys or certificates from ssh_public_keys.
+type publicKeyTableSource struct {
+	repo     Repository
+	verifier *certVerifier
+}
+
+func (s *publicKeyTableSource) LoadKeys(limit int) ([]*Key, error) {
+	rows, err := s.repo.GetLastSshPublicKeys(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make([]*Key, 0, len(rows))
+	for _, row := range rows {
+		rowKeys, err := parsePEMKeys([]byte(row.PublicKey), s.verifier)
+		if err != nil || len(rowKeys) == 0 {
+			log.Printf("Skipping signing key %d: error parsing public key: %v", row.ID, err)
+			continue
+		}
+
+		key := rowKeys[0]
+		key.ID = row.ID
+		key.CreatedAt = row.CreatedAt
+		if row.KID != "" {
+			key.KID = row.KID
+		} else {
+			key.KID = strconv.FormatUint(uint64(row.ID), 10)
+		}
+		parsed = append(parsed, key)
+	}
+
+	return parsed, nil
+}
+
+// fileSource reads PEM public keys or certificates from a local file. The
+// file is read again on every refresh so rotated keys are picked up.
+type fileSource struct {
+	path     string
+	verifier *certVerifier
+}
+
+func (s *fileSource) LoadKeys(limit int) ([]*Key, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key file: %w", err)
+	}
+
+	parsed, err := parsePEMKeys(data, s.verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parsed) > limit {
+		parsed = parsed[:limit]
+	}
+	return parsed, nil
+}
+
+// certVerifier checks certificate chains against the configured CA bundle, or
+// the system roots when none is configured.
+type certVerifier struct {
+	caFile string
+}
+
+func (v *certVerifier) verify(leaf *x509.Certificate, intermediates []*x509.Certificate) error {
+	opts := x509.VerifyOptions{
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, cert := range intermediates {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	if v.caFile != "" {
+		roots, err := loadCertPool(v.caFile)
+		if err != nil {
+			return err
+		}
+		opts.Roots = roots
+	}
+
+	if _, err := leaf.Verify(opts); err != nil {
+		return fmt.Errorf("error verifying signing certificate %q: %w", leaf.Subject.CommonName, err)
+	}
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in signing key CA file %s", path)
+	}
+	return pool, nil
+}
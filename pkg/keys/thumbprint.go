@@ -0,0 +1,41 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// Thumbprint returns the RFC 7638 JWK thumbprint of an RSA, ECDSA or Ed25519
+// public key.
+func Thumbprint(publicKey crypto.PublicKey) (string, error) {
+	var members string
+
+	// Members must be in lexicographic order with no whitespace.
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		members = `{"e":"` + encode(big.NewInt(int64(key.E)).Bytes()) +
+			`","kty":"RSA","n":"` + encode(key.N.Bytes()) + `"}`
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		members = `{"crv":"` + key.Curve.Params().Name + `","kty":"EC","x":"` +
+			encode(key.X.FillBytes(make([]byte, size))) + `","y":"` +
+			encode(key.Y.FillBytes(make([]byte, size))) + `"}`
+	case ed25519.PublicKey:
+		members = `{"crv":"Ed25519","kty":"OKP","x":"` + encode(key) + `"}`
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return encode(sum[:]), nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"expvar"
+	"sort"
+	"strings"
+)
+
+// Recorder receives counters emitted by the library. Implementations must be
+// safe for concurrent use.
+type Recorder interface {
+	IncCounter(name string, labels map[string]string)
+}
+
+// ExpvarRecorder publishes counters under the "sso_client" expvar map, keyed by
+// the metric name followed by its sorted labels.
+type ExpvarRecorder struct {
+	counters *expvar.Map
+}
+
+var defaultRecorder = &ExpvarRecorder{counters: expvar.NewMap("sso_client")}
+
+// Default returns the process-wide expvar recorder.
+func Default() Recorder {
+	return defaultRecorder
+}
+
+func (r *ExpvarRecorder) IncCounter(name string, labels map[string]string) {
+	r.counters.Add(key(name, labels), 1)
+}
+
+func key(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
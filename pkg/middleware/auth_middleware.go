@@ -1,19 +1,30 @@
 package middleware
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
 
 	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
 	"github.com/jarvisconsulting/sso-client-go/pkg/store"
 )
 
+// SessionChecker returns an error for a session that must no longer be used,
+// e.g. because its user was disabled or signed out everywhere.
+type SessionChecker interface {
+	CheckSession(ctx context.Context, session *sessions.Session, userKey string) error
+}
+
 type AuthMiddleware struct {
 	sessionStore store.SessionStore
 	sessionName  string
 	signInURL    string
+	checker      SessionChecker
+	loadUser     UserLoader
 }
 
 func NewAuthMiddleware(sessionStore store.SessionStore, sessionName, signInURL string) *AuthMiddleware {
@@ -24,6 +35,16 @@ func NewAuthMiddleware(sessionStore store.SessionStore, sessionName, signInURL s
 	}
 }
 
+// SetSessionChecker makes RequireAuth check every session with checker.
+func (m *AuthMiddleware) SetSessionChecker(checker SessionChecker) {
+	m.checker = checker
+}
+
+// SetUserLoader lets CurrentUser load the user that SetUserID found.
+func (m *AuthMiddleware) SetUserLoader(load UserLoader) {
+	m.loadUser = load
+}
+
 func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		session, err := m.sessionStore.GetStore().Get(c.Request, m.sessionName)
@@ -32,9 +53,22 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
-		_, ok := session.Values[auth.SessionUserIDKey]
+		userKey, ok := auth.UserKey(session.Values[auth.SessionUserIDKey])
 		if !ok {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		if m.checker != nil {
+			if err := m.checker.CheckSession(c.Request.Context(), session, userKey); err != nil {
+				// Delete the rejected session so the next request skips the check.
+				session.Options.MaxAge = -1
+				if err := session.Save(c.Request, c.Writer); err != nil {
+					log.Printf("Failed to delete rejected session: %v", err)
+				}
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+				return
+			}
 		}
 
 		c.Next()
@@ -43,12 +77,17 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 
 func (m *AuthMiddleware) SetUserID() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var value any
 		session, err := m.sessionStore.GetStore().Get(c.Request, m.sessionName)
 		if err == nil {
-			if userID, ok := session.Values[auth.SessionUserIDKey].(uint); ok {
+			// A uint for models.User, or the encoded ID from a UserIDResolver.
+			switch userID := session.Values[auth.SessionUserIDKey].(type) {
+			case uint, string:
 				c.Set("user_id", userID)
+				value = userID
 			}
 		}
+		attachUser(c, value, m.loadUser)
 		c.Next()
 	}
 }
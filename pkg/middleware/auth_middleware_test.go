@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
+)
+
+const testSessionName = "sso_session"
+
+// cookieSessionStore keeps sessions in cookies, so tests need no Redis.
+type cookieSessionStore struct {
+	sessions.Store
+}
+
+func newCookieSessionStore() cookieSessionStore {
+	return cookieSessionStore{sessions.NewCookieStore([]byte("test-session-key-of-32-bytes-len"))}
+}
+
+func (s cookieSessionStore) GetStore() sessions.Store {
+	return s.Store
+}
+
+func (s cookieSessionStore) Close() error {
+	return nil
+}
+
+// signedIn returns the cookies of a session holding values.
+func signedIn(t *testing.T, sessionStore cookieSessionStore, values map[any]any) []*http.Cookie {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := sessionStore.New(r, testSessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		session.Values[key] = value
+	}
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Result().Cookies()
+}
+
+// checkerFunc adapts a function to SessionChecker.
+type checkerFunc func(userKey string) error
+
+func (f checkerFunc) CheckSession(ctx context.Context, session *sessions.Session, userKey string) error {
+	return f(userKey)
+}
+
+func TestRequireAuth(t *testing.T) {
+	revoked := checkerFunc(func(userKey string) error {
+		if userKey != "1" {
+			t.Errorf("checked user key %q, want 1", userKey)
+		}
+		return auth.ErrSessionRevoked
+	})
+	accepted := checkerFunc(func(string) error { return nil })
+
+	tests := []struct {
+		name        string
+		values      map[any]any
+		checker     SessionChecker
+		wantStatus  int
+		wantDeleted bool
+	}{
+		{"signed in", map[any]any{auth.SessionUserIDKey: uint(1)}, nil, http.StatusOK, false},
+		{"resolved user ID", map[any]any{auth.SessionUserIDKey: "user-1"}, nil, http.StatusOK, false},
+		{"signed out", nil, nil, http.StatusUnauthorized, false},
+		{"accepted by the checker", map[any]any{auth.SessionUserIDKey: uint(1)}, accepted, http.StatusOK, false},
+		{"rejected by the checker", map[any]any{auth.SessionUserIDKey: uint(1)}, revoked, http.StatusUnauthorized, true},
+		{"user disabled", map[any]any{auth.SessionUserIDKey: uint(1)}, checkerFunc(func(string) error { return auth.ErrUserDisabled }), http.StatusUnauthorized, true},
+		{"checker failure", map[any]any{auth.SessionUserIDKey: uint(1)}, checkerFunc(func(string) error { return errors.New("unavailable") }), http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionStore := newCookieSessionStore()
+			m := NewAuthMiddleware(sessionStore, testSessionName, "/signin")
+			if tt.checker != nil {
+				m.SetSessionChecker(tt.checker)
+			}
+
+			gin.SetMode(gin.TestMode)
+			engine := gin.New()
+			engine.GET("/", m.RequireAuth(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, cookie := range signedIn(t, sessionStore, tt.values) {
+				r.AddCookie(cookie)
+			}
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, r)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			deleted := false
+			for _, cookie := range recorder.Result().Cookies() {
+				if cookie.Name == testSessionName && cookie.MaxAge < 0 {
+					deleted = true
+				}
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("session deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
+)
+
+type TokenVerifier interface {
+	VerifyAccessTokenContext(ctx context.Context, accessToken string) (jwt.MapClaims, error)
+}
+
+type BearerMiddleware struct {
+	verifier TokenVerifier
+}
+
+func NewBearerMiddleware(verifier TokenVerifier) *BearerMiddleware {
+	return &BearerMiddleware{
+		verifier: verifier,
+	}
+}
+
+// RequireBearer accepts requests with a valid "Authorization: Bearer" token.
+// The claims are stored in the request context and, together with the token
+// subject and actor, in the gin context.
+func (m *BearerMiddleware) RequireBearer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accessToken, ok := bearerToken(c.Request)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		claims, err := m.verifier.VerifyAccessTokenContext(c.Request.Context(), accessToken)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		setBearerClaims(c, claims)
+		c.Next()
+	}
+}
+
+func setBearerClaims(c *gin.Context, claims jwt.MapClaims) {
+	c.Request = c.Request.WithContext(auth.WithBearerClaims(c.Request.Context(), claims))
+	c.Set("bearer_claims", claims)
+	if subject, ok := claims["sub"].(string); ok {
+		c.Set("subject", subject)
+	}
+	if actor, _ := auth.ActorFromClaims(claims); actor != nil {
+		c.Set("actor", actor)
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[7:])
+	return token, token != ""
+}
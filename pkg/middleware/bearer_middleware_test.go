@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
+)
+
+// staticVerifier accepts only the "valid" token.
+type staticVerifier struct {
+	claims jwt.MapClaims
+}
+
+func (v staticVerifier) VerifyAccessTokenContext(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+	if accessToken != "valid" {
+		return nil, errors.New("invalid token")
+	}
+	return v.claims, nil
+}
+
+func TestRequireBearer(t *testing.T) {
+	claims := jwt.MapClaims{
+		"sub": "user",
+		"act": map[string]any{"client_id": "service-a"},
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantError     string
+	}{
+		{"valid", "Bearer valid", http.StatusOK, ""},
+		{"scheme is case-insensitive", "bearer valid", http.StatusOK, ""},
+		{"missing", "", http.StatusUnauthorized, "Bearer"},
+		{"other scheme", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, "Bearer"},
+		{"empty token", "Bearer  ", http.StatusUnauthorized, "Bearer"},
+		{"invalid", "Bearer forged", http.StatusUnauthorized, `Bearer error="invalid_token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			engine := gin.New()
+			var subject, actor string
+			engine.GET("/", NewBearerMiddleware(staticVerifier{claims}).RequireBearer(), func(c *gin.Context) {
+				subject = c.GetString("subject")
+				if a, ok := c.Get("actor"); ok {
+					actor = a.(*auth.Actor).String()
+				}
+				if _, ok := auth.BearerClaimsFromContext(c.Request.Context()); !ok {
+					t.Error("claims missing from the request context")
+				}
+				c.Status(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, r)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if got := recorder.Header().Get("WWW-Authenticate"); got != tt.wantError {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantError)
+			}
+			if tt.wantStatus == http.StatusOK && (subject != "user" || actor != "service-a") {
+				t.Errorf("subject = %q, actor = %q", subject, actor)
+			}
+		})
+	}
+}
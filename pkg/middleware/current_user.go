@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+// ErrNoUserContext is returned for a request that SetUserID has not run on.
+var ErrNoUserContext = errors.New("no user context; add the SetUserID middleware")
+
+// ErrCustomUserID is returned by UserID and CurrentUser when the session holds
+// an ID from a UserIDResolver rather than a models.User ID.
+var ErrCustomUserID = errors.New("session holds a custom user ID; use TypedClient")
+
+// UserLoader loads a models.User by ID.
+type UserLoader func(ctx context.Context, id uint) (*models.User, error)
+
+// requestUserKey holds the request user in gin.Context.Keys; the request's
+// context uses requestUserContextKey.
+const requestUserKey = "sso_request_user"
+
+type requestUserContextKey struct{}
+
+// requestUser is the signed-in user of one request. The record is loaded on
+// first use and shared by every later call.
+type requestUser struct {
+	value any // the session's user ID: uint, string, or nil if signed out
+	load  UserLoader
+
+	once sync.Once
+	user *models.User
+	err  error
+}
+
+// attachUser makes the session's user available to UserID and CurrentUser
+// through both c and c.Request.Context().
+func attachUser(c *gin.Context, value any, load UserLoader) {
+	user := &requestUser{value: value, load: load}
+	c.Set(requestUserKey, user)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestUserContextKey{}, user))
+}
+
+func requestUserFrom(ctx context.Context) *requestUser {
+	// gin.Context only falls back to the request's context when the engine
+	// enables ContextWithFallback, so look in both places.
+	if c, ok := ctx.(*gin.Context); ok {
+		if value, exists := c.Get(requestUserKey); exists {
+			user, _ := value.(*requestUser)
+			return user
+		}
+		if c.Request == nil {
+			return nil
+		}
+		ctx = c.Request.Context()
+	}
+
+	user, _ := ctx.Value(requestUserContextKey{}).(*requestUser)
+	return user
+}
+
+// UserID returns the ID of the user signed in on the request ctx belongs to.
+// ctx is a *gin.Context or a request's context.Context.
+func UserID(ctx context.Context) (uint, error) {
+	user := requestUserFrom(ctx)
+	if user == nil {
+		return 0, ErrNoUserContext
+	}
+
+	switch id := user.value.(type) {
+	case uint:
+		return id, nil
+	case string:
+		return 0, ErrCustomUserID
+	}
+	return 0, auth.ErrNoSessionUser
+}
+
+// CurrentUser loads the signed-in user. The record is read at most once per
+// request with the ctx of the first call; later calls return the same user or
+// error.
+func CurrentUser(ctx context.Context) (*models.User, error) {
+	id, err := UserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user := requestUserFrom(ctx)
+	user.once.Do(func() {
+		if user.load == nil {
+			user.err = errors.New("no user loader; call SetUserLoader")
+			return
+		}
+		user.user, user.err = user.load(ctx, id)
+		if user.err != nil {
+			user.err = fmt.Errorf("error loading user %d: %w", id, user.err)
+		}
+	})
+	return user.user, user.err
+}
+
+// MustUser is CurrentUser for handlers behind RequireAuth and SetUserID. It
+// panics if the user cannot be loaded; gin's recovery middleware turns that
+// into a 500.
+func MustUser(ctx context.Context) *models.User {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return user
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+var errLoad = errors.New("database unavailable")
+
+// serveUser runs handler behind SetUserID for a session holding values.
+func serveUser(t *testing.T, values map[any]any, load UserLoader, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+
+	sessionStore := newCookieSessionStore()
+	m := NewAuthMiddleware(sessionStore, testSessionName, "/signin")
+	if load != nil {
+		m.SetUserLoader(load)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(gin.Recovery())
+	engine.GET("/", m.SetUserID(), handler)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range signedIn(t, sessionStore, values) {
+		r.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestCurrentUser(t *testing.T) {
+	errNoLoader := errors.New("no loader")
+
+	tests := []struct {
+		name    string
+		values  map[any]any
+		load    error // returned by the loader; errNoLoader leaves it unset
+		wantID  uint
+		idErr   error
+		userErr error
+	}{
+		{"signed in", map[any]any{auth.SessionUserIDKey: uint(7)}, nil, 7, nil, nil},
+		{"signed out", nil, nil, 0, auth.ErrNoSessionUser, auth.ErrNoSessionUser},
+		{"resolved user ID", map[any]any{auth.SessionUserIDKey: "user-7"}, nil, 0, ErrCustomUserID, ErrCustomUserID},
+		{"load fails", map[any]any{auth.SessionUserIDKey: uint(7)}, errLoad, 7, nil, errLoad},
+		{"no loader", map[any]any{auth.SessionUserIDKey: uint(7)}, errNoLoader, 7, nil, errNoLoader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loads := 0
+			var load UserLoader = func(ctx context.Context, id uint) (*models.User, error) {
+				loads++
+				if tt.load != nil {
+					return nil, tt.load
+				}
+				return &models.User{ID: id, Email: "user@example.com"}, nil
+			}
+			if tt.load == errNoLoader {
+				load = nil
+			}
+
+			serveUser(t, tt.values, load, func(c *gin.Context) {
+				// Both contexts see the same user.
+				for _, ctx := range []context.Context{c, c.Request.Context()} {
+					if id, err := UserID(ctx); id != tt.wantID || !errors.Is(err, tt.idErr) {
+						t.Errorf("UserID() = %d, %v, want %d, %v", id, err, tt.wantID, tt.idErr)
+					}
+
+					user, err := CurrentUser(ctx)
+					switch {
+					case tt.userErr == errNoLoader:
+						if err == nil {
+							t.Error("CurrentUser() without a loader succeeded")
+						}
+					case tt.userErr != nil:
+						if !errors.Is(err, tt.userErr) {
+							t.Errorf("CurrentUser() error = %v, want %v", err, tt.userErr)
+						}
+					case err != nil || user.ID != tt.wantID:
+						t.Errorf("CurrentUser() = %+v, %v, want user %d", user, err, tt.wantID)
+					}
+				}
+				c.Status(http.StatusOK)
+			})
+
+			// The user, or the failure to load it, is shared by the request.
+			wantLoads := 0
+			if tt.wantID != 0 && load != nil {
+				wantLoads = 1
+			}
+			if loads != wantLoads {
+				t.Errorf("loaded the user %d times, want %d", loads, wantLoads)
+			}
+		})
+	}
+}
+
+func TestCurrentUserWithoutSetUserID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	for _, ctx := range []context.Context{c, c.Request.Context(), context.Background()} {
+		if _, err := UserID(ctx); !errors.Is(err, ErrNoUserContext) {
+			t.Errorf("UserID() error = %v, want ErrNoUserContext", err)
+		}
+		if _, err := CurrentUser(ctx); !errors.Is(err, ErrNoUserContext) {
+			t.Errorf("CurrentUser() error = %v, want ErrNoUserContext", err)
+		}
+	}
+}
+
+func TestMustUser(t *testing.T) {
+	load := func(ctx context.Context, id uint) (*models.User, error) {
+		return &models.User{ID: id}, nil
+	}
+
+	tests := []struct {
+		name       string
+		values     map[any]any
+		wantStatus int
+	}{
+		{"signed in", map[any]any{auth.SessionUserIDKey: uint(7)}, http.StatusOK},
+		{"signed out", nil, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveUser(t, tt.values, load, func(c *gin.Context) {
+				if user := MustUser(c); user.ID != 7 {
+					t.Errorf("MustUser() = %+v, want user 7", user)
+				}
+				c.Status(http.StatusOK)
+			})
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+		})
+	}
+}
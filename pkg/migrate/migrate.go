@@ -0,0 +1,296 @@
+package migrate
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+//go:embed sql
+var migrationFiles embed.FS
+
+var ErrPendingMigrations = errors.New("database has pending migrations")
+
+// Migration is one versioned SQL file, e.g. sql/postgres/0003_add_scim.sql.
+type Migration struct {
+	Version int64
+	Name    string
+	file    string
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of the table recording applied versions.
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return models.QualifiedTable(models.SchemaMigrationsTable)
+}
+
+// Migrations lists the embedded migrations for db's dialect, oldest first.
+func Migrations(db *gorm.DB) ([]Migration, error) {
+	dialect, err := dialectOf(db)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := fs.ReadDir(migrationFiles, path.Join("sql", dialect))
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		versionText, name, ok := strings.Cut(base, "_")
+		if !ok {
+			continue
+		}
+		version, err := strconv.ParseInt(versionText, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			file:    path.Join("sql", dialect, entry.Name()),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration in order. Each migration and the record
+// of it are written in one transaction where the database allows it; MySQL
+// commits DDL implicitly.
+func Up(db *gorm.DB) error {
+	if err := prepare(db); err != nil {
+		return err
+	}
+
+	statuses, err := Status(db)
+	if err != nil {
+		return err
+	}
+	migrations, err := Migrations(db)
+	if err != nil {
+		return err
+	}
+
+	for i, migration := range migrations {
+		if statuses[i].Applied {
+			continue
+		}
+
+		statements, err := render(db, migration)
+		if err != nil {
+			return err
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, statement := range statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("error applying migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+// AutoMigrate builds the schema from the models with GORM instead of the SQL
+// files, then records every migration as applied so Status and Check agree.
+// Use one path or the other for a given database.
+func AutoMigrate(db *gorm.DB) error {
+	if err := prepare(db); err != nil {
+		return err
+	}
+	if err := db.SetupJoinTable(&models.Group{}, "Members", &models.GroupMember{}); err != nil {
+		return err
+	}
+
+	err := db.AutoMigrate(
+		&models.User{},
+		&models.SshKey{},
+		&models.SshPublicKey{},
+		&models.UserAccessToken{},
+		&models.Group{},
+		&models.GroupMember{},
+	)
+	if err != nil {
+		return fmt.Errorf("error auto-migrating models: %w", err)
+	}
+
+	statuses, err := Status(db)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			continue
+		}
+		record := &schemaMigration{Version: status.Version, Name: status.Name, AppliedAt: time.Now()}
+		if err := db.Create(record).Error; err != nil {
+			return fmt.Errorf("error recording migration %04d_%s: %w", status.Version, status.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Status lists every known migration and whether it has been applied.
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int64]time.Time{}
+	if db.Migrator().HasTable(&schemaMigration{}) {
+		var rows []schemaMigration
+		if err := db.Find(&rows).Error; err != nil {
+			return nil, fmt.Errorf("error reading applied migrations: %w", err)
+		}
+		for _, row := range rows {
+			applied[row.Version] = row.AppliedAt
+		}
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Check returns an error wrapping ErrPendingMigrations if any migration has
+// not been applied, for use at startup or in a readiness probe.
+func Check(db *gorm.DB) error {
+	statuses, err := Status(db)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, fmt.Sprintf("%04d_%s", status.Version, status.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %s", ErrPendingMigrations, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// prepare creates the configured schema on Postgres and the table recording
+// applied migrations.
+func prepare(db *gorm.DB) error {
+	if schema := models.TableSchema(); schema != "" && db.Dialector.Name() == "postgres" {
+		if err := db.Exec("CREATE SCHEMA IF NOT EXISTS " + quote(db, schema)).Error; err != nil {
+			return fmt.Errorf("error creating schema %s: %w", schema, err)
+		}
+	}
+
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return fmt.Errorf("error creating migrations table: %w", err)
+	}
+	return nil
+}
+
+// render fills in the configured table names and splits the file into
+// statements.
+func render(db *gorm.DB, migration Migration) ([]string, error) {
+	source, err := migrationFiles.ReadFile(migration.file)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := models.TableSchema()
+	qualify := func(name string) string {
+		if schema == "" {
+			return quote(db, name)
+		}
+		return quote(db, schema) + "." + quote(db, name)
+	}
+
+	funcs := template.FuncMap{
+		"table": func(table string) string { return qualify(models.Table(table)) },
+		"name":  func(table string) string { return quote(db, models.Table(table)) },
+		"index": func(table, column string) string {
+			index := "idx_" + models.Table(table) + "_" + column
+			// SQLite puts the schema on the index rather than on its table.
+			if db.Dialector.Name() == "sqlite" {
+				return qualify(index)
+			}
+			return quote(db, index)
+		},
+	}
+
+	tmpl, err := template.New(migration.file).Funcs(funcs).Parse(string(source))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing migration %s: %w", migration.file, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("error rendering migration %s: %w", migration.file, err)
+	}
+
+	var statements []string
+	for _, statement := range strings.Split(buf.String(), ";\n") {
+		if statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";")); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements, nil
+}
+
+func quote(db *gorm.DB, name string) string {
+	var buf strings.Builder
+	db.Dialector.QuoteTo(&buf, name)
+	return buf.String()
+}
+
+func dialectOf(db *gorm.DB) (string, error) {
+	switch name := db.Dialector.Name(); name {
+	case "postgres", "mysql", "sqlite":
+		return name, nil
+	default:
+		return "", fmt.Errorf("no migrations for database %q; use AutoMigrate", name)
+	}
+}
@@ -0,0 +1,205 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+// The layout is fixed per process, so every test here runs against renamed
+// tables; the default names are covered by the repository tests.
+var renamedTables = map[string]string{
+	models.UsersTable:        "accounts",
+	models.GroupMembersTable: "team_members",
+}
+
+func TestMain(m *testing.M) {
+	if err := models.ConfigureTables("", renamedTables); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestDB opens a private, empty in-memory SQLite database.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s_%p?mode=memory&cache=shared", name, t)), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// columns lists a table's columns, sorted.
+func columns(t *testing.T, db *gorm.DB, table string) []string {
+	t.Helper()
+
+	types, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(types))
+	for _, column := range types {
+		names = append(names, column.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+var tables = []string{"accounts", "ssh_keys", "ssh_public_keys", "user_access_tokens", "groups", "team_members"}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations(newTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for i, migration := range migrations {
+		if migration.Version != int64(i+1) {
+			t.Errorf("migration %d has version %d", i, migration.Version)
+		}
+		names = append(names, migration.Name)
+	}
+	want := []string{"create_core_tables", "create_ssh_public_keys", "add_scim", "add_access_token_consumed_at", "add_user_status"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("migrations = %v, want %v", names, want)
+	}
+}
+
+func TestUp(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := Check(db); !errors.Is(err, ErrPendingMigrations) {
+		t.Fatalf("Check() on an empty database error = %v, want ErrPendingMigrations", err)
+	}
+	if err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+	// Applied migrations are skipped.
+	if err := Up(db); err != nil {
+		t.Fatalf("second Up() error = %v", err)
+	}
+
+	for _, table := range tables {
+		if !db.Migrator().HasTable(table) {
+			t.Errorf("table %s is missing", table)
+		}
+	}
+	for _, table := range []string{"users", "group_members"} {
+		if db.Migrator().HasTable(table) {
+			t.Errorf("table %s was created under its default name", table)
+		}
+	}
+	if !db.Migrator().HasIndex("accounts", "idx_accounts_email") {
+		t.Error("index idx_accounts_email is missing")
+	}
+
+	statuses, err := Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt == nil {
+			t.Errorf("migration %04d_%s not recorded", status.Version, status.Name)
+		}
+	}
+	if err := Check(db); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+}
+
+func TestStatusReportsPendingMigrations(t *testing.T) {
+	db := newTestDB(t)
+	if err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("version > ?", 3).Delete(&schemaMigration{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Applied != (status.Version <= 3) {
+			t.Errorf("migration %04d_%s applied = %v", status.Version, status.Name, status.Applied)
+		}
+	}
+
+	err = Check(db)
+	if !errors.Is(err, ErrPendingMigrations) || !strings.Contains(err.Error(), "0004_add_access_token_consumed_at, 0005_add_user_status") {
+		t.Errorf("Check() error = %v, want the pending versions listed", err)
+	}
+}
+
+func TestAutoMigrateMatchesUp(t *testing.T) {
+	migrated := newTestDB(t)
+	if err := Up(migrated); err != nil {
+		t.Fatal(err)
+	}
+	auto := newTestDB(t)
+	if err := AutoMigrate(auto); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range tables {
+		want := columns(t, migrated, table)
+		if got := columns(t, auto, table); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s columns = %v, want %v", table, got, want)
+		}
+	}
+	if err := Check(auto); err != nil {
+		t.Errorf("Check() after AutoMigrate error = %v", err)
+	}
+}
+
+func TestMigratedSchemaFitsModels(t *testing.T) {
+	db := newTestDB(t)
+	if err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetupJoinTable(&models.Group{}, "Members", &models.GroupMember{}); err != nil {
+		t.Fatal(err)
+	}
+
+	user := &models.User{Email: "user@example.com", Name: "User"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	group := &models.Group{DisplayName: "Staff", Members: []models.User{*user}}
+	if err := db.Create(group).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.UserAccessToken{UserID: user.ID, JTI: "jti"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded models.Group
+	if err := db.Preload("Members").First(&loaded, group.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Members) != 1 || loaded.Members[0].Email != user.Email || !loaded.Members[0].IsActive() {
+		t.Errorf("group members = %+v, want the active user", loaded.Members)
+	}
+}
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS {{table "users"}} (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	email VARCHAR(255) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	created_at DATETIME(3) NULL,
+	updated_at DATETIME(3) NULL,
+	UNIQUE KEY {{index "users" "email"}} (email)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS {{table "ssh_keys"}} (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	`key` TEXT NOT NULL,
+	created_at DATETIME(3) NULL,
+	updated_at DATETIME(3) NULL
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS {{table "user_access_tokens"}} (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	user_id BIGINT UNSIGNED NOT NULL,
+	jti VARCHAR(255) NOT NULL,
+	created_at DATETIME(3) NULL,
+	updated_at DATETIME(3) NULL,
+	UNIQUE KEY {{index "user_access_tokens" "jti"}} (jti),
+	KEY {{index "user_access_tokens" "user_id"}} (user_id),
+	FOREIGN KEY (user_id) REFERENCES {{table "users"}} (id) ON DELETE CASCADE
+) ENGINE=InnoDB;
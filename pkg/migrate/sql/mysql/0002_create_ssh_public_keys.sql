@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS {{table "ssh_public_keys"}} (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	kid VARCHAR(255) NULL,
+	`key` TEXT NOT NULL,
+	created_at DATETIME(3) NULL,
+	updated_at DATETIME(3) NULL,
+	KEY {{index "ssh_public_keys" "kid"}} (kid)
+) ENGINE=InnoDB;
@@ -0,0 +1,23 @@
+ALTER TABLE {{table "users"}}
+	ADD COLUMN external_id VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE,
+	ADD KEY {{index "users" "external_id"}} (external_id);
+
+CREATE TABLE IF NOT EXISTS {{table "groups"}} (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	display_name VARCHAR(255) NOT NULL,
+	external_id VARCHAR(255) NOT NULL DEFAULT '',
+	created_at DATETIME(3) NULL,
+	updated_at DATETIME(3) NULL,
+	UNIQUE KEY {{index "groups" "display_name"}} (display_name),
+	KEY {{index "groups" "external_id"}} (external_id)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS {{table "group_members"}} (
+	group_id BIGINT UNSIGNED NOT NULL,
+	user_id BIGINT UNSIGNED NOT NULL,
+	PRIMARY KEY (group_id, user_id),
+	KEY {{index "group_members" "user_id"}} (user_id),
+	FOREIGN KEY (group_id) REFERENCES {{table "groups"}} (id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES {{table "users"}} (id) ON DELETE CASCADE
+) ENGINE=InnoDB;
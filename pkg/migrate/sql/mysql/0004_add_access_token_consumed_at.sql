@@ -0,0 +1,4 @@
+ALTER TABLE {{table "user_access_tokens"}}
+	ADD COLUMN consumed_at DATETIME(3) NULL,
+	ADD KEY {{index "user_access_tokens" "consumed_at"}} (consumed_at),
+	ADD KEY {{index "user_access_tokens" "created_at"}} (created_at);
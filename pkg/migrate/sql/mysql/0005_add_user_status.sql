@@ -0,0 +1,3 @@
+ALTER TABLE {{table "users"}}
+	ADD COLUMN locked_at DATETIME(3) NULL,
+	ADD COLUMN deleted_at DATETIME(3) NULL;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS {{table "users"}} (
+	id BIGSERIAL PRIMARY KEY,
+	email VARCHAR(255) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS {{index "users" "email"}} ON {{table "users"}} (email);
+
+CREATE TABLE IF NOT EXISTS {{table "ssh_keys"}} (
+	id BIGSERIAL PRIMARY KEY,
+	key TEXT NOT NULL,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS {{table "user_access_tokens"}} (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES {{table "users"}} (id) ON DELETE CASCADE,
+	jti VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS {{index "user_access_tokens" "jti"}} ON {{table "user_access_tokens"}} (jti);
+CREATE INDEX IF NOT EXISTS {{index "user_access_tokens" "user_id"}} ON {{table "user_access_tokens"}} (user_id);
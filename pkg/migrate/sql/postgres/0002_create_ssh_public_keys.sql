@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS {{table "ssh_public_keys"}} (
+	id BIGSERIAL PRIMARY KEY,
+	kid VARCHAR(255),
+	key TEXT NOT NULL,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS {{index "ssh_public_keys" "kid"}} ON {{table "ssh_public_keys"}} (kid);
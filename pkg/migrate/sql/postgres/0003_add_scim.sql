@@ -0,0 +1,20 @@
+ALTER TABLE {{table "users"}} ADD COLUMN IF NOT EXISTS external_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE {{table "users"}} ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE;
+CREATE INDEX IF NOT EXISTS {{index "users" "external_id"}} ON {{table "users"}} (external_id);
+
+CREATE TABLE IF NOT EXISTS {{table "groups"}} (
+	id BIGSERIAL PRIMARY KEY,
+	display_name VARCHAR(255) NOT NULL,
+	external_id VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS {{index "groups" "display_name"}} ON {{table "groups"}} (display_name);
+CREATE INDEX IF NOT EXISTS {{index "groups" "external_id"}} ON {{table "groups"}} (external_id);
+
+CREATE TABLE IF NOT EXISTS {{table "group_members"}} (
+	group_id BIGINT NOT NULL REFERENCES {{table "groups"}} (id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES {{table "users"}} (id) ON DELETE CASCADE,
+	PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS {{index "group_members" "user_id"}} ON {{table "group_members"}} (user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE {{table "users"}} ADD COLUMN IF NOT EXISTS locked_at TIMESTAMPTZ;
+ALTER TABLE {{table "users"}} ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS {{table "users"}} (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL,
+	name TEXT NOT NULL,
+	created_at DATETIME,
+	updated_at DATETIME
+);
+CREATE UNIQUE INDEX IF NOT EXISTS {{index "users" "email"}} ON {{name "users"}} (email);
+
+CREATE TABLE IF NOT EXISTS {{table "ssh_keys"}} (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	key TEXT NOT NULL,
+	created_at DATETIME,
+	updated_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS {{table "user_access_tokens"}} (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES {{name "users"}} (id) ON DELETE CASCADE,
+	jti TEXT NOT NULL,
+	created_at DATETIME,
+	updated_at DATETIME
+);
+CREATE UNIQUE INDEX IF NOT EXISTS {{index "user_access_tokens" "jti"}} ON {{name "user_access_tokens"}} (jti);
+CREATE INDEX IF NOT EXISTS {{index "user_access_tokens" "user_id"}} ON {{name "user_access_tokens"}} (user_id);
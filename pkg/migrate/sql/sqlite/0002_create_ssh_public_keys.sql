@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS {{table "ssh_public_keys"}} (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kid TEXT,
+	key TEXT NOT NULL,
+	created_at DATETIME,
+	updated_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS {{index "ssh_public_keys" "kid"}} ON {{name "ssh_public_keys"}} (kid);
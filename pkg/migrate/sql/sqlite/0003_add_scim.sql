@@ -0,0 +1,20 @@
+ALTER TABLE {{table "users"}} ADD COLUMN external_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE {{table "users"}} ADD COLUMN active BOOLEAN NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS {{index "users" "external_id"}} ON {{name "users"}} (external_id);
+
+CREATE TABLE IF NOT EXISTS {{table "groups"}} (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	display_name TEXT NOT NULL,
+	external_id TEXT NOT NULL DEFAULT '',
+	created_at DATETIME,
+	updated_at DATETIME
+);
+CREATE UNIQUE INDEX IF NOT EXISTS {{index "groups" "display_name"}} ON {{name "groups"}} (display_name);
+CREATE INDEX IF NOT EXISTS {{index "groups" "external_id"}} ON {{name "groups"}} (external_id);
+
+CREATE TABLE IF NOT EXISTS {{table "group_members"}} (
+	group_id INTEGER NOT NULL REFERENCES {{name "groups"}} (id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES {{name "users"}} (id) ON DELETE CASCADE,
+	PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS {{index "group_members" "user_id"}} ON {{name "group_members"}} (user_id);
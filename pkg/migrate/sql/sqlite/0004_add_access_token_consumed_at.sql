@@ -0,0 +1,3 @@
+ALTER TABLE {{table "user_access_tokens"}} ADD COLUMN consumed_at DATETIME;
+CREATE INDEX IF NOT EXISTS {{index "user_access_tokens" "consumed_at"}} ON {{name "user_access_tokens"}} (consumed_at);
+CREATE INDEX IF NOT EXISTS {{index "user_access_tokens" "created_at"}} ON {{name "user_access_tokens"}} (created_at);
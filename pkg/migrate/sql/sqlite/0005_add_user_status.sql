@@ -0,0 +1,2 @@
+ALTER TABLE {{table "users"}} ADD COLUMN locked_at DATETIME;
+ALTER TABLE {{table "users"}} ADD COLUMN deleted_at DATETIME;
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Default table names. Every table can be renamed and moved to another
+// schema with ConfigureTables.
+const (
+	UsersTable            = "users"
+	SshKeysTable          = "ssh_keys"
+	SshPublicKeysTable    = "ssh_public_keys"
+	UserAccessTokensTable = "user_access_tokens"
+	GroupsTable           = "groups"
+	GroupMembersTable     = "group_members"
+	SchemaMigrationsTable = "schema_migrations"
+)
+
+var ErrTablesConfigured = errors.New("tables are already configured with a different layout")
+
+var (
+	tablesMu         sync.RWMutex
+	tableSchema      string
+	tableNames       = map[string]string{}
+	tablesConfigured bool
+	// tablesUsed is set once a name has been handed out, which GORM may
+	// have cached with the models' schema.
+	tablesUsed atomic.Bool
+)
+
+// ConfigureTables sets the schema every table lives in and renames tables by
+// their default name. GORM caches table names, so the layout is fixed for the
+// process once it has been configured or the models have been used: a
+// different layout then returns ErrTablesConfigured, the same one is a no-op.
+func ConfigureTables(schema string, names map[string]string) error {
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	configured := make(map[string]string, len(names))
+	for table, name := range names {
+		if name != "" && name != table {
+			configured[table] = name
+		}
+	}
+
+	if tablesConfigured || tablesUsed.Load() {
+		if schema == tableSchema && sameNames(configured, tableNames) {
+			return nil
+		}
+		return fmt.Errorf("error configuring tables: %w", ErrTablesConfigured)
+	}
+
+	tableSchema = schema
+	tableNames = configured
+	tablesConfigured = true
+	return nil
+}
+
+func sameNames(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for table, name := range a {
+		if b[table] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// Table returns the configured name of a table, without its schema.
+func Table(table string) string {
+	tablesUsed.Store(true)
+
+	tablesMu.RLock()
+	defer tablesMu.RUnlock()
+
+	if name := tableNames[table]; name != "" {
+		return name
+	}
+	return table
+}
+
+// TableSchema returns the configured schema, or "" for the default one.
+func TableSchema() string {
+	tablesUsed.Store(true)
+
+	tablesMu.RLock()
+	defer tablesMu.RUnlock()
+
+	return tableSchema
+}
+
+// QualifiedTable returns a table's configured name prefixed with its schema.
+func QualifiedTable(table string) string {
+	if schema := TableSchema(); schema != "" {
+		return schema + "." + Table(table)
+	}
+	return Table(table)
+}
+
+func (User) TableName() string            { return QualifiedTable(UsersTable) }
+func (SshKey) TableName() string          { return QualifiedTable(SshKeysTable) }
+func (SshPublicKey) TableName() string    { return QualifiedTable(SshPublicKeysTable) }
+func (UserAccessToken) TableName() string { return QualifiedTable(UserAccessTokensTable) }
+func (Group) TableName() string           { return QualifiedTable(GroupsTable) }
+func (GroupMember) TableName() string     { return QualifiedTable(GroupMembersTable) }
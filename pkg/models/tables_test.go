@@ -0,0 +1,88 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+// resetTables restores the default layout as if the process had just
+// started.
+func resetTables(t *testing.T) {
+	t.Helper()
+
+	reset := func() {
+		tablesMu.Lock()
+		defer tablesMu.Unlock()
+		tableSchema = ""
+		tableNames = map[string]string{}
+		tablesConfigured = false
+		tablesUsed.Store(false)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestConfigureTables(t *testing.T) {
+	resetTables(t)
+
+	if err := ConfigureTables("auth", map[string]string{UsersTable: "accounts", GroupsTable: ""}); err != nil {
+		t.Fatal(err)
+	}
+	if got := (User{}).TableName(); got != "auth.accounts" {
+		t.Errorf("User table = %q, want auth.accounts", got)
+	}
+	if got := (Group{}).TableName(); got != "auth.groups" {
+		t.Errorf("Group table = %q, want auth.groups", got)
+	}
+	if got := Table(UsersTable); got != "accounts" {
+		t.Errorf("Table(users) = %q, want accounts", got)
+	}
+}
+
+func TestConfigureTablesAgain(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		names   map[string]string
+		wantErr bool
+	}{
+		{"same layout", "auth", map[string]string{UsersTable: "accounts"}, false},
+		{"same layout with default names spelled out", "auth", map[string]string{UsersTable: "accounts", SshKeysTable: SshKeysTable}, false},
+		{"other schema", "", map[string]string{UsersTable: "accounts"}, true},
+		{"other name", "auth", map[string]string{UsersTable: "members"}, true},
+		{"extra name", "auth", map[string]string{UsersTable: "accounts", GroupsTable: "teams"}, true},
+		{"default names", "auth", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTables(t)
+			if err := ConfigureTables("auth", map[string]string{UsersTable: "accounts"}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ConfigureTables(tt.schema, tt.names)
+			if tt.wantErr != errors.Is(err, ErrTablesConfigured) {
+				t.Fatalf("ConfigureTables() error = %v, want error %v", err, tt.wantErr)
+			}
+			// A refused layout leaves the first one in place.
+			if got := (User{}).TableName(); got != "auth.accounts" {
+				t.Errorf("User table = %q, want auth.accounts", got)
+			}
+		})
+	}
+}
+
+func TestConfigureTablesAfterUse(t *testing.T) {
+	resetTables(t)
+
+	if got := (User{}).TableName(); got != UsersTable {
+		t.Fatalf("User table = %q, want %s", got, UsersTable)
+	}
+	// GORM may have cached the default names by now.
+	if err := ConfigureTables("", map[string]string{UsersTable: "accounts"}); !errors.Is(err, ErrTablesConfigured) {
+		t.Errorf("ConfigureTables() error = %v, want ErrTablesConfigured", err)
+	}
+	if err := ConfigureTables("", nil); err != nil {
+		t.Errorf("ConfigureTables() with the default layout error = %v", err)
+	}
+}
@@ -5,11 +5,48 @@ import (
 )
 
 type User struct {
-	ID        uint      `gorm:"primaryKey"`
-	Email     string    `gorm:"uniqueIndex;not null"`
-	Name      string    `gorm:"not null"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	ID         uint       `gorm:"primaryKey"`
+	Email      string     `gorm:"uniqueIndex;not null"`
+	Name       string     `gorm:"not null"`
+	ExternalID string     `gorm:"column:external_id;index;not null;default:''"` // identifier assigned by a SCIM client
+	Active     *bool      `gorm:"not null;default:true"`                        // nil means active; see IsActive
+	LockedAt   *time.Time // set while the account is locked
+	DeletedAt  *time.Time // set by applications that soft-delete users; Delete still removes the row
+	CreatedAt  time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime"`
+}
+
+// IsActive reports whether the user is active. Active is a pointer so that an
+// explicit false is written on create rather than replaced by the column
+// default.
+func (u *User) IsActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+// SetActive sets Active to a copy of active.
+func (u *User) SetActive(active bool) {
+	u.Active = &active
+}
+
+// Disabled reports whether the user may no longer sign in or use a session.
+func (u *User) Disabled() bool {
+	return !u.IsActive() || u.LockedAt != nil || u.DeletedAt != nil
+}
+
+// Group is a set of users provisioned through SCIM.
+type Group struct {
+	ID          uint      `gorm:"primaryKey"`
+	DisplayName string    `gorm:"uniqueIndex;not null"`
+	ExternalID  string    `gorm:"column:external_id;index;not null;default:''"`
+	Members     []User    `gorm:"many2many:group_members"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+}
+
+// GroupMember is the join table between groups and users.
+type GroupMember struct {
+	GroupID uint `gorm:"primaryKey"`
+	UserID  uint `gorm:"primaryKey;index"`
 }
 
 type SshKey struct {
@@ -19,11 +56,22 @@ type SshKey struct {
 	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
 }
 
-type UserAccessToken struct {
+// SshPublicKey holds a PEM public key or certificate chain for deployments
+// that must not store the identity provider's private key.
+type SshPublicKey struct {
 	ID        uint      `gorm:"primaryKey"`
-	UserID    uint      `gorm:"index;not null"`
-	JTI       string    `gorm:"uniqueIndex;not null"`
+	KID       string    `gorm:"column:kid;index"`
+	PublicKey string    `gorm:"column:key;not null"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime"`
-	User      User      `gorm:"foreignKey:UserID"`
+}
+
+type UserAccessToken struct {
+	ID         uint       `gorm:"primaryKey"`
+	UserID     uint       `gorm:"index;not null"`
+	JTI        string     `gorm:"uniqueIndex;not null"`
+	ConsumedAt *time.Time `gorm:"index"` // set when a callback signs the user in with it
+	CreatedAt  time.Time  `gorm:"autoCreateTime;index"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime"`
+	User       User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
 }
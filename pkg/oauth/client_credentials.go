@@ -0,0 +1,260 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/singleflight"
+)
+
+const (
+	clientAssertionType     = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionLifetime = 5 * time.Minute
+	defaultRefreshAhead     = 60 * time.Second
+)
+
+// ClientCredentialsTokenSource fetches and caches tokens for the client itself
+// using the client_credentials grant, for callers without a user session such
+// as background jobs. The client authenticates with its secret or, when an
+// assertion key is configured, with a private_key_jwt assertion.
+type ClientCredentialsTokenSource struct {
+	client       *TokenClient
+	scopes       []string
+	audience     string
+	refreshAhead time.Duration
+
+	assertionKey crypto.Signer
+	assertionKID string
+	assertionAlg jwt.SigningMethod
+
+	mu    sync.Mutex
+	token *Token
+	group singleflight.Group
+}
+
+func NewClientCredentialsTokenSource(cfg *config.Config) (*ClientCredentialsTokenSource, error) {
+	if cfg.TokenURL == "" || cfg.ClientID == "" {
+		return nil, errors.New("token_url and client_id are required for client credentials")
+	}
+
+	source := &ClientCredentialsTokenSource{
+		client:       NewTokenClient(cfg.TokenURL, cfg.ClientID, cfg.ClientSecret),
+		scopes:       cfg.ClientCredentialsScopes,
+		audience:     cfg.ClientCredentialsAudience,
+		refreshAhead: defaultRefreshAhead,
+	}
+	if cfg.AccessTokenRefreshLeeway > 0 {
+		source.refreshAhead = time.Duration(cfg.AccessTokenRefreshLeeway) * time.Second
+	}
+
+	if cfg.ClientAssertionKey != "" {
+		key, alg, err := parseAssertionKey([]byte(cfg.ClientAssertionKey))
+		if err != nil {
+			return nil, err
+		}
+		// private_key_jwt replaces the secret; sending both is rejected by
+		// most servers.
+		source.client.ClientSecret = ""
+		source.assertionKey = key
+		source.assertionKID = cfg.ClientAssertionKeyID
+		source.assertionAlg = alg
+	} else if cfg.ClientSecret == "" {
+		return nil, errors.New("client_secret or client_assertion_key is required for client credentials")
+	}
+
+	return source, nil
+}
+
+// SetHTTPClient replaces the client used to reach the token endpoint.
+func (s *ClientCredentialsTokenSource) SetHTTPClient(httpClient *http.Client) {
+	s.client.HTTPClient = httpClient
+}
+
+// Token returns the cached token, fetching a new one once the cached token is
+// within the refresh window of its expiry. Concurrent callers share one fetch;
+// a caller whose ctx ends stops waiting without cancelling it for the others.
+func (s *ClientCredentialsTokenSource) Token(ctx context.Context) (*Token, error) {
+	if token := s.cached(); token != nil {
+		return token, nil
+	}
+
+	value, err := s.group.DoContext(ctx, "token", func() (any, error) {
+		if token := s.cached(); token != nil {
+			return token, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		defer cancel()
+		token, err := s.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		s.token = token
+		s.mu.Unlock()
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Token), nil
+}
+
+// cached returns the cached token while it is outside the refresh window.
+func (s *ClientCredentialsTokenSource) cached() *Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && (s.token.Expiry.IsZero() || time.Until(s.token.Expiry) > s.refreshAhead) {
+		return s.token
+	}
+	return nil
+}
+
+// AccessToken returns the access token of Token.
+func (s *ClientCredentialsTokenSource) AccessToken(ctx context.Context) (string, error) {
+	token, err := s.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// Invalidate drops the cached token if it is still rejected, so the next call
+// fetches a new one.
+func (s *ClientCredentialsTokenSource) Invalidate(rejected string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.token.AccessToken == rejected {
+		s.token = nil
+	}
+}
+
+// RoundTripper returns a transport that authenticates requests with the
+// source's token, fetching a new token and retrying once on 401.
+func (s *ClientCredentialsTokenSource) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	return &BearerTransport{
+		Base:  base,
+		Token: s.AccessToken,
+		Refresh: func(ctx context.Context, rejected string) (string, error) {
+			s.Invalidate(rejected)
+			return s.AccessToken(ctx)
+		},
+	}
+}
+
+// HTTPClient returns a client using RoundTripper over the default transport.
+func (s *ClientCredentialsTokenSource) HTTPClient() *http.Client {
+	return &http.Client{Transport: s.RoundTripper(nil)}
+}
+
+func (s *ClientCredentialsTokenSource) fetch(ctx context.Context) (*Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	if s.audience != "" {
+		form.Set("audience", s.audience)
+	}
+
+	if s.assertionKey != nil {
+		assertion, err := s.clientAssertion()
+		if err != nil {
+			return nil, err
+		}
+		form.Set("client_assertion_type", clientAssertionType)
+		form.Set("client_assertion", assertion)
+	}
+
+	return s.client.Exchange(ctx, form)
+}
+
+// clientAssertion builds the private_key_jwt assertion of RFC 7523 section 2.2.
+func (s *ClientCredentialsTokenSource) clientAssertion() (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(s.assertionAlg, jwt.RegisteredClaims{
+		Issuer:    s.client.ClientID,
+		Subject:   s.client.ClientID,
+		Audience:  jwt.ClaimStrings{s.client.TokenURL},
+		ID:        base64.RawURLEncoding.EncodeToString(jti),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	})
+	if s.assertionKID != "" {
+		token.Header["kid"] = s.assertionKID
+	}
+
+	signed, err := token.SignedString(s.assertionKey)
+	if err != nil {
+		return "", fmt.Errorf("error signing client assertion: %w", err)
+	}
+	return signed, nil
+}
+
+// parseAssertionKey reads the client's PEM private key and picks the signing
+// algorithm that matches it.
+func parseAssertionKey(data []byte) (crypto.Signer, jwt.SigningMethod, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM data found in client assertion key")
+	}
+
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing client assertion key: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return k, jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return k, jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return k, jwt.SigningMethodES512, nil
+		}
+	case ed25519.PrivateKey:
+		return k, jwt.SigningMethodEdDSA, nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported client assertion key type %T", key)
+}
@@ -0,0 +1,348 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+)
+
+// credentialsServer answers client_credentials grants with "token-<n>". It
+// keeps the last form it received; release, when set, holds every response
+// until it is closed.
+type credentialsServer struct {
+	*httptest.Server
+	requests  atomic.Int32
+	expiresIn int
+	fail      atomic.Bool
+	release   chan struct{}
+
+	mu       sync.Mutex
+	form     map[string]string
+	username string
+}
+
+func newCredentialsServer(t *testing.T) *credentialsServer {
+	t.Helper()
+
+	cs := &credentialsServer{expiresIn: 3600}
+	cs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cs.release != nil {
+			<-cs.release
+		}
+		r.ParseForm()
+		username, _, _ := r.BasicAuth()
+		cs.mu.Lock()
+		cs.form = map[string]string{}
+		for key := range r.PostForm {
+			cs.form[key] = r.PostForm.Get(key)
+		}
+		cs.username = username
+		cs.mu.Unlock()
+
+		n := cs.requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if cs.fail.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"bad secret"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "token-" + strconv.Itoa(int(n)),
+			"token_type":   "Bearer",
+			"expires_in":   cs.expiresIn,
+		})
+	}))
+	t.Cleanup(cs.Close)
+	return cs
+}
+
+func (cs *credentialsServer) lastForm() (map[string]string, string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.form, cs.username
+}
+
+func newCredentialsSource(t *testing.T, cs *credentialsServer, configure func(*config.Config)) *ClientCredentialsTokenSource {
+	t.Helper()
+
+	cfg := &config.Config{
+		TokenURL:                  cs.URL,
+		ClientID:                  "worker",
+		ClientSecret:              "secret",
+		ClientCredentialsScopes:   []string{"reports:read", "reports:write"},
+		ClientCredentialsAudience: "https://reports.internal",
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	source, err := NewClientCredentialsTokenSource(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func TestNewClientCredentialsTokenSourceRequiresCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{"no token URL", config.Config{ClientID: "worker", ClientSecret: "secret"}},
+		{"no client ID", config.Config{TokenURL: "https://sso/token", ClientSecret: "secret"}},
+		{"no secret or key", config.Config{TokenURL: "https://sso/token", ClientID: "worker"}},
+		{"bad key", config.Config{TokenURL: "https://sso/token", ClientID: "worker", ClientAssertionKey: "not a key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClientCredentialsTokenSource(&tt.cfg); err == nil {
+				t.Error("NewClientCredentialsTokenSource() error = nil")
+			}
+		})
+	}
+}
+
+func TestClientCredentialsFetch(t *testing.T) {
+	cs := newCredentialsServer(t)
+	source := newCredentialsSource(t, cs, nil)
+
+	token, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "token-1" || token.Expiry.IsZero() {
+		t.Errorf("Token() = %+v", token)
+	}
+
+	form, username := cs.lastForm()
+	want := map[string]string{
+		"grant_type": "client_credentials",
+		"scope":      "reports:read reports:write",
+		"audience":   "https://reports.internal",
+	}
+	for key, value := range want {
+		if form[key] != value {
+			t.Errorf("form %s = %q, want %q", key, form[key], value)
+		}
+	}
+	if username != "worker" {
+		t.Errorf("basic auth user = %q, want worker", username)
+	}
+}
+
+func TestClientCredentialsCachesToken(t *testing.T) {
+	cs := newCredentialsServer(t)
+	source := newCredentialsSource(t, cs, nil)
+
+	for i := 0; i < 3; i++ {
+		token, err := source.AccessToken(context.Background())
+		if err != nil || token != "token-1" {
+			t.Fatalf("AccessToken() = %q, %v, want token-1", token, err)
+		}
+	}
+	if got := cs.requests.Load(); got != 1 {
+		t.Errorf("token requests = %d, want 1", got)
+	}
+
+	source.Invalidate("someone-elses-token")
+	if token, _ := source.AccessToken(context.Background()); token != "token-1" {
+		t.Errorf("AccessToken() after invalidating another token = %q, want token-1", token)
+	}
+
+	source.Invalidate("token-1")
+	if token, _ := source.AccessToken(context.Background()); token != "token-2" {
+		t.Errorf("AccessToken() after Invalidate = %q, want token-2", token)
+	}
+}
+
+func TestClientCredentialsRefreshLeeway(t *testing.T) {
+	tests := []struct {
+		name      string
+		leeway    int
+		expiresIn int
+		want      int32
+	}{
+		{"outside default leeway", 0, 3600, 1},
+		{"inside default leeway", 0, 30, 2},
+		{"outside configured leeway", 10, 30, 1},
+		{"inside configured leeway", 120, 90, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newCredentialsServer(t)
+			cs.expiresIn = tt.expiresIn
+			source := newCredentialsSource(t, cs, func(cfg *config.Config) {
+				cfg.AccessTokenRefreshLeeway = tt.leeway
+			})
+
+			for i := 0; i < 2; i++ {
+				if _, err := source.Token(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := cs.requests.Load(); got != tt.want {
+				t.Errorf("token requests = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientCredentialsError(t *testing.T) {
+	cs := newCredentialsServer(t)
+	cs.fail.Store(true)
+	source := newCredentialsSource(t, cs, nil)
+
+	_, err := source.Token(context.Background())
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_client" || oauthErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Token() error = %v, want invalid_client", err)
+	}
+
+	// Errors are not cached.
+	cs.fail.Store(false)
+	if token, err := source.AccessToken(context.Background()); err != nil || token != "token-2" {
+		t.Errorf("AccessToken() after error = %q, %v, want token-2", token, err)
+	}
+}
+
+func TestClientCredentialsSharesFetch(t *testing.T) {
+	cs := newCredentialsServer(t)
+	cs.release = make(chan struct{})
+	source := newCredentialsSource(t, cs, nil)
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := source.AccessToken(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			tokens[i] = token
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(cs.release)
+	wg.Wait()
+
+	if got := cs.requests.Load(); got != 1 {
+		t.Errorf("token requests = %d, want 1", got)
+	}
+	for _, token := range tokens {
+		if token != "token-1" {
+			t.Errorf("AccessToken() = %q, want token-1", token)
+		}
+	}
+}
+
+func TestClientCredentialsWaiterHonorsContext(t *testing.T) {
+	cs := newCredentialsServer(t)
+	cs.release = make(chan struct{})
+	source := newCredentialsSource(t, cs, nil)
+
+	first := make(chan string, 1)
+	go func() {
+		token, _ := source.AccessToken(context.Background())
+		first <- token
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// A waiter gives up at its own deadline while the fetch is in flight.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := source.Token(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Token() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	close(cs.release)
+	if token := <-first; token != "token-1" {
+		t.Errorf("first caller got %q, want token-1", token)
+	}
+}
+
+func TestClientCredentialsFetchOutlivesCanceledCaller(t *testing.T) {
+	cs := newCredentialsServer(t)
+	cs.release = make(chan struct{})
+	source := newCredentialsSource(t, cs, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := source.Token(ctx)
+		first <- err
+	}()
+	second := make(chan string, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		token, _ := source.AccessToken(context.Background())
+		second <- token
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller error = %v, want context.Canceled", err)
+	}
+
+	close(cs.release)
+	if token := <-second; token != "token-1" {
+		t.Errorf("waiting caller got %q, want token-1", token)
+	}
+	if got := cs.requests.Load(); got != 1 {
+		t.Errorf("token requests = %d, want 1", got)
+	}
+}
+
+func TestClientCredentialsPrivateKeyJWT(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := newCredentialsServer(t)
+	source := newCredentialsSource(t, cs, func(cfg *config.Config) {
+		cfg.ClientAssertionKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		cfg.ClientAssertionKeyID = "worker-key"
+	})
+
+	if _, err := source.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	form, username := cs.lastForm()
+	if username != "" || form["client_id"] != "worker" || form["client_assertion_type"] != clientAssertionType {
+		t.Fatalf("basic auth user = %q, form = %v", username, form)
+	}
+
+	claims := jwt.RegisteredClaims{}
+	assertion, err := jwt.ParseWithClaims(form["client_assertion"], &claims, func(*jwt.Token) (any, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience(cs.URL))
+	if err != nil {
+		t.Fatalf("client assertion: %v", err)
+	}
+	if assertion.Header["kid"] != "worker-key" || claims.Issuer != "worker" || claims.Subject != "worker" || claims.ID == "" {
+		t.Errorf("client assertion header = %v, claims = %+v", assertion.Header, claims)
+	}
+}
@@ -0,0 +1,88 @@
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Credentials are the tokens a CLI keeps between runs. UserKey is set instead
+// of UserID when users are resolved by a UserIDResolver, e.g. a TypedClient.
+type Credentials struct {
+	UserID       uint      `json:"user_id"`
+	UserKey      string    `json:"user_key,omitempty"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// DefaultCredentialsPath returns <user config dir>/<app>/credentials.json.
+func DefaultCredentialsPath(app string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, app, "credentials.json"), nil
+}
+
+// SaveCredentials writes the credentials readable by the current user only.
+// The file is replaced atomically so a crash never leaves it half written.
+func SaveCredentials(path string, credentials *Credentials) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("error creating credentials directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("error writing credentials: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing credentials: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing credentials: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing credentials: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing credentials: %w", err)
+	}
+	return nil
+}
+
+// LoadCredentials reads credentials saved by SaveCredentials. Files that other
+// users can read are refused.
+func LoadCredentials(path string) (*Credentials, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("credentials file %s is accessible by other users (mode %v); run chmod 600", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials Credentials
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("error decoding credentials file: %w", err)
+	}
+	return &credentials, nil
+}
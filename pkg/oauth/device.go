@@ -0,0 +1,176 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+)
+
+const (
+	deviceCodeGrantType   = "urn:ietf:params:oauth:grant-type:device_code"
+	defaultPollInterval   = 5 * time.Second
+	slowDownIncrement     = 5 * time.Second
+	maxTransientBackoff   = 60 * time.Second
+	defaultDeviceLifetime = 10 * time.Minute
+)
+
+var (
+	ErrDeviceAccessDenied = errors.New("the user denied the device authorization request")
+	ErrDeviceCodeExpired  = errors.New("the device code expired before the user approved it")
+)
+
+// DeviceAuthorization is the device authorization response of RFC 8628
+// section 3.2. UserCode and VerificationURI are shown to the user.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
+// DeviceFlow runs the OAuth 2.0 Device Authorization Grant for machines
+// without a browser.
+type DeviceFlow struct {
+	client           *TokenClient
+	authorizationURL string
+	scopes           []string
+
+	// sleep waits between polls; tests replace it to skip the delays.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func NewDeviceFlow(cfg *config.Config) (*DeviceFlow, error) {
+	if cfg.DeviceAuthorizationURL == "" || cfg.TokenURL == "" || cfg.ClientID == "" {
+		return nil, errors.New("device_authorization_url, token_url and client_id are required for the device flow")
+	}
+
+	scopes := cfg.DeviceScopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid"}
+	}
+
+	return &DeviceFlow{
+		client:           NewTokenClient(cfg.TokenURL, cfg.ClientID, cfg.ClientSecret),
+		authorizationURL: cfg.DeviceAuthorizationURL,
+		scopes:           scopes,
+		sleep:            sleepContext,
+	}, nil
+}
+
+// SetHTTPClient replaces the client used to reach the provider.
+func (f *DeviceFlow) SetHTTPClient(httpClient *http.Client) {
+	f.client.HTTPClient = httpClient
+}
+
+// Start requests a device code and the user code to display.
+func (f *DeviceFlow) Start(ctx context.Context) (*DeviceAuthorization, error) {
+	resp, err := f.client.PostForm(ctx, f.authorizationURL, url.Values{
+		"scope": {strings.Join(f.scopes, " ")},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading device authorization response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ParseError(resp.StatusCode, body)
+	}
+
+	var authorization DeviceAuthorization
+	if err := json.Unmarshal(body, &authorization); err != nil {
+		return nil, fmt.Errorf("error decoding device authorization response: %w", err)
+	}
+	if authorization.DeviceCode == "" || authorization.UserCode == "" || authorization.VerificationURI == "" {
+		return nil, errors.New("device authorization response is missing required fields")
+	}
+
+	return &authorization, nil
+}
+
+// Poll waits for the user to approve the request and returns the issued
+// tokens. It honours the server's interval, adds five seconds on every
+// slow_down, and backs off exponentially on network errors.
+func (f *DeviceFlow) Poll(ctx context.Context, authorization *DeviceAuthorization) (*Token, error) {
+	interval := time.Duration(authorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	lifetime := time.Duration(authorization.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultDeviceLifetime
+	}
+	ctx, cancel := context.WithTimeout(ctx, lifetime)
+	defer cancel()
+
+	wait := interval
+	for {
+		if err := f.sleep(ctx, wait); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, ErrDeviceCodeExpired
+			}
+			return nil, err
+		}
+
+		token, err := f.client.Exchange(ctx, url.Values{
+			"grant_type":  {deviceCodeGrantType},
+			"device_code": {authorization.DeviceCode},
+		})
+		if err == nil {
+			return token, nil
+		}
+
+		var oauthErr *Error
+		if !errors.As(err, &oauthErr) {
+			if ctx.Err() != nil {
+				continue
+			}
+			wait *= 2
+			if wait > maxTransientBackoff {
+				wait = maxTransientBackoff
+			}
+			continue
+		}
+
+		switch oauthErr.Code {
+		case "authorization_pending":
+			wait = interval
+		case "slow_down":
+			interval += slowDownIncrement
+			wait = interval
+		case "access_denied":
+			return nil, ErrDeviceAccessDenied
+		case "expired_token":
+			return nil, ErrDeviceCodeExpired
+		default:
+			return nil, err
+		}
+	}
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
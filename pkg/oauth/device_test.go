@@ -0,0 +1,328 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+)
+
+// deviceServer serves the device authorization endpoint at /device and
+// answers token polls at /token with the scripted responses in order: an
+// OAuth error code, "drop" to close the connection, or "" to issue tokens.
+type deviceServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses []string
+	forms     []map[string]string
+}
+
+func newDeviceServer(t *testing.T, responses ...string) *deviceServer {
+	t.Helper()
+
+	ds := &deviceServer{responses: responses}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		ds.record(r)
+		json.NewEncoder(w).Encode(DeviceAuthorization{
+			DeviceCode:      "device-code",
+			UserCode:        "WDJB-MJHT",
+			VerificationURI: "https://sso.example.com/device",
+			ExpiresIn:       600,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		ds.record(r)
+		ds.mu.Lock()
+		response := "authorization_pending"
+		if len(ds.responses) > 0 {
+			response, ds.responses = ds.responses[0], ds.responses[1:]
+		}
+		ds.mu.Unlock()
+
+		switch response {
+		case "drop":
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case "":
+			json.NewEncoder(w).Encode(map[string]any{
+				"access_token":  "access",
+				"refresh_token": "refresh",
+				"id_token":      "id",
+				"expires_in":    3600,
+			})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": response})
+		}
+	})
+	ds.Server = httptest.NewServer(mux)
+	t.Cleanup(ds.Close)
+	return ds
+}
+
+func (ds *deviceServer) record(r *http.Request) {
+	r.ParseForm()
+	form := map[string]string{}
+	for key := range r.PostForm {
+		form[key] = r.PostForm.Get(key)
+	}
+	ds.mu.Lock()
+	ds.forms = append(ds.forms, form)
+	ds.mu.Unlock()
+}
+
+// newDeviceFlow returns a flow against ds whose waits are recorded instead of
+// slept.
+func newDeviceFlow(t *testing.T, ds *deviceServer) (*DeviceFlow, *[]time.Duration) {
+	t.Helper()
+
+	flow, err := NewDeviceFlow(&config.Config{
+		DeviceAuthorizationURL: ds.URL + "/device",
+		TokenURL:               ds.URL + "/token",
+		ClientID:               "cli",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var waits []time.Duration
+	flow.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	return flow, &waits
+}
+
+func TestNewDeviceFlowRequiresEndpoints(t *testing.T) {
+	if _, err := NewDeviceFlow(&config.Config{TokenURL: "https://sso/token", ClientID: "cli"}); err == nil {
+		t.Error("NewDeviceFlow() without device_authorization_url error = nil")
+	}
+}
+
+func TestDeviceFlowStart(t *testing.T) {
+	ds := newDeviceServer(t)
+	flow, _ := newDeviceFlow(t, ds)
+
+	authorization, err := flow.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization.DeviceCode != "device-code" || authorization.UserCode != "WDJB-MJHT" {
+		t.Errorf("Start() = %+v", authorization)
+	}
+
+	// Public clients identify themselves in the form; openid is the default scope.
+	if form := ds.forms[0]; form["client_id"] != "cli" || form["scope"] != "openid" {
+		t.Errorf("device authorization form = %v", form)
+	}
+}
+
+func TestDeviceFlowStartRejectsIncompleteResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"device_code":"device-code"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	flow, err := NewDeviceFlow(&config.Config{DeviceAuthorizationURL: server.URL, TokenURL: server.URL, ClientID: "cli"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := flow.Start(context.Background()); err == nil {
+		t.Error("Start() accepted a response without user_code and verification_uri")
+	}
+}
+
+func TestDeviceFlowPoll(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  int
+		responses []string
+		wantWaits []time.Duration
+		wantErr   error
+	}{
+		{
+			name:      "pending then approved",
+			interval:  2,
+			responses: []string{"authorization_pending", "authorization_pending", ""},
+			wantWaits: []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second},
+		},
+		{
+			name:      "default interval",
+			responses: []string{""},
+			wantWaits: []time.Duration{5 * time.Second},
+		},
+		{
+			name:      "slow_down adds five seconds for good",
+			interval:  1,
+			responses: []string{"slow_down", "authorization_pending", "slow_down", ""},
+			wantWaits: []time.Duration{1 * time.Second, 6 * time.Second, 6 * time.Second, 11 * time.Second},
+		},
+		{
+			name:      "network errors back off exponentially",
+			interval:  1,
+			responses: []string{"drop", "drop", "authorization_pending", ""},
+			wantWaits: []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 1 * time.Second},
+		},
+		{
+			name:      "backoff is capped",
+			interval:  40,
+			responses: []string{"drop", "drop", ""},
+			wantWaits: []time.Duration{40 * time.Second, 60 * time.Second, 60 * time.Second},
+		},
+		{
+			name:      "access denied",
+			interval:  1,
+			responses: []string{"authorization_pending", "access_denied"},
+			wantWaits: []time.Duration{time.Second, time.Second},
+			wantErr:   ErrDeviceAccessDenied,
+		},
+		{
+			name:      "expired token",
+			interval:  1,
+			responses: []string{"expired_token"},
+			wantWaits: []time.Duration{time.Second},
+			wantErr:   ErrDeviceCodeExpired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := newDeviceServer(t, tt.responses...)
+			flow, waits := newDeviceFlow(t, ds)
+
+			token, err := flow.Poll(context.Background(), &DeviceAuthorization{
+				DeviceCode: "device-code",
+				ExpiresIn:  600,
+				Interval:   tt.interval,
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Poll() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil || token.AccessToken != "access" || token.IDToken != "id" {
+				t.Fatalf("Poll() = %+v, %v", token, err)
+			}
+			if !reflect.DeepEqual(*waits, tt.wantWaits) {
+				t.Errorf("waits = %v, want %v", *waits, tt.wantWaits)
+			}
+
+			last := ds.forms[len(ds.forms)-1]
+			if last["grant_type"] != deviceCodeGrantType || last["device_code"] != "device-code" {
+				t.Errorf("token form = %v", last)
+			}
+		})
+	}
+}
+
+func TestDeviceFlowPollReturnsUnexpectedErrors(t *testing.T) {
+	ds := newDeviceServer(t, "invalid_client")
+	flow, _ := newDeviceFlow(t, ds)
+
+	_, err := flow.Poll(context.Background(), &DeviceAuthorization{DeviceCode: "device-code", Interval: 1})
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_client" {
+		t.Errorf("Poll() error = %v, want invalid_client", err)
+	}
+}
+
+func TestDeviceFlowPollStopsWhenCodeExpires(t *testing.T) {
+	ds := newDeviceServer(t)
+	flow, _ := newDeviceFlow(t, ds)
+	flow.sleep = sleepContext
+
+	start := time.Now()
+	_, err := flow.Poll(context.Background(), &DeviceAuthorization{DeviceCode: "device-code", ExpiresIn: 1, Interval: 5})
+	if !errors.Is(err, ErrDeviceCodeExpired) {
+		t.Errorf("Poll() error = %v, want ErrDeviceCodeExpired", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Poll() returned after %v, want about 1s", elapsed)
+	}
+}
+
+func TestDeviceFlowPollHonorsCancellation(t *testing.T) {
+	ds := newDeviceServer(t)
+	flow, _ := newDeviceFlow(t, ds)
+	flow.sleep = sleepContext
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := flow.Poll(ctx, &DeviceAuthorization{DeviceCode: "device-code", Interval: 5}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Poll() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestCredentialsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cli", "credentials.json")
+	saved := &Credentials{
+		UserID:       7,
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		IDToken:      "id",
+		Expiry:       time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := SaveCredentials(path, saved); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("credentials file mode = %v, want 0600", perm)
+	}
+	dir, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := dir.Mode().Perm(); perm != 0o700 {
+		t.Errorf("credentials directory mode = %v, want 0700", perm)
+	}
+
+	loaded, err := LoadCredentials(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, saved) {
+		t.Errorf("LoadCredentials() = %+v, want %+v", loaded, saved)
+	}
+
+	// Saving again replaces the file without leaving temporary files behind.
+	saved.AccessToken = "rotated"
+	if err := SaveCredentials(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("credentials directory has %d entries, want 1", len(entries))
+	}
+}
+
+func TestLoadCredentialsRefusesSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := SaveCredentials(path, &Credentials{AccessToken: "access"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadCredentials(path); err == nil {
+		t.Error("LoadCredentials() accepted a file other users can read")
+	}
+}
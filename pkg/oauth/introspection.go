@@ -0,0 +1,59 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Introspector calls a token introspection endpoint (RFC 7662).
+type Introspector struct {
+	client *TokenClient
+	url    string
+}
+
+func NewIntrospector(introspectionURL, clientID, clientSecret string) *Introspector {
+	return &Introspector{
+		client: NewTokenClient("", clientID, clientSecret),
+		url:    introspectionURL,
+	}
+}
+
+// SetHTTPClient replaces the client used to reach the introspection endpoint.
+func (i *Introspector) SetHTTPClient(httpClient *http.Client) {
+	i.client.HTTPClient = httpClient
+}
+
+// Introspect returns the endpoint's response members. An inactive token is
+// not an error: the result has "active" set to false. Errors mean the
+// endpoint could not give an answer.
+func (i *Introspector) Introspect(ctx context.Context, token string) (map[string]any, error) {
+	resp, err := i.client.PostForm(ctx, i.url, url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading introspection response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ParseError(resp.StatusCode, body)
+	}
+
+	var result map[string]any
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("error decoding introspection response: %w", err)
+	}
+	if _, ok := result["active"].(bool); !ok {
+		return nil, fmt.Errorf("introspection response has no active member")
+	}
+	return result, nil
+}
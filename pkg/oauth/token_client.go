@@ -0,0 +1,150 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Token is a token endpoint response.
+type Token struct {
+	AccessToken     string `json:"access_token"`
+	TokenType       string `json:"token_type"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	ExpiresIn       int64  `json:"expires_in,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+
+	// Expiry is computed from ExpiresIn when the response is received. It is
+	// zero when the server did not say how long the token lives.
+	Expiry time.Time `json:"-"`
+}
+
+// Error is an OAuth 2.0 error response (RFC 6749 section 5.2).
+type Error struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth error %s: %s", e.Code, e.Description)
+	}
+	return fmt.Sprintf("oauth error %s (status %d)", e.Code, e.StatusCode)
+}
+
+// TokenClient posts grants to a token endpoint, authenticating with
+// client_secret_basic when a secret is configured.
+type TokenClient struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	HTTPClient   *http.Client
+}
+
+func NewTokenClient(tokenURL, clientID, clientSecret string) *TokenClient {
+	return &TokenClient{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		HTTPClient:   &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// Refresh redeems a refresh token. The returned token carries a new refresh
+// token only if the server rotated it.
+func (c *TokenClient) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	return c.Exchange(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// Exchange posts an arbitrary grant to the token endpoint.
+func (c *TokenClient) Exchange(ctx context.Context, form url.Values) (*Token, error) {
+	resp, err := c.PostForm(ctx, c.TokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ParseError(resp.StatusCode, body)
+	}
+
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("error decoding token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+
+	return &token, nil
+}
+
+// PostForm sends an authenticated form POST to one of the provider's endpoints.
+func (c *TokenClient) PostForm(ctx context.Context, endpoint string, form url.Values) (*http.Response, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("endpoint URL is not configured")
+	}
+
+	if c.ClientSecret == "" && c.ClientID != "" {
+		form.Set("client_id", c.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s: %w", endpoint, err)
+	}
+	return resp, nil
+}
+
+// ParseError builds an *Error from a non-200 response body.
+func ParseError(statusCode int, body []byte) error {
+	oauthErr := &Error{StatusCode: statusCode}
+	if err := json.Unmarshal(body, oauthErr); err != nil || oauthErr.Code == "" {
+		oauthErr.Code = "server_error"
+	}
+	return oauthErr
+}
+
+// IsInvalidGrant reports whether err means the grant (e.g. a refresh token) is
+// no longer usable and retrying will not help.
+func IsInvalidGrant(err error) bool {
+	var oauthErr *Error
+	return errors.As(err, &oauthErr) && (oauthErr.Code == "invalid_grant" || oauthErr.Code == "unauthorized_client")
+}
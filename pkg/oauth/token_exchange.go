@@ -0,0 +1,132 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/singleflight"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	AccessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+
+	maxExchangeCacheEntries = 10000
+	exchangeExpiryLeeway    = 30 * time.Second
+)
+
+// TokenExchanger trades a subject's token for one scoped to a single
+// downstream audience (RFC 8693). Results are cached per subject token,
+// audience and scope set until shortly before they expire.
+type TokenExchanger struct {
+	client *TokenClient
+
+	mu    sync.Mutex
+	cache map[string]*Token
+	group singleflight.Group
+}
+
+func NewTokenExchanger(cfg *config.Config) *TokenExchanger {
+	return &TokenExchanger{
+		client: NewTokenClient(cfg.TokenURL, cfg.ClientID, cfg.ClientSecret),
+		cache:  make(map[string]*Token),
+	}
+}
+
+// Exchange returns a token for audience acting on behalf of subjectToken's
+// subject, limited to scopes.
+func (e *TokenExchanger) Exchange(ctx context.Context, subjectToken, audience string, scopes []string) (*Token, error) {
+	if subjectToken == "" {
+		return nil, errors.New("subject token is required for token exchange")
+	}
+
+	key := exchangeCacheKey(subjectToken, audience, scopes)
+	if token := e.cached(key); token != nil {
+		return token, nil
+	}
+
+	value, err := e.group.Do(key, func() (any, error) {
+		if token := e.cached(key); token != nil {
+			return token, nil
+		}
+
+		form := url.Values{
+			"grant_type":           {tokenExchangeGrantType},
+			"subject_token":        {subjectToken},
+			"subject_token_type":   {AccessTokenType},
+			"requested_token_type": {AccessTokenType},
+		}
+		if audience != "" {
+			form.Set("audience", audience)
+		}
+		if len(scopes) > 0 {
+			form.Set("scope", strings.Join(scopes, " "))
+		}
+
+		token, err := e.client.Exchange(ctx, form)
+		if err != nil {
+			return nil, err
+		}
+		e.store(key, token)
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Token), nil
+}
+
+func (e *TokenExchanger) cached(key string) *Token {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	token, ok := e.cache[key]
+	if !ok {
+		return nil
+	}
+	if time.Until(token.Expiry) < exchangeExpiryLeeway {
+		delete(e.cache, key)
+		return nil
+	}
+	return token
+}
+
+func (e *TokenExchanger) store(key string, token *Token) {
+	// Tokens without a lifetime cannot be safely reused.
+	if token.Expiry.IsZero() {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.cache) >= maxExchangeCacheEntries {
+		for k, cached := range e.cache {
+			if time.Until(cached.Expiry) < exchangeExpiryLeeway {
+				delete(e.cache, k)
+			}
+		}
+		if len(e.cache) >= maxExchangeCacheEntries {
+			e.cache = make(map[string]*Token)
+		}
+	}
+	e.cache[key] = token
+}
+
+// exchangeCacheKey hashes the subject token so raw tokens are not kept as map
+// keys, and sorts scopes so their order does not matter.
+func exchangeCacheKey(subjectToken, audience string, scopes []string) string {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+
+	sum := sha256.Sum256([]byte(subjectToken))
+	return hex.EncodeToString(sum[:]) + "|" + audience + "|" + strings.Join(sorted, " ")
+}
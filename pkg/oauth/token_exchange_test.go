@@ -0,0 +1,150 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+)
+
+// exchangeServer answers token exchanges with "exchanged-<n>" and records the
+// forms it received.
+type exchangeServer struct {
+	*httptest.Server
+	requests  atomic.Int32
+	expiresIn int
+
+	mu    sync.Mutex
+	forms []map[string]string
+}
+
+func newExchangeServer(t *testing.T) *exchangeServer {
+	t.Helper()
+
+	es := &exchangeServer{expiresIn: 3600}
+	es.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form := map[string]string{}
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		es.mu.Lock()
+		es.forms = append(es.forms, form)
+		es.mu.Unlock()
+
+		n := es.requests.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":      "exchanged-" + strconv.Itoa(int(n)),
+			"issued_token_type": AccessTokenType,
+			"token_type":        "Bearer",
+			"expires_in":        es.expiresIn,
+		})
+	}))
+	t.Cleanup(es.Close)
+	return es
+}
+
+func newTestExchanger(es *exchangeServer) *TokenExchanger {
+	return NewTokenExchanger(&config.Config{TokenURL: es.URL, ClientID: "service-a", ClientSecret: "secret"})
+}
+
+func TestTokenExchangeRequest(t *testing.T) {
+	es := newExchangeServer(t)
+	exchanger := newTestExchanger(es)
+
+	token, err := exchanger.Exchange(context.Background(), "user-token", "orders", []string{"orders:read", "orders:write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "exchanged-1" || token.IssuedTokenType != AccessTokenType {
+		t.Errorf("Exchange() = %+v", token)
+	}
+
+	want := map[string]string{
+		"grant_type":           tokenExchangeGrantType,
+		"subject_token":        "user-token",
+		"subject_token_type":   AccessTokenType,
+		"requested_token_type": AccessTokenType,
+		"audience":             "orders",
+		"scope":                "orders:read orders:write",
+	}
+	for key, value := range want {
+		if got := es.forms[0][key]; got != value {
+			t.Errorf("form %s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestTokenExchangeCache(t *testing.T) {
+	es := newExchangeServer(t)
+	exchanger := newTestExchanger(es)
+	ctx := context.Background()
+
+	exchange := func(subject, audience string, scopes ...string) string {
+		t.Helper()
+		token, err := exchanger.Exchange(ctx, subject, audience, scopes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.AccessToken
+	}
+
+	first := exchange("alice", "orders", "read", "write")
+	if got := exchange("alice", "orders", "write", "read"); got != first {
+		t.Errorf("same subject, audience and scopes in another order got %q, want cached %q", got, first)
+	}
+	if got := exchange("alice", "billing", "read", "write"); got == first {
+		t.Error("another audience reused the cached token")
+	}
+	if got := exchange("bob", "orders", "read", "write"); got == first {
+		t.Error("another subject reused the cached token")
+	}
+	if got := exchange("alice", "orders", "read"); got == first {
+		t.Error("other scopes reused the cached token")
+	}
+	if got := es.requests.Load(); got != 4 {
+		t.Errorf("exchanges = %d, want 4", got)
+	}
+}
+
+func TestTokenExchangeDoesNotCacheShortLivedTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+	}{
+		{"no lifetime", 0},
+		{"inside expiry leeway", 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es := newExchangeServer(t)
+			es.expiresIn = tt.expiresIn
+			exchanger := newTestExchanger(es)
+
+			for i := 0; i < 2; i++ {
+				if _, err := exchanger.Exchange(context.Background(), "alice", "orders", nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := es.requests.Load(); got != 2 {
+				t.Errorf("exchanges = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestTokenExchangeRequiresSubjectToken(t *testing.T) {
+	es := newExchangeServer(t)
+	if _, err := newTestExchanger(es).Exchange(context.Background(), "", "orders", nil); err == nil {
+		t.Error("Exchange() without a subject token error = nil")
+	}
+	if got := es.requests.Load(); got != 0 {
+		t.Errorf("exchanges = %d, want 0", got)
+	}
+}
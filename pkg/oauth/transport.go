@@ -0,0 +1,112 @@
+package oauth
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// TraceHeaders are copied from the incoming request onto outbound requests so
+// downstream calls join the caller's trace.
+var TraceHeaders = []string{
+	"Traceparent",
+	"Tracestate",
+	"Baggage",
+	"X-Request-Id",
+	"X-B3-Traceid",
+	"X-B3-Spanid",
+	"X-B3-Parentspanid",
+	"X-B3-Sampled",
+	"X-B3-Flags",
+	"B3",
+	"X-Cloud-Trace-Context",
+	"X-Amzn-Trace-Id",
+}
+
+// BearerTransport attaches an access token to every request. When the server
+// answers 401 it asks Refresh for a new token and retries the request once, if
+// the request body can be replayed.
+type BearerTransport struct {
+	Base http.RoundTripper
+
+	// Token returns the current access token.
+	Token func(ctx context.Context) (string, error)
+	// Refresh returns a token other than rejected. Optional; without it a 401
+	// is returned to the caller as is.
+	Refresh func(ctx context.Context, rejected string) (string, error)
+	// Propagate holds headers copied onto every outbound request, typically
+	// the trace headers of the request being served.
+	Propagate http.Header
+}
+
+func (t *BearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Token(req.Context())
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+
+	resp, err := t.base().RoundTrip(t.authorize(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || t.Refresh == nil {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	refreshed, err := t.Refresh(req.Context(), token)
+	if err != nil || refreshed == token {
+		return resp, nil
+	}
+
+	retry := t.authorize(req, refreshed)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+
+	// Only discard the first response once the retry is certain to be sent.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	return t.base().RoundTrip(retry)
+}
+
+// authorize clones the request, as a RoundTripper must not modify it.
+func (t *BearerTransport) authorize(req *http.Request, token string) *http.Request {
+	clone := req.Clone(req.Context())
+	for name, values := range t.Propagate {
+		if clone.Header.Get(name) == "" {
+			clone.Header[name] = values
+		}
+	}
+	clone.Header.Set("Authorization", "Bearer "+token)
+	return clone
+}
+
+func (t *BearerTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// TraceHeadersFrom collects the trace headers present on r.
+func TraceHeadersFrom(r *http.Request) http.Header {
+	headers := http.Header{}
+	for _, name := range TraceHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			headers[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return headers
+}
@@ -0,0 +1,168 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// apiServer accepts only the "fresh" token and records what it received.
+type apiServer struct {
+	*httptest.Server
+	authorizations []string
+	bodies         []string
+	traceparent    string
+}
+
+func newAPIServer(t *testing.T) *apiServer {
+	t.Helper()
+
+	api := &apiServer{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		api.authorizations = append(api.authorizations, r.Header.Get("Authorization"))
+		api.bodies = append(api.bodies, string(body))
+		api.traceparent = r.Header.Get("Traceparent")
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func staticToken(token string) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) { return token, nil }
+}
+
+func TestBearerTransportSendsToken(t *testing.T) {
+	api := newAPIServer(t)
+	client := &http.Client{Transport: &BearerTransport{Token: staticToken("fresh")}}
+
+	resp, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(api.authorizations) != 1 {
+		t.Errorf("status = %d after %d requests", resp.StatusCode, len(api.authorizations))
+	}
+}
+
+func TestBearerTransportRefreshesOnce(t *testing.T) {
+	api := newAPIServer(t)
+	var rejected []string
+	client := &http.Client{Transport: &BearerTransport{
+		Token: staticToken("stale"),
+		Refresh: func(_ context.Context, token string) (string, error) {
+			rejected = append(rejected, token)
+			return "fresh", nil
+		},
+	}}
+
+	resp, err := client.Post(api.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if len(rejected) != 1 || rejected[0] != "stale" {
+		t.Errorf("Refresh called with %v, want [stale]", rejected)
+	}
+	if len(api.bodies) != 2 || api.bodies[1] != "payload" {
+		t.Errorf("bodies = %q, want the payload replayed", api.bodies)
+	}
+}
+
+func TestBearerTransportReturns401(t *testing.T) {
+	tests := []struct {
+		name    string
+		refresh func(context.Context, string) (string, error)
+	}{
+		{"without Refresh", nil},
+		{"refresh fails", func(context.Context, string) (string, error) { return "", errors.New("refresh failed") }},
+		{"same token", func(_ context.Context, token string) (string, error) { return token, nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newAPIServer(t)
+			client := &http.Client{Transport: &BearerTransport{Token: staticToken("stale"), Refresh: tt.refresh}}
+
+			resp, err := client.Get(api.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized || len(api.authorizations) != 1 {
+				t.Errorf("status = %d after %d requests, want one 401", resp.StatusCode, len(api.authorizations))
+			}
+		})
+	}
+}
+
+func TestBearerTransportDoesNotReplayUnreadableBody(t *testing.T) {
+	api := newAPIServer(t)
+	refreshed := false
+	client := &http.Client{Transport: &BearerTransport{
+		Token: staticToken("stale"),
+		Refresh: func(context.Context, string) (string, error) {
+			refreshed = true
+			return "fresh", nil
+		},
+	}}
+
+	// A body without GetBody cannot be sent twice.
+	req, err := http.NewRequest(http.MethodPost, api.URL, io.NopCloser(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || refreshed {
+		t.Errorf("status = %d, refreshed = %v, want the 401 without a refresh", resp.StatusCode, refreshed)
+	}
+}
+
+func TestBearerTransportTokenError(t *testing.T) {
+	client := &http.Client{Transport: &BearerTransport{
+		Token: func(context.Context) (string, error) { return "", errors.New("no token") },
+	}}
+
+	if _, err := client.Get("http://127.0.0.1:1"); err == nil || !strings.Contains(err.Error(), "no token") {
+		t.Errorf("Get() error = %v, want the token error", err)
+	}
+}
+
+func TestBearerTransportPropagatesTraceHeaders(t *testing.T) {
+	api := newAPIServer(t)
+	incoming := httptest.NewRequest(http.MethodGet, "/", nil)
+	incoming.Header.Set("traceparent", "00-trace-span-01")
+	incoming.Header.Set("Cookie", "session=secret")
+
+	propagate := TraceHeadersFrom(incoming)
+	if propagate.Get("Cookie") != "" {
+		t.Errorf("TraceHeadersFrom() copied the cookie: %v", propagate)
+	}
+
+	client := &http.Client{Transport: &BearerTransport{Token: staticToken("fresh"), Propagate: propagate}}
+	resp, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if api.traceparent != "00-trace-span-01" {
+		t.Errorf("traceparent = %q, want it propagated", api.traceparent)
+	}
+}
@@ -0,0 +1,57 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// UserInfoClient reads the OIDC userinfo endpoint with a user's access token.
+// Only plain JSON responses are supported, not signed or encrypted ones.
+type UserInfoClient struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+func NewUserInfoClient(userInfoURL string) *UserInfoClient {
+	return &UserInfoClient{
+		URL:        userInfoURL,
+		HTTPClient: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// Fetch returns the claims the endpoint releases for the access token's user.
+func (c *UserInfoClient) Fetch(ctx context.Context, accessToken string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s: %w", c.URL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading userinfo response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ParseError(resp.StatusCode, body)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		return nil, fmt.Errorf("unsupported userinfo content type %q", contentType)
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, fmt.Errorf("error decoding userinfo response: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,57 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserInfoFetch(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantEmail   string
+		wantCode    string
+	}{
+		{"json", http.StatusOK, "application/json", `{"sub":"user","email":"user@example.com"}`, "user@example.com", ""},
+		{"json with charset", http.StatusOK, "application/json; charset=utf-8", `{"sub":"user","email":"user@example.com"}`, "user@example.com", ""},
+		{"signed response", http.StatusOK, "application/jwt", `eyJhbGciOiJSUzI1NiJ9.e30.sig`, "", ""},
+		{"malformed", http.StatusOK, "application/json", `{"sub":`, "", ""},
+		{"invalid token", http.StatusUnauthorized, "application/json", `{"error":"invalid_token"}`, "", "invalid_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var authorization string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			t.Cleanup(server.Close)
+
+			claims, err := NewUserInfoClient(server.URL).Fetch(context.Background(), "access")
+			if authorization != "Bearer access" {
+				t.Errorf("Authorization = %q, want Bearer access", authorization)
+			}
+
+			if tt.wantEmail != "" {
+				if err != nil || claims["email"] != tt.wantEmail {
+					t.Errorf("Fetch() = %v, %v, want email %s", claims, err, tt.wantEmail)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Fetch() = %v, want an error", claims)
+			}
+			var oauthErr *Error
+			if tt.wantCode != "" && (!errors.As(err, &oauthErr) || oauthErr.Code != tt.wantCode) {
+				t.Errorf("Fetch() error = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+}
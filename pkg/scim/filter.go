@@ -0,0 +1,386 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2).
+// Logical nodes use Left and Right ("not" only Left); comparisons use Attr and
+// Value.
+type Filter struct {
+	Op    string
+	Attr  string
+	Value any
+	Left  *Filter
+	Right *Filter
+}
+
+type columnType int
+
+const (
+	stringColumn columnType = iota
+	boolColumn
+	idColumn
+	timeColumn
+)
+
+type column struct {
+	name string
+	typ  columnType
+}
+
+// Attribute names are matched case-insensitively, as SCIM requires.
+var userColumns = map[string]column{
+	"id":                {"id", idColumn},
+	"externalid":        {"external_id", stringColumn},
+	"username":          {"email", stringColumn},
+	"displayname":       {"name", stringColumn},
+	"name.formatted":    {"name", stringColumn},
+	"emails":            {"email", stringColumn},
+	"emails.value":      {"email", stringColumn},
+	"active":            {"active", boolColumn},
+	"meta.created":      {"created_at", timeColumn},
+	"meta.lastmodified": {"updated_at", timeColumn},
+}
+
+var groupColumns = map[string]column{
+	"id":                {"id", idColumn},
+	"externalid":        {"external_id", stringColumn},
+	"displayname":       {"display_name", stringColumn},
+	"meta.created":      {"created_at", timeColumn},
+	"meta.lastmodified": {"updated_at", timeColumn},
+}
+
+var comparisonOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// ParseFilter parses a filter such as
+// `userName eq "bjensen" and (active eq true or emails co "@example.com")`.
+func ParseFilter(expression string) (*Filter, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.tokens[p.pos].text)
+	}
+	return filter, nil
+}
+
+// sql renders the filter as a WHERE clause over the given attribute columns.
+// String comparisons ignore case, like SCIM's caseExact=false attributes.
+func (f *Filter) sql(columns map[string]column) (string, []any, error) {
+	switch f.Op {
+	case "and", "or":
+		left, leftArgs, err := f.Left.sql(columns)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := f.Right.sql(columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(" + left + " " + strings.ToUpper(f.Op) + " " + right + ")", append(leftArgs, rightArgs...), nil
+	case "not":
+		inner, args, err := f.Left.sql(columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + inner, args, nil
+	}
+
+	col, ok := columns[strings.ToLower(f.Attr)]
+	if !ok {
+		return "", nil, fmt.Errorf("filtering on %q is not supported", f.Attr)
+	}
+
+	if f.Op == "pr" {
+		if col.typ == stringColumn {
+			return "(" + col.name + " IS NOT NULL AND " + col.name + " <> '')", nil, nil
+		}
+		return col.name + " IS NOT NULL", nil, nil
+	}
+
+	value, err := col.convert(f.Value)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid value for %s: %w", f.Attr, err)
+	}
+
+	name := col.name
+	if col.typ == stringColumn {
+		name = "LOWER(" + col.name + ")"
+		value = strings.ToLower(value.(string))
+	}
+
+	switch f.Op {
+	case "eq":
+		return name + " = ?", []any{value}, nil
+	case "ne":
+		return name + " <> ?", []any{value}, nil
+	case "gt":
+		return name + " > ?", []any{value}, nil
+	case "ge":
+		return name + " >= ?", []any{value}, nil
+	case "lt":
+		return name + " < ?", []any{value}, nil
+	case "le":
+		return name + " <= ?", []any{value}, nil
+	}
+
+	// co, sw and ew only apply to strings.
+	text, ok := value.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("operator %s needs a string attribute", f.Op)
+	}
+	text = escapeLike(text)
+	switch f.Op {
+	case "co":
+		text = "%" + text + "%"
+	case "sw":
+		text = text + "%"
+	case "ew":
+		text = "%" + text
+	}
+	return name + " LIKE ? ESCAPE '!'", []any{text}, nil
+}
+
+func (c column) convert(value any) (any, error) {
+	switch c.typ {
+	case idColumn:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string id")
+		}
+		id, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unknown id %q", text)
+		}
+		return uint(id), nil
+	case boolColumn:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected true or false")
+		}
+		return b, nil
+	case timeColumn:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a date-time string")
+		}
+		t, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string")
+	}
+	return text, nil
+}
+
+func escapeLike(text string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(text)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenOpen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenClose, ")"})
+			i++
+		case r == '"':
+			end := i + 1
+			for ; end < len(runes) && runes[end] != '"'; end++ {
+				if runes[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			var text string
+			if err := json.Unmarshal([]byte(string(runes[i:end+1])), &text); err != nil {
+				return nil, fmt.Errorf("invalid string in filter: %w", err)
+			}
+			tokens = append(tokens, token{tokenString, text})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && runes[end] != '(' && runes[end] != ')' && runes[end] != '"' {
+				end++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[i:end])})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) peekWord(word string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenWord && strings.EqualFold(p.tokens[p.pos].text, word)
+}
+
+func (p *filterParser) parseOr() (*Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Filter{Op: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (*Filter, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("and") {
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = &Filter{Op: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseFactor() (*Filter, error) {
+	if p.peekWord("not") {
+		p.pos++
+		inner, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &Filter{Op: "not", Left: inner}, nil
+	}
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+
+	if p.tokens[p.pos].kind == tokenOpen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenClose {
+			return nil, fmt.Errorf("missing ) in filter")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (*Filter, error) {
+	if p.pos+1 >= len(p.tokens) || p.tokens[p.pos].kind != tokenWord || p.tokens[p.pos+1].kind != tokenWord {
+		return nil, fmt.Errorf("expected an attribute and operator in filter")
+	}
+
+	attr := stripSchema(p.tokens[p.pos].text)
+	op := strings.ToLower(p.tokens[p.pos+1].text)
+	if !comparisonOps[op] {
+		return nil, fmt.Errorf("unknown filter operator %q", op)
+	}
+	p.pos += 2
+
+	if op == "pr" {
+		return &Filter{Op: op, Attr: attr}, nil
+	}
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("missing value for %s %s", attr, op)
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	var value any
+	switch {
+	case tok.kind == tokenString:
+		value = tok.text
+	case tok.kind == tokenWord && strings.EqualFold(tok.text, "true"):
+		value = true
+	case tok.kind == tokenWord && strings.EqualFold(tok.text, "false"):
+		value = false
+	case tok.kind == tokenWord && strings.EqualFold(tok.text, "null"):
+		value = nil
+	case tok.kind == tokenWord:
+		number, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q in filter", tok.text)
+		}
+		value = number
+	default:
+		return nil, fmt.Errorf("invalid value %q in filter", tok.text)
+	}
+
+	return &Filter{Op: op, Attr: attr, Value: value}, nil
+}
+
+// stripSchema turns a fully qualified attribute such as
+// "urn:ietf:params:scim:schemas:core:2.0:User:userName" into "userName".
+func stripSchema(attr string) string {
+	lower := strings.ToLower(attr)
+	for _, schema := range []string{UserSchema, GroupSchema} {
+		prefix := strings.ToLower(schema) + ":"
+		if strings.HasPrefix(lower, prefix) {
+			return attr[len(prefix):]
+		}
+	}
+	return attr
+}
@@ -0,0 +1,157 @@
+package scim
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilterSQL(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		filter   string
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			name:     "eq ignores case",
+			filter:   `userName eq "BJensen@Example.com"`,
+			wantSQL:  "LOWER(email) = ?",
+			wantArgs: []any{"bjensen@example.com"},
+		},
+		{
+			name:     "attribute names ignore case",
+			filter:   `USERNAME Eq "bjensen"`,
+			wantSQL:  "LOWER(email) = ?",
+			wantArgs: []any{"bjensen"},
+		},
+		{
+			name:     "schema-qualified attribute",
+			filter:   `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`,
+			wantSQL:  "LOWER(email) = ?",
+			wantArgs: []any{"bjensen"},
+		},
+		{
+			name:     "and binds tighter than or",
+			filter:   `userName eq "a" or userName eq "b" and active eq true`,
+			wantSQL:  "(LOWER(email) = ? OR (LOWER(email) = ? AND active = ?))",
+			wantArgs: []any{"a", "b", true},
+		},
+		{
+			name:     "and before or",
+			filter:   `userName eq "a" and active eq true or userName eq "b"`,
+			wantSQL:  "((LOWER(email) = ? AND active = ?) OR LOWER(email) = ?)",
+			wantArgs: []any{"a", true, "b"},
+		},
+		{
+			name:     "parentheses",
+			filter:   `(userName eq "a" or userName eq "b") and active eq true`,
+			wantSQL:  "((LOWER(email) = ? OR LOWER(email) = ?) AND active = ?)",
+			wantArgs: []any{"a", "b", true},
+		},
+		{
+			name:     "not",
+			filter:   `not (userName eq "a" or userName eq "b")`,
+			wantSQL:  "NOT (LOWER(email) = ? OR LOWER(email) = ?)",
+			wantArgs: []any{"a", "b"},
+		},
+		{
+			name:     "not binds to one factor",
+			filter:   `not active eq true and userName eq "a"`,
+			wantSQL:  "(NOT active = ? AND LOWER(email) = ?)",
+			wantArgs: []any{true, "a"},
+		},
+		{
+			name:     "co escapes wildcards",
+			filter:   `emails co "50%_off!"`,
+			wantSQL:  "LOWER(email) LIKE ? ESCAPE '!'",
+			wantArgs: []any{"%50!%!_off!!%"},
+		},
+		{
+			name:     "sw",
+			filter:   `userName sw "a_b"`,
+			wantSQL:  "LOWER(email) LIKE ? ESCAPE '!'",
+			wantArgs: []any{"a!_b%"},
+		},
+		{
+			name:     "ew",
+			filter:   `emails.value ew "@Example.com"`,
+			wantSQL:  "LOWER(email) LIKE ? ESCAPE '!'",
+			wantArgs: []any{"%@example.com"},
+		},
+		{
+			name:     "escaped quote in string",
+			filter:   `displayName eq "Barbara \"Babs\" Jensen"`,
+			wantSQL:  "LOWER(name) = ?",
+			wantArgs: []any{`barbara "babs" jensen`},
+		},
+		{
+			name:    "pr on a string",
+			filter:  `externalId pr`,
+			wantSQL: "(external_id IS NOT NULL AND external_id <> '')",
+		},
+		{
+			name:     "id",
+			filter:   `id eq "42"`,
+			wantSQL:  "id = ?",
+			wantArgs: []any{uint(42)},
+		},
+		{
+			name:     "date-time",
+			filter:   `meta.created gt "2024-01-02T03:04:05Z"`,
+			wantSQL:  "created_at > ?",
+			wantArgs: []any{created},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			sql, args, err := filter.sql(userColumns)
+			if err != nil {
+				t.Fatalf("sql() error = %v", err)
+			}
+			if sql != tt.wantSQL || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("sql() = %q %v, want %q %v", sql, args, tt.wantSQL, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+	}{
+		{"unknown attribute", `nickName eq "babs"`},
+		{"unknown nested attribute", `name.givenName eq "Barbara"`},
+		{"unknown operator", `userName like "a"`},
+		{"missing value", `userName eq`},
+		{"unterminated string", `userName eq "a`},
+		{"unbalanced parenthesis", `(userName eq "a"`},
+		{"trailing token", `userName eq "a" "b"`},
+		{"dangling and", `userName eq "a" and`},
+		{"bare value", `"a"`},
+		{"wrong type for bool", `active eq "yes"`},
+		{"wrong type for string", `userName eq true`},
+		{"co on bool", `active co true`},
+		{"invalid id", `id eq "abc"`},
+		{"invalid date", `meta.created gt "yesterday"`},
+		{"group attribute on users", `members eq "1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParseFilter(tt.filter)
+			if err == nil {
+				_, _, err = filter.sql(userColumns)
+			}
+			if err == nil {
+				t.Errorf("filter %q was accepted", tt.filter)
+			}
+		})
+	}
+}
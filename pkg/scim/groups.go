@@ -0,0 +1,216 @@
+package scim
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+func (s *Server) listGroups(c *gin.Context) {
+	where, args, err := s.where(c, groupColumns)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	startIndex, offset, limit := page(c)
+	groups, total, err := s.repo.ListGroupsContext(c.Request.Context(), where, args, offset, limit)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	baseURL := s.baseURL(c)
+	resources := make([]any, 0, len(groups))
+	for i := range groups {
+		resources = append(resources, groupResource(&groups[i], baseURL))
+	}
+	s.list(c, startIndex, total, resources)
+}
+
+func (s *Server) getGroup(c *gin.Context) {
+	group, err := s.findGroup(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+	s.respond(c, http.StatusOK, groupResource(group, s.baseURL(c)))
+}
+
+func (s *Server) createGroup(c *gin.Context) {
+	var resource Group
+	if err := s.decode(c, &resource); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	members, err := memberIDs(resource.Members)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	group := &models.Group{DisplayName: resource.DisplayName, ExternalID: resource.ExternalID}
+	if err := s.checkGroup(c.Request.Context(), group, members); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err := s.repo.CreateGroupContext(c.Request.Context(), group); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	log.Printf("SCIM created group %d", group.ID)
+	created := groupResource(group, s.baseURL(c))
+	c.Header("Location", created.Meta.Location)
+	s.respond(c, http.StatusCreated, created)
+}
+
+func (s *Server) replaceGroup(c *gin.Context) {
+	group, err := s.findGroup(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var resource Group
+	if err := s.decode(c, &resource); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	members, err := memberIDs(resource.Members)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	group.DisplayName = resource.DisplayName
+	group.ExternalID = resource.ExternalID
+	s.saveGroup(c, group, members)
+}
+
+func (s *Server) patchGroup(c *gin.Context) {
+	group, err := s.findGroup(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var request PatchRequest
+	if err := s.decode(c, &request); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	members := make(map[uint]bool, len(group.Members))
+	for _, user := range group.Members {
+		members[user.ID] = true
+	}
+	for _, op := range request.Operations {
+		if err := applyGroupPatch(group, members, op); err != nil {
+			s.fail(c, err)
+			return
+		}
+	}
+
+	s.saveGroup(c, group, members)
+}
+
+func (s *Server) deleteGroup(c *gin.Context) {
+	group, err := s.findGroup(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err := s.repo.DeleteGroupContext(c.Request.Context(), group.ID); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	log.Printf("SCIM deleted group %d", group.ID)
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) saveGroup(c *gin.Context, group *models.Group, members map[uint]bool) {
+	if err := s.checkGroup(c.Request.Context(), group, members); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err := s.repo.UpdateGroupContext(c.Request.Context(), group); err != nil {
+		s.fail(c, err)
+		return
+	}
+	s.respond(c, http.StatusOK, groupResource(group, s.baseURL(c)))
+}
+
+func (s *Server) findGroup(c *gin.Context) (*models.Group, error) {
+	id, ok := parseID(c.Param("id"))
+	if !ok {
+		return nil, newError(http.StatusNotFound, "", "resource not found")
+	}
+	return s.repo.FindGroupContext(c.Request.Context(), id)
+}
+
+// checkGroup validates the group and loads its members into group.Members.
+// Every member must be an existing user.
+func (s *Server) checkGroup(ctx context.Context, group *models.Group, members map[uint]bool) error {
+	if group.DisplayName == "" {
+		return newError(http.StatusBadRequest, "invalidValue", "displayName is required")
+	}
+
+	where, args := "LOWER(display_name) = ?", []any{strings.ToLower(group.DisplayName)}
+	if group.ID != 0 {
+		where, args = where+" AND id <> ?", append(args, group.ID)
+	}
+	_, total, err := s.repo.ListGroupsContext(ctx, where, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	if total > 0 {
+		return newError(http.StatusConflict, "uniqueness", "displayName %q is already taken", group.DisplayName)
+	}
+
+	group.Members = []models.User{}
+	if len(members) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	users, total, err := s.repo.ListUsersContext(ctx, "id IN ?", []any{ids}, 0, len(ids))
+	if err != nil {
+		return err
+	}
+	if total != int64(len(ids)) {
+		return newError(http.StatusBadRequest, "invalidValue", "members must be existing users")
+	}
+
+	group.Members = users
+	return nil
+}
+
+func memberIDs(members []Member) (map[uint]bool, error) {
+	ids := make(map[uint]bool, len(members))
+	for _, member := range members {
+		id, ok := parseID(member.Value)
+		if !ok {
+			return nil, newError(http.StatusBadRequest, "invalidValue", "unknown member %q", member.Value)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
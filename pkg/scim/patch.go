@@ -0,0 +1,301 @@
+package scim
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+// PatchOperation is one entry of a PatchOp request (RFC 7644 section 3.5.2).
+// Attributes this server does not store are accepted and ignored, since
+// identity providers routinely send more than the user model holds.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (op PatchOperation) kind() (string, error) {
+	kind := strings.ToLower(op.Op)
+	switch kind {
+	case "add", "replace", "remove":
+		return kind, nil
+	}
+	return "", newError(http.StatusBadRequest, "invalidSyntax", "unknown patch operation %q", op.Op)
+}
+
+// attributes splits a path-less add or replace into one value per attribute,
+// in a stable order.
+func (op PatchOperation) attributes() ([]string, map[string]json.RawMessage, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &values); err != nil {
+		return nil, nil, newError(http.StatusBadRequest, "invalidValue", "patch without a path needs an object value")
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, values, nil
+}
+
+func applyUserPatch(user *models.User, op PatchOperation) error {
+	kind, err := op.kind()
+	if err != nil {
+		return err
+	}
+
+	if op.Path != "" {
+		return setUserAttribute(user, op.Path, op.Value, kind == "remove")
+	}
+	if kind == "remove" {
+		return newError(http.StatusBadRequest, "noTarget", "remove needs a path")
+	}
+
+	names, values, err := op.attributes()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := setUserAttribute(user, name, values[name], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setUserAttribute(user *models.User, path string, value json.RawMessage, remove bool) error {
+	switch normalizePath(path) {
+	case "username":
+		if remove {
+			return newError(http.StatusBadRequest, "mutability", "userName is required")
+		}
+		text, err := stringValue(path, value)
+		if err != nil {
+			return err
+		}
+		user.Email = text
+	case "displayname", "name.formatted":
+		if remove {
+			user.Name = ""
+			return nil
+		}
+		text, err := stringValue(path, value)
+		if err != nil {
+			return err
+		}
+		user.Name = text
+	case "name":
+		if remove {
+			user.Name = ""
+			return nil
+		}
+		var name Name
+		if err := json.Unmarshal(value, &name); err != nil {
+			return newError(http.StatusBadRequest, "invalidValue", "name must be an object")
+		}
+		if display := name.display(); display != "" {
+			user.Name = display
+		}
+	case "externalid":
+		if remove {
+			user.ExternalID = ""
+			return nil
+		}
+		text, err := stringValue(path, value)
+		if err != nil {
+			return err
+		}
+		user.ExternalID = text
+	case "active":
+		if remove {
+			return newError(http.StatusBadRequest, "mutability", "active cannot be removed")
+		}
+		active, err := boolValue(path, value)
+		if err != nil {
+			return err
+		}
+		user.SetActive(active)
+	case "emails":
+		// The email doubles as userName, so it is never removed.
+		if remove {
+			return nil
+		}
+		var emails []Email
+		if err := json.Unmarshal(value, &emails); err != nil {
+			return newError(http.StatusBadRequest, "invalidValue", "emails must be a list")
+		}
+		if email := (&User{Emails: emails}).email(); email != "" {
+			user.Email = email
+		}
+	case "emails.value":
+		if remove {
+			return nil
+		}
+		text, err := stringValue(path, value)
+		if err != nil {
+			return err
+		}
+		user.Email = text
+	}
+	return nil
+}
+
+// applyGroupPatch updates group and the member IDs in members.
+func applyGroupPatch(group *models.Group, members map[uint]bool, op PatchOperation) error {
+	kind, err := op.kind()
+	if err != nil {
+		return err
+	}
+
+	path := stripSchema(op.Path)
+	if strings.HasPrefix(strings.ToLower(path), "members[") {
+		return removeFilteredMember(members, kind, path)
+	}
+	if path != "" {
+		return setGroupAttribute(group, members, kind, path, op.Value)
+	}
+	if kind == "remove" {
+		return newError(http.StatusBadRequest, "noTarget", "remove needs a path")
+	}
+
+	names, values, err := op.attributes()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := setGroupAttribute(group, members, kind, name, values[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFilteredMember handles `members[value eq "42"]`, the form identity
+// providers use to remove a single member.
+func removeFilteredMember(members map[uint]bool, kind, path string) error {
+	end := strings.LastIndex(path, "]")
+	if kind != "remove" || end < 0 || end != len(path)-1 {
+		return newError(http.StatusBadRequest, "invalidPath", "unsupported path %q", path)
+	}
+
+	filter, err := ParseFilter(path[len("members[") : len(path)-1])
+	if err != nil {
+		return newError(http.StatusBadRequest, "invalidFilter", "%v", err)
+	}
+	text, _ := filter.Value.(string)
+	if filter.Op != "eq" || !strings.EqualFold(filter.Attr, "value") || text == "" {
+		return newError(http.StatusBadRequest, "invalidFilter", "members can only be selected with value eq")
+	}
+
+	if id, ok := parseID(text); ok {
+		delete(members, id)
+	}
+	return nil
+}
+
+func setGroupAttribute(group *models.Group, members map[uint]bool, kind, path string, value json.RawMessage) error {
+	switch normalizePath(path) {
+	case "displayname":
+		if kind == "remove" {
+			return newError(http.StatusBadRequest, "mutability", "displayName is required")
+		}
+		text, err := stringValue(path, value)
+		if err != nil {
+			return err
+		}
+		group.DisplayName = text
+	case "externalid":
+		if kind == "remove" {
+			group.ExternalID = ""
+			return nil
+		}
+		text, err := stringValue(path, value)
+		if err != nil {
+			return err
+		}
+		group.ExternalID = text
+	case "members":
+		if kind == "remove" && isNull(value) {
+			for id := range members {
+				delete(members, id)
+			}
+			return nil
+		}
+
+		var list []Member
+		if err := json.Unmarshal(value, &list); err != nil {
+			return newError(http.StatusBadRequest, "invalidValue", "members must be a list")
+		}
+		if kind == "replace" {
+			for id := range members {
+				delete(members, id)
+			}
+		}
+		for _, member := range list {
+			id, ok := parseID(member.Value)
+			if !ok {
+				return newError(http.StatusBadRequest, "invalidValue", "unknown member %q", member.Value)
+			}
+			if kind == "remove" {
+				delete(members, id)
+			} else {
+				members[id] = true
+			}
+		}
+	}
+	return nil
+}
+
+// normalizePath lowercases an attribute path and drops its schema prefix and
+// any value filter, so `emails[type eq "work"].value` becomes "emails.value".
+func normalizePath(path string) string {
+	path = stripSchema(path)
+	for {
+		start := strings.Index(path, "[")
+		end := strings.Index(path, "]")
+		if start < 0 || end < start {
+			break
+		}
+		path = path[:start] + path[end+1:]
+	}
+	return strings.ToLower(path)
+}
+
+func stringValue(path string, value json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(value, &text); err != nil {
+		return "", newError(http.StatusBadRequest, "invalidValue", "%s must be a string", path)
+	}
+	return text, nil
+}
+
+// boolValue also accepts "True" and "False" strings, which some identity
+// providers send for active.
+func boolValue(path string, value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		switch strings.ToLower(text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, newError(http.StatusBadRequest, "invalidValue", "%s must be a boolean", path)
+}
+
+func isNull(value json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(value)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+}
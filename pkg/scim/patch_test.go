@@ -0,0 +1,244 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+func TestApplyUserPatch(t *testing.T) {
+	tests := []struct {
+		name string
+		op   PatchOperation
+		want models.User
+		// inactive reports that the patch should deactivate the user.
+		inactive bool
+		wantErr  string
+	}{
+		{
+			name:     "replace active",
+			op:       PatchOperation{Op: "replace", Path: "active", Value: json.RawMessage(`false`)},
+			want:     models.User{Email: "old@example.com", Name: "Old", ExternalID: "ext"},
+			inactive: true,
+		},
+		{
+			name:     "active as a string",
+			op:       PatchOperation{Op: "Replace", Path: "active", Value: json.RawMessage(`"False"`)},
+			want:     models.User{Email: "old@example.com", Name: "Old", ExternalID: "ext"},
+			inactive: true,
+		},
+		{
+			name: "path-less replace",
+			op: PatchOperation{Op: "replace", Value: json.RawMessage(
+				`{"userName":"new@example.com","displayName":"New","externalId":"ext-2","active":false,"nickName":"ignored"}`)},
+			want:     models.User{Email: "new@example.com", Name: "New", ExternalID: "ext-2"},
+			inactive: true,
+		},
+		{
+			name:    "path-less replace with a non-object",
+			op:      PatchOperation{Op: "replace", Value: json.RawMessage(`"new"`)},
+			wantErr: "invalidValue",
+		},
+		{
+			name: "schema-qualified path",
+			op:   PatchOperation{Op: "replace", Path: "urn:ietf:params:scim:schemas:core:2.0:User:displayName", Value: json.RawMessage(`"New"`)},
+			want: models.User{Email: "old@example.com", Name: "New", ExternalID: "ext"},
+		},
+		{
+			name: "name object",
+			op:   PatchOperation{Op: "add", Path: "name", Value: json.RawMessage(`{"givenName":"Barbara","familyName":"Jensen"}`)},
+			want: models.User{Email: "old@example.com", Name: "Barbara Jensen", ExternalID: "ext"},
+		},
+		{
+			name: "filtered email value",
+			op:   PatchOperation{Op: "replace", Path: `emails[type eq "work"].value`, Value: json.RawMessage(`"work@example.com"`)},
+			want: models.User{Email: "work@example.com", Name: "Old", ExternalID: "ext"},
+		},
+		{
+			name: "emails list uses the primary",
+			op:   PatchOperation{Op: "replace", Path: "emails", Value: json.RawMessage(`[{"value":"home@example.com"},{"value":"work@example.com","primary":true}]`)},
+			want: models.User{Email: "work@example.com", Name: "Old", ExternalID: "ext"},
+		},
+		{
+			name: "remove externalId",
+			op:   PatchOperation{Op: "remove", Path: "externalId"},
+			want: models.User{Email: "old@example.com", Name: "Old"},
+		},
+		{
+			name: "remove emails keeps userName",
+			op:   PatchOperation{Op: "remove", Path: "emails"},
+			want: models.User{Email: "old@example.com", Name: "Old", ExternalID: "ext"},
+		},
+		{
+			name: "unknown attribute is ignored",
+			op:   PatchOperation{Op: "replace", Path: "title", Value: json.RawMessage(`"Tour Guide"`)},
+			want: models.User{Email: "old@example.com", Name: "Old", ExternalID: "ext"},
+		},
+		{
+			name:    "remove userName",
+			op:      PatchOperation{Op: "remove", Path: "userName"},
+			wantErr: "mutability",
+		},
+		{
+			name:    "remove active",
+			op:      PatchOperation{Op: "remove", Path: "active"},
+			wantErr: "mutability",
+		},
+		{
+			name:    "remove without path",
+			op:      PatchOperation{Op: "remove"},
+			wantErr: "noTarget",
+		},
+		{
+			name:    "unknown operation",
+			op:      PatchOperation{Op: "move", Path: "displayName"},
+			wantErr: "invalidSyntax",
+		},
+		{
+			name:    "wrong value type",
+			op:      PatchOperation{Op: "replace", Path: "active", Value: json.RawMessage(`"maybe"`)},
+			wantErr: "invalidValue",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := models.User{Email: "old@example.com", Name: "Old", ExternalID: "ext"}
+			user.SetActive(true)
+			err := applyUserPatch(&user, tt.op)
+			if tt.wantErr != "" {
+				var scimErr *Error
+				if !errors.As(err, &scimErr) || scimErr.ScimType != tt.wantErr {
+					t.Fatalf("applyUserPatch() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyUserPatch() error = %v", err)
+			}
+			if user.IsActive() == tt.inactive {
+				t.Errorf("active = %v, want %v", user.IsActive(), !tt.inactive)
+			}
+			user.Active = nil
+			if user != tt.want {
+				t.Errorf("user = %+v, want %+v", user, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyGroupPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		op          PatchOperation
+		wantName    string
+		wantMembers []uint
+		wantErr     string
+	}{
+		{
+			name:        "add members",
+			op:          PatchOperation{Op: "add", Path: "members", Value: json.RawMessage(`[{"value":"3"},{"value":"4"}]`)},
+			wantMembers: []uint{1, 2, 3, 4},
+		},
+		{
+			name:        "remove listed members",
+			op:          PatchOperation{Op: "remove", Path: "members", Value: json.RawMessage(`[{"value":"1"}]`)},
+			wantMembers: []uint{2},
+		},
+		{
+			name:        "remove filtered member",
+			op:          PatchOperation{Op: "remove", Path: `members[value eq "2"]`},
+			wantMembers: []uint{1},
+		},
+		{
+			name:        "remove filtered member that is absent",
+			op:          PatchOperation{Op: "remove", Path: `members[value eq "9"]`},
+			wantMembers: []uint{1, 2},
+		},
+		{
+			name:        "remove all members",
+			op:          PatchOperation{Op: "remove", Path: "members"},
+			wantMembers: []uint{},
+		},
+		{
+			name:        "replace members",
+			op:          PatchOperation{Op: "replace", Path: "members", Value: json.RawMessage(`[{"value":"5"}]`)},
+			wantMembers: []uint{5},
+		},
+		{
+			name:        "path-less replace",
+			op:          PatchOperation{Op: "replace", Value: json.RawMessage(`{"displayName":"Admins","members":[{"value":"2"},{"value":"6"}]}`)},
+			wantName:    "Admins",
+			wantMembers: []uint{2, 6},
+		},
+		{
+			name:        "path-less add",
+			op:          PatchOperation{Op: "add", Value: json.RawMessage(`{"members":[{"value":"3"}]}`)},
+			wantMembers: []uint{1, 2, 3},
+		},
+		{
+			name:    "filtered member with another operator",
+			op:      PatchOperation{Op: "remove", Path: `members[value co "2"]`},
+			wantErr: "invalidFilter",
+		},
+		{
+			name:    "filtered member on another attribute",
+			op:      PatchOperation{Op: "remove", Path: `members[display eq "Babs"]`},
+			wantErr: "invalidFilter",
+		},
+		{
+			name:    "filtered member with replace",
+			op:      PatchOperation{Op: "replace", Path: `members[value eq "2"]`, Value: json.RawMessage(`[]`)},
+			wantErr: "invalidPath",
+		},
+		{
+			name:    "filtered member with a sub-attribute",
+			op:      PatchOperation{Op: "remove", Path: `members[value eq "2"].display`},
+			wantErr: "invalidPath",
+		},
+		{
+			name:    "unknown member",
+			op:      PatchOperation{Op: "add", Path: "members", Value: json.RawMessage(`[{"value":"abc"}]`)},
+			wantErr: "invalidValue",
+		},
+		{
+			name:    "remove displayName",
+			op:      PatchOperation{Op: "remove", Path: "displayName"},
+			wantErr: "mutability",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := models.Group{DisplayName: "Staff"}
+			members := map[uint]bool{1: true, 2: true}
+			err := applyGroupPatch(&group, members, tt.op)
+			if tt.wantErr != "" {
+				var scimErr *Error
+				if !errors.As(err, &scimErr) || scimErr.ScimType != tt.wantErr {
+					t.Fatalf("applyGroupPatch() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyGroupPatch() error = %v", err)
+			}
+
+			wantName := tt.wantName
+			if wantName == "" {
+				wantName = "Staff"
+			}
+			if group.DisplayName != wantName {
+				t.Errorf("displayName = %q, want %q", group.DisplayName, wantName)
+			}
+			want := map[uint]bool{}
+			for _, id := range tt.wantMembers {
+				want[id] = true
+			}
+			if !reflect.DeepEqual(members, want) {
+				t.Errorf("members = %v, want %v", members, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package scim
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+const (
+	UserSchema          = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema         = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema       = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema         = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema  = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// User is the SCIM representation of models.User. userName and the primary
+// email are both the user's Email; displayName and name.formatted are Name.
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Name        *Name    `json:"name,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created"`
+	LastModified string `json:"lastModified"`
+	Location     string `json:"location"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int64    `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+func userResource(user *models.User, baseURL string) *User {
+	active := user.IsActive()
+	location := baseURL + "/Users/" + formatID(user.ID)
+
+	return &User{
+		Schemas:     []string{UserSchema},
+		ID:          formatID(user.ID),
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		DisplayName: user.Name,
+		Name:        &Name{Formatted: user.Name},
+		Emails:      []Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta:        meta("User", user.CreatedAt, user.UpdatedAt, location),
+	}
+}
+
+// apply copies the resource's attributes onto user, as POST and PUT do.
+func (u *User) apply(user *models.User) {
+	user.Email = u.email()
+	user.Name = u.displayName()
+	user.ExternalID = u.ExternalID
+	user.SetActive(u.Active == nil || *u.Active)
+}
+
+func (u *User) email() string {
+	for _, email := range u.Emails {
+		if email.Primary && email.Value != "" {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 && u.Emails[0].Value != "" {
+		return u.Emails[0].Value
+	}
+	return u.UserName
+}
+
+func (u *User) displayName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name == nil {
+		return ""
+	}
+	return u.Name.display()
+}
+
+func (n *Name) display() string {
+	if n.Formatted != "" {
+		return n.Formatted
+	}
+	return strings.TrimSpace(n.GivenName + " " + n.FamilyName)
+}
+
+func groupResource(group *models.Group, baseURL string) *Group {
+	members := make([]Member, 0, len(group.Members))
+	for _, user := range group.Members {
+		members = append(members, Member{
+			Value:   formatID(user.ID),
+			Display: user.Name,
+			Ref:     baseURL + "/Users/" + formatID(user.ID),
+			Type:    "User",
+		})
+	}
+
+	return &Group{
+		Schemas:     []string{GroupSchema},
+		ID:          formatID(group.ID),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     members,
+		Meta:        meta("Group", group.CreatedAt, group.UpdatedAt, baseURL+"/Groups/"+formatID(group.ID)),
+	}
+}
+
+func meta(resourceType string, created, updated time.Time, location string) *Meta {
+	return &Meta{
+		ResourceType: resourceType,
+		Created:      created.UTC().Format(time.RFC3339),
+		LastModified: updated.UTC().Format(time.RFC3339),
+		Location:     location,
+	}
+}
+
+func formatID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func parseID(id string) (uint, bool) {
+	value, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || value == 0 {
+		return 0, false
+	}
+	return uint(value), true
+}
@@ -0,0 +1,319 @@
+package scim
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/middleware"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+const (
+	contentType     = "application/scim+json"
+	defaultPageSize = 100
+	maxPageSize     = 200
+	defaultScope    = "scim"
+)
+
+// Repository is the storage the SCIM server provisions into. Reads and writes
+// follow the repository's own primary/secondary policy, and are bound to the
+// SCIM request's context. Users are read past any user cache, since they are
+// usually about to be modified.
+type Repository interface {
+	ListUsersContext(ctx context.Context, where string, args []any, offset, limit int) ([]models.User, int64, error)
+	FindByIDUncachedContext(ctx context.Context, id uint) (*models.User, error)
+	CreateContext(ctx context.Context, user *models.User) error
+	UpdateContext(ctx context.Context, user *models.User) error
+	DeleteContext(ctx context.Context, id uint) error
+
+	ListGroupsContext(ctx context.Context, where string, args []any, offset, limit int) ([]models.Group, int64, error)
+	FindGroupContext(ctx context.Context, id uint) (*models.Group, error)
+	CreateGroupContext(ctx context.Context, group *models.Group) error
+	UpdateGroupContext(ctx context.Context, group *models.Group) error
+	DeleteGroupContext(ctx context.Context, id uint) error
+}
+
+// SessionRevoker signs a user out everywhere. It is called when a user is
+// deactivated or deleted.
+type SessionRevoker interface {
+	RevokeUserSessionsContext(ctx context.Context, userID uint) error
+}
+
+type Config struct {
+	// BaseURL is the absolute URL the server is mounted at, used in meta.location.
+	// It is derived from each request when empty.
+	BaseURL string
+
+	// The identity provider authenticates with BearerToken, a shared secret, or
+	// when that is empty with an access token accepted by Verifier that carries
+	// Scope.
+	BearerToken string
+	Verifier    middleware.TokenVerifier
+	Scope       string
+}
+
+// Server implements the SCIM 2.0 Users and Groups endpoints (RFC 7644).
+type Server struct {
+	repo     Repository
+	sessions SessionRevoker
+	config   *Config
+}
+
+func NewServer(repo Repository, sessions SessionRevoker, config *Config) *Server {
+	return &Server{
+		repo:     repo,
+		sessions: sessions,
+		config:   config,
+	}
+}
+
+// Register mounts the endpoints on router, typically router.Group("/scim/v2").
+func (s *Server) Register(router *gin.RouterGroup) {
+	group := router.Group("", s.authenticate)
+
+	group.GET("/ServiceProviderConfig", s.serviceProviderConfig)
+	group.GET("/ResourceTypes", s.resourceTypes)
+
+	group.GET("/Users", s.listUsers)
+	group.POST("/Users", s.createUser)
+	group.GET("/Users/:id", s.getUser)
+	group.PUT("/Users/:id", s.replaceUser)
+	group.PATCH("/Users/:id", s.patchUser)
+	group.DELETE("/Users/:id", s.deleteUser)
+
+	group.GET("/Groups", s.listGroups)
+	group.POST("/Groups", s.createGroup)
+	group.GET("/Groups/:id", s.getGroup)
+	group.PUT("/Groups/:id", s.replaceGroup)
+	group.PATCH("/Groups/:id", s.patchGroup)
+	group.DELETE("/Groups/:id", s.deleteGroup)
+}
+
+func (s *Server) authenticate(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		c.Header("WWW-Authenticate", `Bearer`)
+		s.fail(c, newError(http.StatusUnauthorized, "", "authorization required"))
+		return
+	}
+	token := strings.TrimSpace(header[7:])
+
+	switch {
+	case s.config.BearerToken != "":
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.BearerToken)) != 1 {
+			s.fail(c, newError(http.StatusUnauthorized, "", "invalid token"))
+			return
+		}
+	case s.config.Verifier != nil:
+		claims, err := s.config.Verifier.VerifyAccessTokenContext(c.Request.Context(), token)
+		if err != nil {
+			s.fail(c, newError(http.StatusUnauthorized, "", "invalid token"))
+			return
+		}
+		if !hasScope(claims, s.scope()) {
+			s.fail(c, newError(http.StatusForbidden, "", "token lacks the %s scope", s.scope()))
+			return
+		}
+	default:
+		log.Printf("SCIM request rejected: no bearer token or verifier configured")
+		s.fail(c, newError(http.StatusUnauthorized, "", "invalid token"))
+		return
+	}
+
+	c.Next()
+}
+
+func (s *Server) scope() string {
+	if s.config.Scope != "" {
+		return s.config.Scope
+	}
+	return defaultScope
+}
+
+// hasScope reads the space-separated scope claim, or scp as a list or string.
+func hasScope(claims map[string]any, scope string) bool {
+	var scopes []string
+	switch value := claims["scope"].(type) {
+	case string:
+		scopes = strings.Fields(value)
+	}
+	switch value := claims["scp"].(type) {
+	case string:
+		scopes = append(scopes, strings.Fields(value)...)
+	case []any:
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				scopes = append(scopes, text)
+			}
+		}
+	}
+
+	for _, candidate := range scopes {
+		if candidate == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) baseURL(c *gin.Context) string {
+	if s.config.BaseURL != "" {
+		return strings.TrimSuffix(s.config.BaseURL, "/")
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+
+	path := c.FullPath()
+	for _, endpoint := range []string{"/Users", "/Groups", "/ServiceProviderConfig", "/ResourceTypes"} {
+		if i := strings.LastIndex(path, endpoint); i >= 0 {
+			path = path[:i]
+			break
+		}
+	}
+	return scheme + "://" + c.Request.Host + path
+}
+
+// page reads the 1-based startIndex and count query parameters.
+func page(c *gin.Context) (startIndex, offset, limit int) {
+	startIndex, err := strconv.Atoi(c.Query("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+
+	limit, err = strconv.Atoi(c.Query("count"))
+	if err != nil {
+		limit = defaultPageSize
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	return startIndex, startIndex - 1, limit
+}
+
+func (s *Server) where(c *gin.Context, columns map[string]column) (string, []any, error) {
+	expression := c.Query("filter")
+	if expression == "" {
+		return "", nil, nil
+	}
+
+	filter, err := ParseFilter(expression)
+	if err != nil {
+		return "", nil, newError(http.StatusBadRequest, "invalidFilter", "%v", err)
+	}
+	where, args, err := filter.sql(columns)
+	if err != nil {
+		return "", nil, newError(http.StatusBadRequest, "invalidFilter", "%v", err)
+	}
+	return where, args, nil
+}
+
+func (s *Server) list(c *gin.Context, startIndex int, total int64, resources []any) {
+	s.respond(c, http.StatusOK, &ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (s *Server) decode(c *gin.Context, v any) error {
+	if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, 1<<20)).Decode(v); err != nil {
+		return newError(http.StatusBadRequest, "invalidSyntax", "invalid request body: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) respond(c *gin.Context, status int, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+	c.Data(status, contentType, data)
+}
+
+// Error is a SCIM error response (RFC 7644 section 3.12).
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func newError(status int, scimType, format string, args ...any) *Error {
+	return &Error{Status: status, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("scim error %d %s: %s", e.Status, e.ScimType, e.Detail)
+}
+
+func (s *Server) fail(c *gin.Context, err error) {
+	var scimErr *Error
+	switch {
+	case errors.As(err, &scimErr):
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		scimErr = newError(http.StatusNotFound, "", "resource not found")
+	default:
+		log.Printf("SCIM request failed: %v", err)
+		scimErr = newError(http.StatusInternalServerError, "", "internal error")
+	}
+
+	body := gin.H{
+		"schemas": []string{ErrorSchema},
+		"status":  strconv.Itoa(scimErr.Status),
+		"detail":  scimErr.Detail,
+	}
+	if scimErr.ScimType != "" {
+		body["scimType"] = scimErr.ScimType
+	}
+
+	data, _ := json.Marshal(body)
+	c.Abort()
+	c.Data(scimErr.Status, contentType, data)
+}
+
+func (s *Server) serviceProviderConfig(c *gin.Context) {
+	s.respond(c, http.StatusOK, gin.H{
+		"schemas":        []string{ServiceConfigSchema},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": maxPageSize},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with a bearer token",
+		}},
+	})
+}
+
+func (s *Server) resourceTypes(c *gin.Context) {
+	baseURL := s.baseURL(c)
+	resources := []any{
+		gin.H{"schemas": []string{ResourceTypeSchema}, "id": "User", "name": "User", "endpoint": "/Users", "schema": UserSchema,
+			"meta": gin.H{"resourceType": "ResourceType", "location": baseURL + "/ResourceTypes/User"}},
+		gin.H{"schemas": []string{ResourceTypeSchema}, "id": "Group", "name": "Group", "endpoint": "/Groups", "schema": GroupSchema,
+			"meta": gin.H{"resourceType": "ResourceType", "location": baseURL + "/ResourceTypes/Group"}},
+	}
+	s.list(c, 1, int64(len(resources)), resources)
+}
@@ -0,0 +1,183 @@
+package scim
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+func (s *Server) listUsers(c *gin.Context) {
+	where, args, err := s.where(c, userColumns)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	startIndex, offset, limit := page(c)
+	users, total, err := s.repo.ListUsersContext(c.Request.Context(), where, args, offset, limit)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	baseURL := s.baseURL(c)
+	resources := make([]any, 0, len(users))
+	for i := range users {
+		resources = append(resources, userResource(&users[i], baseURL))
+	}
+	s.list(c, startIndex, total, resources)
+}
+
+func (s *Server) getUser(c *gin.Context) {
+	user, err := s.findUser(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+	s.respond(c, http.StatusOK, userResource(user, s.baseURL(c)))
+}
+
+func (s *Server) createUser(c *gin.Context) {
+	var resource User
+	if err := s.decode(c, &resource); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	user := &models.User{}
+	resource.apply(user)
+	if err := s.checkUser(c.Request.Context(), user); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err := s.repo.CreateContext(c.Request.Context(), user); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	log.Printf("SCIM provisioned user %d", user.ID)
+	created := userResource(user, s.baseURL(c))
+	c.Header("Location", created.Meta.Location)
+	s.respond(c, http.StatusCreated, created)
+}
+
+func (s *Server) replaceUser(c *gin.Context) {
+	user, err := s.findUser(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var resource User
+	if err := s.decode(c, &resource); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	wasActive := user.IsActive()
+	resource.apply(user)
+	s.saveUser(c, user, wasActive)
+}
+
+func (s *Server) patchUser(c *gin.Context) {
+	user, err := s.findUser(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	var request PatchRequest
+	if err := s.decode(c, &request); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	wasActive := user.IsActive()
+	for _, op := range request.Operations {
+		if err := applyUserPatch(user, op); err != nil {
+			s.fail(c, err)
+			return
+		}
+	}
+	s.saveUser(c, user, wasActive)
+}
+
+func (s *Server) deleteUser(c *gin.Context) {
+	user, err := s.findUser(c)
+	if err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err := s.repo.DeleteContext(c.Request.Context(), user.ID); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	log.Printf("SCIM deleted user %d", user.ID)
+	s.revokeSessions(c.Request.Context(), user.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// saveUser stores an updated user and signs them out everywhere if the update
+// deactivated them.
+func (s *Server) saveUser(c *gin.Context, user *models.User, wasActive bool) {
+	if err := s.checkUser(c.Request.Context(), user); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if err := s.repo.UpdateContext(c.Request.Context(), user); err != nil {
+		s.fail(c, err)
+		return
+	}
+
+	if wasActive && !user.IsActive() {
+		log.Printf("SCIM deactivated user %d", user.ID)
+		s.revokeSessions(c.Request.Context(), user.ID)
+	}
+	s.respond(c, http.StatusOK, userResource(user, s.baseURL(c)))
+}
+
+func (s *Server) revokeSessions(ctx context.Context, userID uint) {
+	if s.sessions == nil {
+		return
+	}
+	if err := s.sessions.RevokeUserSessionsContext(ctx, userID); err != nil {
+		log.Printf("Failed to revoke sessions of user %d: %v", userID, err)
+	}
+}
+
+func (s *Server) findUser(c *gin.Context) (*models.User, error) {
+	id, ok := parseID(c.Param("id"))
+	if !ok {
+		return nil, newError(http.StatusNotFound, "", "resource not found")
+	}
+	return s.repo.FindByIDUncachedContext(c.Request.Context(), id)
+}
+
+// checkUser requires a userName and rejects one that another user already has.
+func (s *Server) checkUser(ctx context.Context, user *models.User) error {
+	if user.Email == "" {
+		return newError(http.StatusBadRequest, "invalidValue", "userName is required")
+	}
+
+	where, args := "LOWER(email) = ?", []any{strings.ToLower(user.Email)}
+	if user.ID != 0 {
+		where, args = where+" AND id <> ?", append(args, user.ID)
+	}
+	_, total, err := s.repo.ListUsersContext(ctx, where, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	if total > 0 {
+		return newError(http.StatusConflict, "uniqueness", "userName %q is already taken", user.Email)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package singleflight
+
+import (
+	"context"
+	"sync"
+)
+
+type call struct {
+	wg  sync.WaitGroup
+	val any
+	err error
+}
+
+// Group collapses concurrent calls with the same key into one execution whose
+// result is shared by every caller.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+func (g *Group) Do(key string, fn func() (any, error)) (any, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	c.val, c.err = fn()
+	return c.val, c.err
+}
+
+// DoContext is Do, but the caller stops waiting once ctx is done. The shared
+// call keeps running for the other callers.
+func (g *Group) DoContext(ctx context.Context, key string, fn func() (any, error)) (any, error) {
+	type result struct {
+		val any
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		val, err := g.Do(key, fn)
+		results <- result{val, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.val, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,87 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoSharesOneCall(t *testing.T) {
+	var g Group
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([]any, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.Do("key", func() (any, error) {
+				calls.Add(1)
+				<-release
+				return "value", nil
+			})
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("calls = %d, want 1", got)
+	}
+	for _, result := range results {
+		if result != "value" {
+			t.Errorf("Do() = %v, want value", result)
+		}
+	}
+
+	// A finished call is not cached.
+	g.Do("key", func() (any, error) {
+		calls.Add(1)
+		return nil, nil
+	})
+	if calls.Load() != 2 {
+		t.Errorf("calls after the shared call finished = %d, want 2", calls.Load())
+	}
+}
+
+func TestDoContextStopsWaitingButKeepsCall(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err := g.DoContext(ctx, "key", func() (any, error) {
+		defer close(done)
+		<-release
+		return "value", nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DoContext() error = %v, want context.Canceled", err)
+	}
+
+	// A later caller joins the call that is still running.
+	waiter := make(chan any, 1)
+	go func() {
+		value, _ := g.DoContext(context.Background(), "key", func() (any, error) {
+			return "second call", nil
+		})
+		waiter <- value
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if value := <-waiter; value != "value" {
+		t.Errorf("DoContext() = %v, want the shared value", value)
+	}
+	<-done
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Conn gets a connection from pool whose commands fail once ctx is done and
+// wait no longer than ctx's deadline. Without a deadline the pool's own read
+// and write timeouts apply. A nil pool, from a session store without one,
+// gives ErrNoPool.
+func Conn(ctx context.Context, pool *redis.Pool) (redis.Conn, error) {
+	if pool == nil {
+		return nil, ErrNoPool
+	}
+	conn, err := pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return contextConn{Conn: conn, ctx: ctx}, nil
+}
+
+type contextConn struct {
+	redis.Conn
+	ctx context.Context
+}
+
+func (c contextConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	deadline, ok := c.ctx.Deadline()
+	if !ok {
+		return c.Conn.Do(commandName, args...)
+	}
+	// A zero timeout would mean no timeout at all.
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	return redis.DoWithTimeout(c.Conn, timeout, commandName, args...)
+}
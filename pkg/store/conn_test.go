@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+func newTestPool(t *testing.T) *redis.Pool {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", server.Addr()) }}
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func TestConn(t *testing.T) {
+	pool := newTestPool(t)
+	conn, err := Conn(context.Background(), pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("SET", "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := redis.String(conn.Do("GET", "key")); err != nil || value != "value" {
+		t.Errorf("GET = %q, %v, want value", value, err)
+	}
+}
+
+func TestConnStopsWithContext(t *testing.T) {
+	pool := newTestPool(t)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if conn, err := Conn(canceled, pool); err == nil {
+		_, err = conn.Do("PING")
+		conn.Close()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Do() with a canceled context error = %v, want context.Canceled", err)
+		}
+	}
+
+	// BLPOP on an empty list blocks until the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if _, err := conn.Do("BLPOP", "empty", 0); err == nil {
+		t.Error("BLPOP past the deadline succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("BLPOP returned after %v, want the deadline to stop it", elapsed)
+	}
+	if _, err := conn.Do("PING"); err == nil {
+		t.Error("Do() after the deadline succeeded")
+	}
+}
+
+func TestSessionStoreDialOptions(t *testing.T) {
+	sessionStore, err := NewRedisSessionStore("redis://"+miniredis.RunT(t).Addr(), "secret", false, 3600,
+		redis.DialReadTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sessionStore.Close()
+
+	conn := PoolOf(sessionStore).Get()
+	defer conn.Close()
+
+	start := time.Now()
+	if _, err := conn.Do("BLPOP", "empty", 0); err == nil {
+		t.Error("BLPOP past the read timeout succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("BLPOP returned after %v, want the read timeout to stop it", elapsed)
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Deletes the lock only if it still holds our token, so a lock that expired
+// and was taken by another replica is never released by mistake.
+var releaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Lock is a lease on a Redis key shared by every replica.
+type Lock struct {
+	pool  *redis.Pool
+	key   string
+	token string
+}
+
+// TryLock takes the lock at key for ttl. It returns nil without an error if
+// another holder has it.
+func TryLock(ctx context.Context, pool *redis.Pool, key string, ttl time.Duration) (*Lock, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = redis.String(conn.Do("SET", key, token, "NX", "PX", ttl.Milliseconds()))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lock{pool: pool, key: key, token: token}, nil
+}
+
+// WaitLock retries TryLock until it succeeds, wait has elapsed or ctx is done.
+func WaitLock(ctx context.Context, pool *redis.Pool, key string, ttl, wait time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		lock, err := TryLock(ctx, pool, key, ttl)
+		if err != nil || lock != nil || time.Now().After(deadline) {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func (l *Lock) Release() error {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	_, err := releaseScript.Do(conn, l.key, l.token)
+	return err
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// The counters never expire: if one reset to zero, sessions stamped before a
+// bump would become valid again.
+const (
+	globalSessionEpochKey = "sso:session_epoch"
+	userSessionEpochKey   = "sso:session_epoch:"
+)
+
+// SessionEpochs returns the user's session epoch and the global one. Counters
+// that were never bumped are zero.
+func SessionEpochs(ctx context.Context, pool *redis.Pool, userKey string) (user, global int64, err error) {
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	epochs, err := redis.Int64s(conn.Do("MGET", userSessionEpochKey+userKey, globalSessionEpochKey))
+	if err != nil {
+		return 0, 0, err
+	}
+	return epochs[0], epochs[1], nil
+}
+
+// BumpSessionEpoch increments the user's session epoch and returns it.
+func BumpSessionEpoch(ctx context.Context, pool *redis.Pool, userKey string) (int64, error) {
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return redis.Int64(conn.Do("INCR", userSessionEpochKey+userKey))
+}
+
+// BumpGlobalSessionEpoch increments the epoch shared by every user and
+// returns it.
+func BumpGlobalSessionEpoch(ctx context.Context, pool *redis.Pool) (int64, error) {
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return redis.Int64(conn.Do("INCR", globalSessionEpochKey))
+}
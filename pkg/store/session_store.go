@@ -1,6 +1,9 @@
 package store
 
 import (
+	"errors"
+	"net/http"
+
 	redistore "github.com/boj/redistore"
 	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/sessions"
@@ -12,16 +15,36 @@ type SessionStore interface {
 	Close() error
 }
 
+// PoolProvider is implemented by session stores that keep their sessions in
+// Redis. Session revocation, session epochs, the cached user status and the
+// token refresh lock keep their state in the same pool.
+type PoolProvider interface {
+	Pool() *redis.Pool
+}
+
+var ErrNoPool = errors.New("session store does not provide a Redis pool")
+
+// PoolOf returns the Redis pool behind sessionStore, or nil if it does not
+// implement PoolProvider.
+func PoolOf(sessionStore SessionStore) *redis.Pool {
+	if provider, ok := sessionStore.(PoolProvider); ok {
+		return provider.Pool()
+	}
+	return nil
+}
+
 type RedisSessionStore struct {
 	store  *redistore.RediStore
 	config *config.Config
 }
 
-func NewRedisSessionStore(redisURI, sessionKey string, isRedisSecure bool, sessionMaxAge int) (SessionStore, error) {
+// NewRedisSessionStore connects to redisURI with options, e.g. the dial,
+// read and write timeouts.
+func NewRedisSessionStore(redisURI, sessionKey string, isRedisSecure bool, sessionMaxAge int, options ...redis.DialOption) (SessionStore, error) {
 	pool := &redis.Pool{
 		MaxIdle: 10,
 		Dial: func() (redis.Conn, error) {
-			return redis.DialURL(redisURI)
+			return redis.DialURL(redisURI, options...)
 		},
 	}
 
@@ -42,10 +65,25 @@ func NewRedisSessionStore(redisURI, sessionKey string, isRedisSecure bool, sessi
 	}, nil
 }
 
+// SetSameSite sets the SameSite attribute of the session cookie. Browsers only
+// accept SameSite=None on secure cookies, so it also turns Secure on.
+func (s *RedisSessionStore) SetSameSite(mode http.SameSite) {
+	s.store.Options.SameSite = mode
+	if mode == http.SameSiteNoneMode {
+		s.store.Options.Secure = true
+	}
+}
+
 func (s *RedisSessionStore) GetStore() sessions.Store {
 	return s.store
 }
 
+// Pool returns the Redis pool the sessions live in, for features that keep
+// their own state next to them.
+func (s *RedisSessionStore) Pool() *redis.Pool {
+	return s.store.Pool
+}
+
 func (s *RedisSessionStore) Close() error {
 	return s.store.Close()
 }
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestStore(t *testing.T) *RedisSessionStore {
+	t.Helper()
+
+	sessionStore, err := NewRedisSessionStore("redis://"+miniredis.RunT(t).Addr(), "secret", false, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessionStore.Close() })
+	return sessionStore.(*RedisSessionStore)
+}
+
+func sessionCookie(t *testing.T, sessionStore SessionStore) *http.Cookie {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	recorder := httptest.NewRecorder()
+	session, err := sessionStore.GetStore().New(r, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values["user_id"] = uint(1)
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	return cookies[0]
+}
+
+func TestSessionCookieDefaults(t *testing.T) {
+	cookie := sessionCookie(t, newTestStore(t))
+
+	if !cookie.HttpOnly || cookie.Secure || cookie.SameSite == http.SameSiteNoneMode {
+		t.Errorf("cookie HttpOnly = %v, Secure = %v, SameSite = %v", cookie.HttpOnly, cookie.Secure, cookie.SameSite)
+	}
+}
+
+func TestSetSameSiteNoneMakesCookieSecure(t *testing.T) {
+	sessionStore := newTestStore(t)
+	sessionStore.SetSameSite(http.SameSiteNoneMode)
+
+	cookie := sessionCookie(t, sessionStore)
+	if cookie.SameSite != http.SameSiteNoneMode || !cookie.Secure {
+		t.Errorf("cookie SameSite = %v, Secure = %v, want None and secure", cookie.SameSite, cookie.Secure)
+	}
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	sessionKeyPrefix   = "session_" // redistore's key prefix for session values
+	userSessionsPrefix = "sso:user_sessions:"
+)
+
+// Adds a session to the user's set, first dropping IDs whose session has
+// expired so the set only ever holds live sessions.
+var trackSessionScript = redis.NewScript(1, `
+for _, id in ipairs(redis.call("SMEMBERS", KEYS[1])) do
+	if redis.call("EXISTS", ARGV[2] .. id) == 0 then
+		redis.call("SREM", KEYS[1], id)
+	end
+end
+return redis.call("SADD", KEYS[1], ARGV[1])
+`)
+
+var revokeSessionsScript = redis.NewScript(1, `
+local ids = redis.call("SMEMBERS", KEYS[1])
+for _, id in ipairs(ids) do
+	redis.call("DEL", ARGV[1] .. id)
+end
+redis.call("DEL", KEYS[1])
+return #ids
+`)
+
+// TrackUserSession records that sessionID belongs to the user so the session
+// can later be revoked with RevokeUserSessions. userKey is the user ID in its
+// string form.
+func TrackUserSession(ctx context.Context, pool *redis.Pool, userKey, sessionID string) error {
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = trackSessionScript.Do(conn, userSessionsPrefix+userKey, sessionID, sessionKeyPrefix)
+	return err
+}
+
+// RevokeUserSessions deletes every tracked session of the user and returns how
+// many there were.
+func RevokeUserSessions(ctx context.Context, pool *redis.Pool, userKey string) (int, error) {
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return redis.Int(revokeSessionsScript.Do(conn, userSessionsPrefix+userKey, sessionKeyPrefix))
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const userStatusPrefix = "sso:user_status:"
+
+// GetUserStatus returns the cached answer to whether the user is still
+// allowed in. found is false when nothing is cached.
+func GetUserStatus(ctx context.Context, pool *redis.Pool, userKey string) (active, found bool, err error) {
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		return false, false, err
+	}
+	defer conn.Close()
+
+	active, err = redis.Bool(conn.Do("GET", userStatusPrefix+userKey))
+	if err == redis.ErrNil {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return active, true, nil
+}
+
+// SetUserStatus caches whether the user is allowed in for ttl.
+func SetUserStatus(ctx context.Context, pool *redis.Pool, userKey string, active bool, ttl time.Duration) error {
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", userStatusPrefix+userKey, active, "PX", ttl.Milliseconds())
+	return err
+}
+
+// ClearUserStatus drops the cached status so the next request reloads it.
+func ClearUserStatus(ctx context.Context, pool *redis.Pool, userKey string) error {
+	conn, err := Conn(ctx, pool)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", userStatusPrefix+userKey)
+	return err
+}
@@ -0,0 +1,401 @@
+package usercache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/metrics"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+	"github.com/jarvisconsulting/sso-client-go/pkg/singleflight"
+	"github.com/jarvisconsulting/sso-client-go/pkg/store"
+)
+
+const (
+	userKeyPrefix  = "sso:user:"
+	emailKeyPrefix = "sso:user_email:"
+	generationKey  = "sso:user_cache:generation" // bumped by every invalidation
+	channel        = "sso:user_cache:invalidate"
+
+	defaultSize     = 10000
+	defaultLocalTTL = 30 * time.Second
+	defaultTTL      = 5 * time.Minute
+)
+
+// Config sizes the two tiers. Zero values take the defaults.
+type Config struct {
+	Size     int           // users kept in process
+	LocalTTL time.Duration // how long a replica trusts its own copy
+	TTL      time.Duration // how long a user stays in Redis
+}
+
+// Cache is a read-through user cache with an in-process LRU in front of Redis.
+// Invalidations are published over Redis pub/sub so every replica drops its
+// copy; LocalTTL bounds how stale a replica gets if it misses a message.
+type Cache struct {
+	pool    *redis.Pool
+	config  Config
+	local   *lru
+	loads   singleflight.Group
+	metrics metrics.Recorder
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(pool *redis.Pool, config Config) *Cache {
+	if config.Size <= 0 {
+		config.Size = defaultSize
+	}
+	if config.LocalTTL <= 0 {
+		config.LocalTTL = defaultLocalTTL
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaultTTL
+	}
+
+	return &Cache{
+		pool:    pool,
+		config:  config,
+		local:   newLRU(config.Size, config.LocalTTL),
+		metrics: metrics.Default(),
+	}
+}
+
+// SetMetricsRecorder replaces the recorder that receives the hit and miss
+// counters.
+func (c *Cache) SetMetricsRecorder(recorder metrics.Recorder) {
+	c.metrics = recorder
+}
+
+// FindByID returns the user with id from the cache, or from load on a miss.
+// Concurrent misses for the same user share one load, run with the context of
+// whichever caller started it; the others stop waiting when their ctx is done.
+func (c *Cache) FindByID(ctx context.Context, id uint, load func() (*models.User, error)) (*models.User, error) {
+	if user := c.cached(ctx, id); user != nil {
+		return user, nil
+	}
+
+	c.metrics.IncCounter("user_cache_misses", nil)
+	return c.load(ctx, "id:"+strconv.FormatUint(uint64(id), 10), load)
+}
+
+// FindByEmail is FindByID for a lookup by email.
+func (c *Cache) FindByEmail(ctx context.Context, email string, load func() (*models.User, error)) (*models.User, error) {
+	if id, ok := c.emailID(ctx, email); ok {
+		// The index may be stale if the user's email changed since.
+		if user := c.cached(ctx, id); user != nil && user.Email == email {
+			return user, nil
+		}
+	}
+
+	c.metrics.IncCounter("user_cache_misses", nil)
+	return c.load(ctx, "email:"+email, load)
+}
+
+// Invalidate drops the user and the email index entries pointing to it from
+// every replica's cache. Call it after the user's row changed.
+func (c *Cache) Invalidate(ctx context.Context, id uint) error {
+	conn, err := store.Conn(ctx, c.pool)
+	if err != nil {
+		c.forget(id)
+		return fmt.Errorf("error invalidating cached user %d: %w", id, err)
+	}
+	defer conn.Close()
+
+	idText := strconv.FormatUint(uint64(id), 10)
+	keys := []any{userKeyPrefix + idText}
+	for _, email := range c.cachedEmails(conn, id) {
+		keys = append(keys, emailKeyPrefix+email)
+	}
+
+	// Bumping the generation stops loads that are already running from
+	// caching the old row again once it is deleted.
+	conn.Send("MULTI")
+	conn.Send("INCR", generationKey)
+	conn.Send("DEL", keys...)
+	if _, err := conn.Do("EXEC"); err != nil {
+		c.forget(id)
+		return fmt.Errorf("error invalidating cached user %d: %w", id, err)
+	}
+
+	// The local copy goes after the Redis one, so it cannot be refilled from
+	// Redis in between.
+	c.forget(id)
+	if _, err := conn.Do("PUBLISH", channel, idText); err != nil {
+		return fmt.Errorf("error publishing invalidation of user %d: %w", id, err)
+	}
+	return nil
+}
+
+// forget drops the local copy of the user and its email index entry.
+func (c *Cache) forget(id uint) {
+	if value, ok := c.local.get(localUserKey(id)); ok {
+		c.local.remove(localEmailKey(value.(*models.User).Email))
+	}
+	c.local.remove(localUserKey(id))
+}
+
+// cachedEmails returns the emails of the local and Redis copies of the user,
+// whose index entries Invalidate deletes.
+func (c *Cache) cachedEmails(conn redis.Conn, id uint) []string {
+	var emails []string
+	if value, ok := c.local.get(localUserKey(id)); ok {
+		emails = append(emails, value.(*models.User).Email)
+	}
+
+	data, err := redis.Bytes(conn.Do("GET", userKeyPrefix+strconv.FormatUint(uint64(id), 10)))
+	if err != nil {
+		return emails
+	}
+	var user models.User
+	if err := json.Unmarshal(data, &user); err == nil && (len(emails) == 0 || emails[0] != user.Email) {
+		emails = append(emails, user.Email)
+	}
+	return emails
+}
+
+// load runs a load for a cache miss. Callers only share a load started at
+// the same local generation, so nobody waits on one that read the row before
+// an invalidation.
+func (c *Cache) load(ctx context.Context, key string, load func() (*models.User, error)) (*models.User, error) {
+	localGen := c.local.generation()
+	key += "@" + strconv.FormatUint(localGen, 10)
+
+	value, err := c.loads.DoContext(ctx, key, func() (any, error) {
+		gen, err := c.generation(ctx)
+		if err != nil {
+			log.Printf("Failed to read the user cache generation: %v", err)
+		}
+		user, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.store(ctx, user, localGen, gen)
+		return user, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return copyUser(value.(*models.User)), nil
+}
+
+// cached looks in the local tier, then in Redis, and returns a copy the
+// caller may modify.
+func (c *Cache) cached(ctx context.Context, id uint) *models.User {
+	if value, ok := c.local.get(localUserKey(id)); ok {
+		c.metrics.IncCounter("user_cache_hits", map[string]string{"tier": "local"})
+		return copyUser(value.(*models.User))
+	}
+
+	// Read before Redis: an invalidation in between keeps the copy out of
+	// the local tier.
+	localGen := c.local.generation()
+	conn, err := store.Conn(ctx, c.pool)
+	if err != nil {
+		log.Printf("Failed to read cached user %d: %v", id, err)
+		return nil
+	}
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", userKeyPrefix+strconv.FormatUint(uint64(id), 10)))
+	if err != nil {
+		if err != redis.ErrNil {
+			log.Printf("Failed to read cached user %d: %v", id, err)
+		}
+		return nil
+	}
+
+	var user models.User
+	if err := json.Unmarshal(data, &user); err != nil {
+		log.Printf("Failed to decode cached user %d: %v", id, err)
+		return nil
+	}
+
+	c.metrics.IncCounter("user_cache_hits", map[string]string{"tier": "redis"})
+	if c.local.setAt(localGen, localUserKey(id), copyUser(&user)) {
+		c.local.setAt(localGen, localEmailKey(user.Email), user.ID)
+	}
+	return &user
+}
+
+func (c *Cache) emailID(ctx context.Context, email string) (uint, bool) {
+	if value, ok := c.local.get(localEmailKey(email)); ok {
+		return value.(uint), true
+	}
+
+	conn, err := store.Conn(ctx, c.pool)
+	if err != nil {
+		log.Printf("Failed to read cached user ID for %s: %v", email, err)
+		return 0, false
+	}
+	defer conn.Close()
+
+	id, err := redis.Uint64(conn.Do("GET", emailKeyPrefix+email))
+	if err != nil {
+		if err != redis.ErrNil {
+			log.Printf("Failed to read cached user ID for %s: %v", email, err)
+		}
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// storeScript caches a user and its email index entry unless the generation
+// has moved on from ARGV[1].
+var storeScript = redis.NewScript(3, `
+if (redis.call("GET", KEYS[1]) or "0") ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[4])
+redis.call("SET", KEYS[3], ARGV[3], "PX", ARGV[4])
+return 1
+`)
+
+// generation reads the Redis generation; a missing key is generation 0.
+func (c *Cache) generation(ctx context.Context) (string, error) {
+	conn, err := store.Conn(ctx, c.pool)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	gen, err := redis.String(conn.Do("GET", generationKey))
+	if err == redis.ErrNil {
+		return "0", nil
+	}
+	return gen, err
+}
+
+// store caches a loaded user in both tiers. A tier is skipped if the user may
+// have been invalidated while it was loaded, i.e. its generation is no longer
+// the one read before the load. An empty gen skips Redis.
+func (c *Cache) store(ctx context.Context, user *models.User, localGen uint64, gen string) {
+	if c.local.setAt(localGen, localUserKey(user.ID), copyUser(user)) {
+		c.local.setAt(localGen, localEmailKey(user.Email), user.ID)
+	}
+	if gen == "" {
+		return
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		log.Printf("Failed to encode user %d for the cache: %v", user.ID, err)
+		return
+	}
+
+	conn, err := store.Conn(ctx, c.pool)
+	if err != nil {
+		log.Printf("Failed to cache user %d: %v", user.ID, err)
+		return
+	}
+	defer conn.Close()
+
+	idText := strconv.FormatUint(uint64(user.ID), 10)
+	_, err = storeScript.Do(conn, generationKey, userKeyPrefix+idText, emailKeyPrefix+user.Email,
+		gen, data, idText, c.config.TTL.Milliseconds())
+	if err != nil {
+		log.Printf("Failed to cache user %d: %v", user.ID, err)
+	}
+}
+
+// Start listens for invalidations from other replicas until Stop is called.
+func (c *Cache) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		for {
+			err := c.listen(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Messages sent while disconnected are lost, so drop every local
+			// copy rather than serve one that was invalidated meanwhile.
+			log.Printf("User cache invalidation listener stopped, reconnecting: %v", err)
+			c.local.clear()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+}
+
+// Stop ends the invalidation listener and waits for it to exit.
+func (c *Cache) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+func (c *Cache) listen(ctx context.Context) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(channel); err != nil {
+		return err
+	}
+
+	// The connection is closed only once the goroutine that may unsubscribe
+	// on it has exited.
+	stopped, exited := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(stopped)
+		<-exited
+	}()
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			psc.Unsubscribe()
+		case <-stopped:
+		}
+	}()
+
+	for {
+		// No read timeout: the connection idles until a message arrives.
+		switch message := psc.ReceiveWithTimeout(0).(type) {
+		case redis.Message:
+			id, err := strconv.ParseUint(string(message.Data), 10, 0)
+			if err != nil {
+				continue
+			}
+			c.forget(uint(id))
+		case redis.Subscription:
+			if message.Count == 0 {
+				return nil
+			}
+		case error:
+			return message
+		}
+	}
+}
+
+func localUserKey(id uint) string {
+	return "id:" + strconv.FormatUint(uint64(id), 10)
+}
+
+func localEmailKey(email string) string {
+	return "email:" + email
+}
+
+func copyUser(user *models.User) *models.User {
+	copied := *user
+	return &copied
+}
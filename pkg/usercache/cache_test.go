@@ -0,0 +1,333 @@
+package usercache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+// newTestPool returns a pool for a fresh miniredis server.
+func newTestPool(t *testing.T) (*redis.Pool, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", server.Addr()) }}
+	t.Cleanup(func() { pool.Close() })
+	return pool, server
+}
+
+// rows is a users table that counts its reads.
+type rows struct {
+	mu    sync.Mutex
+	users map[uint]models.User
+	loads int
+}
+
+func newRows(users ...models.User) *rows {
+	r := &rows{users: map[uint]models.User{}}
+	for _, user := range users {
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+func (r *rows) byID(id uint) func() (*models.User, error) {
+	return func() (*models.User, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.loads++
+		user, ok := r.users[id]
+		if !ok {
+			return nil, errors.New("record not found")
+		}
+		return &user, nil
+	}
+}
+
+func (r *rows) byEmail(email string) func() (*models.User, error) {
+	return func() (*models.User, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.loads++
+		for _, user := range r.users {
+			if user.Email == email {
+				return &user, nil
+			}
+		}
+		return nil, errors.New("record not found")
+	}
+}
+
+func (r *rows) set(user models.User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[user.ID] = user
+}
+
+func (r *rows) loadCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.loads
+}
+
+func TestFindByIDReadsThrough(t *testing.T) {
+	pool, _ := newTestPool(t)
+	db := newRows(models.User{ID: 1, Email: "user@example.com"})
+	replica, other := New(pool, Config{}), New(pool, Config{})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		user, err := replica.FindByID(ctx, 1, db.byID(1))
+		if err != nil || user.Email != "user@example.com" {
+			t.Fatalf("FindByID() = %+v, %v", user, err)
+		}
+		// Callers get copies.
+		user.Email = "changed@example.com"
+	}
+	// Another replica reads the Redis copy.
+	if user, err := other.FindByID(ctx, 1, db.byID(1)); err != nil || user.Email != "user@example.com" {
+		t.Fatalf("FindByID() on another replica = %+v, %v", user, err)
+	}
+	if got := db.loadCount(); got != 1 {
+		t.Errorf("loaded %d times, want 1", got)
+	}
+
+	if _, err := replica.FindByID(ctx, 2, db.byID(2)); err == nil {
+		t.Error("FindByID() of a missing user succeeded")
+	}
+	if _, err := replica.FindByID(ctx, 2, db.byID(2)); err == nil {
+		t.Error("FindByID() cached a failed load")
+	}
+}
+
+func TestFindByEmail(t *testing.T) {
+	pool, _ := newTestPool(t)
+	db := newRows(models.User{ID: 1, Email: "old@example.com"})
+	cache := New(pool, Config{})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if user, err := cache.FindByEmail(ctx, "old@example.com", db.byEmail("old@example.com")); err != nil || user.ID != 1 {
+			t.Fatalf("FindByEmail() = %+v, %v", user, err)
+		}
+	}
+	if got := db.loadCount(); got != 1 {
+		t.Errorf("loaded %d times, want 1", got)
+	}
+
+	db.set(models.User{ID: 1, Email: "new@example.com"})
+	if err := cache.Invalidate(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := cache.FindByEmail(ctx, "old@example.com", db.byEmail("old@example.com")); err == nil {
+		t.Errorf("FindByEmail() of the old email = %+v, want a miss that finds nobody", user)
+	}
+	if user, err := cache.FindByEmail(ctx, "new@example.com", db.byEmail("new@example.com")); err != nil || user.ID != 1 {
+		t.Errorf("FindByEmail() of the new email = %+v, %v", user, err)
+	}
+}
+
+func TestInvalidateDropsEmailIndex(t *testing.T) {
+	pool, server := newTestPool(t)
+	db := newRows(models.User{ID: 1, Email: "user@example.com"})
+	cache := New(pool, Config{})
+	ctx := context.Background()
+
+	if _, err := cache.FindByEmail(ctx, "user@example.com", db.byEmail("user@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{userKeyPrefix + "1", emailKeyPrefix + "user@example.com"} {
+		if !server.Exists(key) {
+			t.Fatalf("%s was not cached", key)
+		}
+	}
+
+	if err := cache.Invalidate(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{userKeyPrefix + "1", emailKeyPrefix + "user@example.com"} {
+		if server.Exists(key) {
+			t.Errorf("%s survived the invalidation", key)
+		}
+	}
+	for _, key := range []string{localUserKey(1), localEmailKey("user@example.com")} {
+		if _, ok := cache.local.get(key); ok {
+			t.Errorf("local %s survived the invalidation", key)
+		}
+	}
+}
+
+func TestInvalidateDuringLoad(t *testing.T) {
+	pool, server := newTestPool(t)
+	db := newRows(models.User{ID: 1, Email: "old@example.com"})
+	cache := New(pool, Config{})
+	ctx := context.Background()
+
+	// The row changes and is invalidated after the load read it.
+	stale := func() (*models.User, error) {
+		user, err := db.byID(1)()
+		db.set(models.User{ID: 1, Email: "new@example.com"})
+		if err := cache.Invalidate(ctx, 1); err != nil {
+			t.Error(err)
+		}
+		return user, err
+	}
+	if user, err := cache.FindByID(ctx, 1, stale); err != nil || user.Email != "old@example.com" {
+		t.Fatalf("FindByID() = %+v, %v", user, err)
+	}
+
+	if server.Exists(userKeyPrefix + "1") {
+		t.Error("the stale row was cached in Redis")
+	}
+	if user, err := cache.FindByID(ctx, 1, db.byID(1)); err != nil || user.Email != "new@example.com" {
+		t.Errorf("FindByID() after the invalidation = %+v, %v, want the new row", user, err)
+	}
+}
+
+func TestInvalidateOnAnotherReplicaDuringLoad(t *testing.T) {
+	pool, server := newTestPool(t)
+	db := newRows(models.User{ID: 1, Email: "old@example.com"})
+	cache, other := New(pool, Config{}), New(pool, Config{})
+	ctx := context.Background()
+
+	stale := func() (*models.User, error) {
+		user, err := db.byID(1)()
+		db.set(models.User{ID: 1, Email: "new@example.com"})
+		if err := other.Invalidate(ctx, 1); err != nil {
+			t.Error(err)
+		}
+		return user, err
+	}
+	if _, err := cache.FindByID(ctx, 1, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the Redis generation tells the loading replica about the change.
+	if server.Exists(userKeyPrefix + "1") {
+		t.Error("the stale row was cached in Redis")
+	}
+	if user, err := other.FindByID(ctx, 1, db.byID(1)); err != nil || user.Email != "new@example.com" {
+		t.Errorf("FindByID() on the invalidating replica = %+v, %v, want the new row", user, err)
+	}
+}
+
+func TestLoadsStartedBeforeInvalidateAreNotJoined(t *testing.T) {
+	pool, _ := newTestPool(t)
+	db := newRows(models.User{ID: 1, Email: "old@example.com"})
+	cache := New(pool, Config{})
+	ctx := context.Background()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := func() (*models.User, error) {
+		user, err := db.byID(1)()
+		close(started)
+		<-release
+		return user, err
+	}
+	done := make(chan *models.User)
+	go func() {
+		user, err := cache.FindByID(ctx, 1, slow)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- user
+	}()
+	<-started
+
+	db.set(models.User{ID: 1, Email: "new@example.com"})
+	if err := cache.Invalidate(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	user, err := cache.FindByID(ctx, 1, db.byID(1))
+	close(release)
+	if err != nil || user.Email != "new@example.com" {
+		t.Errorf("FindByID() after the invalidation = %+v, %v, want the new row", user, err)
+	}
+	if user := <-done; user.Email != "old@example.com" {
+		t.Errorf("first FindByID() = %+v, want the row it read", user)
+	}
+
+	if user, err := cache.FindByID(ctx, 1, db.byID(1)); err != nil || user.Email != "new@example.com" {
+		t.Errorf("cached user = %+v, %v, want the new row", user, err)
+	}
+}
+
+func TestPubSubInvalidation(t *testing.T) {
+	pool, server := newTestPool(t)
+	db := newRows(models.User{ID: 1, Email: "user@example.com"})
+	writer, reader := New(pool, Config{LocalTTL: time.Hour}), New(pool, Config{LocalTTL: time.Hour})
+	reader.Start()
+	defer reader.Stop()
+	waitFor(t, "the reader to subscribe", func() bool {
+		return server.PubSubNumSub(channel)[channel] == 1
+	})
+	ctx := context.Background()
+
+	if _, err := reader.FindByEmail(ctx, "user@example.com", db.byEmail("user@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reader.local.get(localUserKey(1)); !ok {
+		t.Fatal("the reader did not keep a local copy")
+	}
+
+	if err := writer.Invalidate(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the reader to drop its copy", func() bool {
+		_, user := reader.local.get(localUserKey(1))
+		_, email := reader.local.get(localEmailKey("user@example.com"))
+		return !user && !email
+	})
+}
+
+func TestLostSubscriptionClearsLocalCopies(t *testing.T) {
+	pool, server := newTestPool(t)
+	db := newRows(models.User{ID: 1, Email: "user@example.com"})
+	cache := New(pool, Config{LocalTTL: time.Hour})
+	cache.Start()
+	defer cache.Stop()
+	waitFor(t, "the cache to subscribe", func() bool {
+		return server.PubSubNumSub(channel)[channel] == 1
+	})
+
+	if _, err := cache.FindByID(context.Background(), 1, db.byID(1)); err != nil {
+		t.Fatal(err)
+	}
+	// Invalidations published while the subscription is down are lost.
+	server.Close()
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the local copy to be dropped", func() bool {
+		_, ok := cache.local.get(localUserKey(1))
+		return !ok
+	})
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	pool, _ := newTestPool(t)
+	New(pool, Config{}).Stop()
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
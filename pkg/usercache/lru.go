@@ -0,0 +1,117 @@
+package usercache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is a fixed-size in-process cache whose entries also expire after ttl.
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+	gen     uint64 // bumped by remove and clear; see setAt
+}
+
+type lruEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *lru) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *lru) set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setLocked(key, value)
+}
+
+// generation returns a value that changes whenever an entry is removed.
+func (c *lru) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// setAt is set, unless an entry was removed or the cache cleared since gen
+// was read. It keeps a value read before an invalidation from being cached
+// after it.
+func (c *lru) setAt(gen uint64, key string, value any) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gen != gen {
+		return false
+	}
+	c.setLocked(key, value)
+	return true
+}
+
+func (c *lru) setLocked(key string, value any) {
+	expires := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lru) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+func (c *lru) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.order.Init()
+	c.entries = make(map[string]*list.Element, c.size)
+}
@@ -0,0 +1,57 @@
+package usercache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRU(2, time.Hour)
+	cache.set("a", 1)
+	cache.set("b", 2)
+	cache.get("a")
+	cache.set("c", 3)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.get(key); ok != want {
+			t.Errorf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	cache := newLRU(2, time.Millisecond)
+	cache.set("a", 1)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := cache.get("a"); ok {
+		t.Error("expired entry was returned")
+	}
+}
+
+func TestLRUSetAt(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*lru)
+		want   bool
+	}{
+		{"unchanged", func(*lru) {}, true},
+		{"set", func(c *lru) { c.set("b", 2) }, true},
+		{"remove", func(c *lru) { c.remove("b") }, false},
+		{"clear", func(c *lru) { c.clear() }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newLRU(2, time.Hour)
+			gen := cache.generation()
+			tt.change(cache)
+
+			if got := cache.setAt(gen, "a", 1); got != tt.want {
+				t.Errorf("setAt() = %v, want %v", got, tt.want)
+			}
+			if _, ok := cache.get("a"); ok != tt.want {
+				t.Errorf("a cached = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
@@ -1,104 +1,281 @@
 package ssoclient
 
 import (
+	"context"
 	"errors"
+	"log"
+	"time"
 
 	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+	"github.com/jarvisconsulting/sso-client-go/pkg/usercache"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrAccessTokenConsumed is returned when a JTI is consumed a second time.
+var ErrAccessTokenConsumed = errors.New("access token JTI was already used")
+
 type UserRepository struct {
 	primaryDB   *gorm.DB
 	secondaryDB *gorm.DB
+	cache       *usercache.Cache
+	timeout     time.Duration
+	columns     userColumns
 }
 
 func NewUserRepository(primaryDB *gorm.DB, secondaryDB *gorm.DB) *UserRepository {
+	// Group members live in a join table whose name is configurable.
+	for _, db := range []*gorm.DB{primaryDB, secondaryDB} {
+		if db == nil {
+			continue
+		}
+		if err := db.SetupJoinTable(&models.Group{}, "Members", &models.GroupMember{}); err != nil {
+			log.Printf("Failed to set up group members table: %v", err)
+		}
+	}
+
 	return &UserRepository{
 		primaryDB:   primaryDB,
 		secondaryDB: secondaryDB,
 	}
 }
 
-// tryDBs attempts to execute the given function first on primary DB, then on secondary if primary fails
-func (r *UserRepository) tryDBs(operation func(*gorm.DB) error) error {
-	if err := operation(r.primaryDB); err != nil && r.secondaryDB != nil {
-		return operation(r.secondaryDB)
+// Every method has a Context variant taking ctx first; the plain method uses
+// context.Background(). Each database call is also bounded by the timeout set
+// with SetTimeout.
+
+// SetTimeout bounds each database call. Zero means no limit beyond ctx.
+func (r *UserRepository) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+func (r *UserRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// tryDBs attempts to execute the given function first on primary DB, then on secondary if primary fails.
+// Each attempt gets its own timeout; the secondary is skipped once ctx is done.
+func (r *UserRepository) tryDBs(ctx context.Context, operation func(*gorm.DB) error) error {
+	err := r.run(ctx, r.primaryDB, operation)
+	if err != nil && r.secondaryDB != nil && ctx.Err() == nil {
+		return r.run(ctx, r.secondaryDB, operation)
+	}
+	return err
+}
+
+func (r *UserRepository) run(ctx context.Context, db *gorm.DB, operation func(*gorm.DB) error) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	return operation(db.WithContext(ctx))
+}
+
+// onSecondary runs the function on the secondary DB only, which holds the
+// JTIs and signing keys written by the SSO server.
+func (r *UserRepository) onSecondary(ctx context.Context, operation func(*gorm.DB) error) error {
+	if r.secondaryDB == nil {
+		return errors.New("secondary database not available")
+	}
+	return r.run(ctx, r.secondaryDB, operation)
+}
+
+// SetCache makes FindByID and FindByEmail read through cache. Update, Delete
+// and UpsertUser invalidate it.
+func (r *UserRepository) SetCache(cache *usercache.Cache) {
+	r.cache = cache
+}
+
+// invalidate drops a changed user from the cache. A failure leaves the old
+// copy until its TTL runs out.
+func (r *UserRepository) invalidate(ctx context.Context, id uint) {
+	if r.cache == nil || id == 0 {
+		return
+	}
+	if err := r.cache.Invalidate(ctx, id); err != nil {
+		log.Printf("Failed to invalidate cached user: %v", err)
 	}
-	return nil
 }
 
 func (r *UserRepository) FindByID(id uint) (*models.User, error) {
-	var user models.User
-	var lastErr error
+	return r.FindByIDContext(context.Background(), id)
+}
 
-	// Try primary DB
-	result := r.primaryDB.First(&user, id)
-	if result.Error == nil {
-		return &user, nil
+func (r *UserRepository) FindByIDContext(ctx context.Context, id uint) (*models.User, error) {
+	if r.cache != nil {
+		return r.cache.FindByID(ctx, id, func() (*models.User, error) {
+			return r.findByID(ctx, id)
+		})
 	}
-	lastErr = result.Error
+	return r.findByID(ctx, id)
+}
 
-	// Try secondary DB if available
-	if r.secondaryDB != nil {
-		result = r.secondaryDB.First(&user, id)
-		if result.Error == nil {
-			return &user, nil
-		}
-		lastErr = result.Error
+// FindByIDUncached reads the user from the database even when a cache is set,
+// for callers that are about to modify the row or must see its current state.
+func (r *UserRepository) FindByIDUncached(id uint) (*models.User, error) {
+	return r.FindByIDUncachedContext(context.Background(), id)
+}
+
+func (r *UserRepository) FindByIDUncachedContext(ctx context.Context, id uint) (*models.User, error) {
+	return r.findByID(ctx, id)
+}
+
+func (r *UserRepository) findByID(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+
+	err := r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.First(&user, id).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, lastErr
+	return &user, nil
 }
 
 func (r *UserRepository) Create(user *models.User) error {
-	return r.tryDBs(func(db *gorm.DB) error {
-		return db.Create(user).Error
+	return r.CreateContext(context.Background(), user)
+}
+
+func (r *UserRepository) CreateContext(ctx context.Context, user *models.User) error {
+	return r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.Omit(r.columns.missingFrom(db)...).Create(user).Error
 	})
 }
 
 func (r *UserRepository) Update(user *models.User) error {
-	return r.tryDBs(func(db *gorm.DB) error {
-		return db.Save(user).Error
+	return r.UpdateContext(context.Background(), user)
+}
+
+func (r *UserRepository) UpdateContext(ctx context.Context, user *models.User) error {
+	err := r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.Omit(r.columns.missingFrom(db)...).Save(user).Error
 	})
+	r.invalidate(ctx, user.ID)
+	return err
+}
+
+// UpsertUser inserts the user with its ID or, if the row exists, updates the
+// given columns. With no columns only a missing row is created.
+func (r *UserRepository) UpsertUser(user *models.User, columns ...string) error {
+	return r.UpsertUserContext(context.Background(), user, columns...)
+}
+
+func (r *UserRepository) UpsertUserContext(ctx context.Context, user *models.User, columns ...string) error {
+	onConflict := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoNothing: len(columns) == 0,
+	}
+	if len(columns) > 0 {
+		onConflict.DoUpdates = clause.AssignmentColumns(append(columns, "updated_at"))
+	}
+
+	err := r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.Clauses(onConflict).Omit(r.columns.missingFrom(db)...).Create(user).Error
+	})
+	r.invalidate(ctx, user.ID)
+	return err
 }
 
 func (r *UserRepository) Delete(id uint) error {
-	return r.tryDBs(func(db *gorm.DB) error {
-		return db.Delete(&models.User{}, id).Error
+	return r.DeleteContext(context.Background(), id)
+}
+
+func (r *UserRepository) DeleteContext(ctx context.Context, id uint) error {
+	err := r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			// Group memberships only exist once SCIM groups are in use.
+			if tx.Migrator().HasTable(&models.GroupMember{}) {
+				if err := tx.Where("user_id = ?", id).Delete(&models.GroupMember{}).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&models.User{}, id).Error
+		})
 	})
+	r.invalidate(ctx, id)
+	return err
 }
 
 func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
+	return r.FindByEmailContext(context.Background(), email)
+}
+
+func (r *UserRepository) FindByEmailContext(ctx context.Context, email string) (*models.User, error) {
+	if r.cache != nil {
+		return r.cache.FindByEmail(ctx, email, func() (*models.User, error) {
+			return r.findByEmail(ctx, email)
+		})
+	}
+	return r.findByEmail(ctx, email)
+}
+
+func (r *UserRepository) findByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	var lastErr error
 
-	// Try primary DB
-	result := r.primaryDB.Where("email = ?", email).First(&user)
-	if result.Error == nil {
-		return &user, nil
+	err := r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.Where("email = ?", email).First(&user).Error
+	})
+	if err != nil {
+		return nil, err
 	}
-	lastErr = result.Error
 
-	// Try secondary DB if available
-	if r.secondaryDB != nil {
-		result = r.secondaryDB.Where("email = ?", email).First(&user)
-		if result.Error == nil {
-			return &user, nil
+	return &user, nil
+}
+
+// ListUsers returns up to limit users matching where, ordered by ID, and the
+// total number of matches. An empty where matches every user.
+func (r *UserRepository) ListUsers(where string, args []any, offset, limit int) ([]models.User, int64, error) {
+	return r.ListUsersContext(context.Background(), where, args, offset, limit)
+}
+
+func (r *UserRepository) ListUsersContext(ctx context.Context, where string, args []any, offset, limit int) ([]models.User, int64, error) {
+	var users []models.User
+	var total int64
+
+	err := r.tryDBs(ctx, func(db *gorm.DB) error {
+		users, total = nil, 0
+		scope := func(db *gorm.DB) *gorm.DB {
+			db = db.Model(&models.User{})
+			if where != "" {
+				db = db.Where(where, args...)
+			}
+			return db
+		}
+
+		if err := db.Scopes(scope).Count(&total).Error; err != nil {
+			return err
 		}
-		lastErr = result.Error
+		if limit <= 0 {
+			return nil
+		}
+		return db.Scopes(scope).Order("id").Offset(offset).Limit(limit).Find(&users).Error
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return nil, lastErr
+	return users, total, nil
 }
 
 func (r *UserRepository) FindByJTI(jti string) (uint, error) {
+	return r.FindByJTIContext(context.Background(), jti)
+}
+
+func (r *UserRepository) FindByJTIContext(ctx context.Context, jti string) (uint, error) {
 	var token models.UserAccessToken
 
 	if r.secondaryDB == nil {
 		return 0, errors.New("secondary database not available")
 	}
 
-	result := r.secondaryDB.Where("jti = ?", jti).First(&token)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	// A consumed JTI has already signed someone in and is not found again.
+	result := r.secondaryDB.WithContext(ctx).Where("jti = ? AND consumed_at IS NULL", jti).First(&token)
 	if result.Error != nil {
 		return 0, result.Error
 	}
@@ -107,13 +284,20 @@ func (r *UserRepository) FindByJTI(jti string) (uint, error) {
 }
 
 func (r *UserRepository) GetLastSshKey() (*models.SshKey, error) {
+	return r.GetLastSshKeyContext(context.Background())
+}
+
+func (r *UserRepository) GetLastSshKeyContext(ctx context.Context) (*models.SshKey, error) {
 	var sshKey models.SshKey
 
 	if r.secondaryDB == nil {
 		return nil, errors.New("secondary database not available")
 	}
 
-	result := r.secondaryDB.Order("id desc").First(&sshKey)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result := r.secondaryDB.WithContext(ctx).Order("id desc").First(&sshKey)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -121,35 +305,289 @@ func (r *UserRepository) GetLastSshKey() (*models.SshKey, error) {
 	return &sshKey, nil
 }
 
+// GetLastSshKeys returns up to limit signing keys, newest first.
+func (r *UserRepository) GetLastSshKeys(limit int) ([]models.SshKey, error) {
+	return r.GetLastSshKeysContext(context.Background(), limit)
+}
+
+func (r *UserRepository) GetLastSshKeysContext(ctx context.Context, limit int) ([]models.SshKey, error) {
+	var sshKeys []models.SshKey
+
+	if r.secondaryDB == nil {
+		return nil, errors.New("secondary database not available")
+	}
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result := r.secondaryDB.WithContext(ctx).Order("id desc").Limit(limit).Find(&sshKeys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return sshKeys, nil
+}
+
+// GetLastSshPublicKeys returns up to limit public signing keys, newest first.
+func (r *UserRepository) GetLastSshPublicKeys(limit int) ([]models.SshPublicKey, error) {
+	return r.GetLastSshPublicKeysContext(context.Background(), limit)
+}
+
+func (r *UserRepository) GetLastSshPublicKeysContext(ctx context.Context, limit int) ([]models.SshPublicKey, error) {
+	var publicKeys []models.SshPublicKey
+
+	if r.secondaryDB == nil {
+		return nil, errors.New("secondary database not available")
+	}
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result := r.secondaryDB.WithContext(ctx).Order("id desc").Limit(limit).Find(&publicKeys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return publicKeys, nil
+}
+
 func (r *UserRepository) CreateAccessToken(userID uint, jti string) error {
+	return r.CreateAccessTokenContext(context.Background(), userID, jti)
+}
+
+func (r *UserRepository) CreateAccessTokenContext(ctx context.Context, userID uint, jti string) error {
 	token := &models.UserAccessToken{
 		UserID: userID,
 		JTI:    jti,
 	}
-	return r.tryDBs(func(db *gorm.DB) error {
+	return r.tryDBs(ctx, func(db *gorm.DB) error {
 		return db.Create(token).Error
 	})
 }
 
 func (r *UserRepository) DeleteAccessToken(jti string) error {
-	return r.tryDBs(func(db *gorm.DB) error {
+	return r.DeleteAccessTokenContext(context.Background(), jti)
+}
+
+func (r *UserRepository) DeleteAccessTokenContext(ctx context.Context, jti string) error {
+	return r.tryDBs(ctx, func(db *gorm.DB) error {
 		return db.Where("jti = ?", jti).Delete(&models.UserAccessToken{}).Error
 	})
 }
 
+// ConsumeAccessToken records that a callback signed a user in with jti. It
+// returns ErrAccessTokenConsumed if jti is unknown or was already used, so of
+// two callbacks racing with the same JTI only one succeeds.
+func (r *UserRepository) ConsumeAccessToken(jti string) error {
+	return r.ConsumeAccessTokenContext(context.Background(), jti)
+}
+
+func (r *UserRepository) ConsumeAccessTokenContext(ctx context.Context, jti string) error {
+	return r.onSecondary(ctx, func(db *gorm.DB) error {
+		result := db.Model(&models.UserAccessToken{}).
+			Where("jti = ? AND consumed_at IS NULL", jti).
+			Update("consumed_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrAccessTokenConsumed
+		}
+		return nil
+	})
+}
+
+// DeleteAccessTokens deletes up to limit JTIs created before createdBefore or
+// consumed before consumedBefore, and returns how many it deleted.
+func (r *UserRepository) DeleteAccessTokens(createdBefore, consumedBefore time.Time, limit int) (int64, error) {
+	return r.DeleteAccessTokensContext(context.Background(), createdBefore, consumedBefore, limit)
+}
+
+func (r *UserRepository) DeleteAccessTokensContext(ctx context.Context, createdBefore, consumedBefore time.Time, limit int) (int64, error) {
+	var deleted int64
+
+	err := r.onSecondary(ctx, func(db *gorm.DB) error {
+		var ids []uint
+		err := db.Model(&models.UserAccessToken{}).
+			Where("created_at < ? OR consumed_at < ?", createdBefore, consumedBefore).
+			Order("id").Limit(limit).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		result := db.Delete(&models.UserAccessToken{}, ids)
+		deleted = result.RowsAffected
+		return result.Error
+	})
+
+	return deleted, err
+}
+
+// DeleteRetiredSshKeys deletes up to limit signing keys that are not among
+// the newest keep keys and were created before createdBefore.
+func (r *UserRepository) DeleteRetiredSshKeys(keep int, createdBefore time.Time, limit int) (int64, error) {
+	return r.DeleteRetiredSshKeysContext(context.Background(), keep, createdBefore, limit)
+}
+
+func (r *UserRepository) DeleteRetiredSshKeysContext(ctx context.Context, keep int, createdBefore time.Time, limit int) (int64, error) {
+	return r.deleteRetiredKeys(ctx, &models.SshKey{}, keep, createdBefore, limit)
+}
+
+// DeleteRetiredSshPublicKeys is DeleteRetiredSshKeys for ssh_public_keys.
+func (r *UserRepository) DeleteRetiredSshPublicKeys(keep int, createdBefore time.Time, limit int) (int64, error) {
+	return r.DeleteRetiredSshPublicKeysContext(context.Background(), keep, createdBefore, limit)
+}
+
+func (r *UserRepository) DeleteRetiredSshPublicKeysContext(ctx context.Context, keep int, createdBefore time.Time, limit int) (int64, error) {
+	return r.deleteRetiredKeys(ctx, &models.SshPublicKey{}, keep, createdBefore, limit)
+}
+
+func (r *UserRepository) deleteRetiredKeys(ctx context.Context, model any, keep int, createdBefore time.Time, limit int) (int64, error) {
+	var deleted int64
+
+	err := r.onSecondary(ctx, func(db *gorm.DB) error {
+		// Only one of the key tables is in use, depending on SigningKeySource.
+		if !db.Migrator().HasTable(model) {
+			return nil
+		}
+
+		var newest []uint
+		if err := db.Model(model).Order("id desc").Limit(keep).Pluck("id", &newest).Error; err != nil {
+			return err
+		}
+		if len(newest) == 0 {
+			return nil
+		}
+
+		var ids []uint
+		err := db.Model(model).
+			Where("id NOT IN ? AND created_at < ?", newest, createdBefore).
+			Order("id").Limit(limit).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		result := db.Delete(model, ids)
+		deleted = result.RowsAffected
+		return result.Error
+	})
+
+	return deleted, err
+}
+
+// Group methods, used by the SCIM server
+
+// ListGroups returns up to limit groups matching where, with their members,
+// ordered by ID, and the total number of matches.
+func (r *UserRepository) ListGroups(where string, args []any, offset, limit int) ([]models.Group, int64, error) {
+	return r.ListGroupsContext(context.Background(), where, args, offset, limit)
+}
+
+func (r *UserRepository) ListGroupsContext(ctx context.Context, where string, args []any, offset, limit int) ([]models.Group, int64, error) {
+	var groups []models.Group
+	var total int64
+
+	err := r.tryDBs(ctx, func(db *gorm.DB) error {
+		groups, total = nil, 0
+		scope := func(db *gorm.DB) *gorm.DB {
+			db = db.Model(&models.Group{})
+			if where != "" {
+				db = db.Where(where, args...)
+			}
+			return db
+		}
+
+		if err := db.Scopes(scope).Count(&total).Error; err != nil {
+			return err
+		}
+		if limit <= 0 {
+			return nil
+		}
+		return db.Scopes(scope).Preload("Members").Order("id").Offset(offset).Limit(limit).Find(&groups).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return groups, total, nil
+}
+
+func (r *UserRepository) FindGroup(id uint) (*models.Group, error) {
+	return r.FindGroupContext(context.Background(), id)
+}
+
+func (r *UserRepository) FindGroupContext(ctx context.Context, id uint) (*models.Group, error) {
+	var group models.Group
+
+	err := r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.Preload("Members").First(&group, id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// CreateGroup inserts the group and links its members, which must already
+// exist; their rows are not written.
+func (r *UserRepository) CreateGroup(group *models.Group) error {
+	return r.CreateGroupContext(context.Background(), group)
+}
+
+func (r *UserRepository) CreateGroupContext(ctx context.Context, group *models.Group) error {
+	return r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.Omit("Members.*").Create(group).Error
+	})
+}
+
+// UpdateGroup saves the group's attributes and replaces its members.
+func (r *UserRepository) UpdateGroup(group *models.Group) error {
+	return r.UpdateGroupContext(context.Background(), group)
+}
+
+func (r *UserRepository) UpdateGroupContext(ctx context.Context, group *models.Group) error {
+	return r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Omit("Members").Save(group).Error; err != nil {
+				return err
+			}
+			return tx.Model(group).Omit("Members.*").Association("Members").Replace(group.Members)
+		})
+	})
+}
+
+func (r *UserRepository) DeleteGroup(id uint) error {
+	return r.DeleteGroupContext(context.Background(), id)
+}
+
+func (r *UserRepository) DeleteGroupContext(ctx context.Context, id uint) error {
+	return r.tryDBs(ctx, func(db *gorm.DB) error {
+		return db.Select("Members").Delete(&models.Group{ID: id}).Error
+	})
+}
+
 // Helper methods for SSH keys
 
 func (r *UserRepository) CreateSshKey(key string) error {
+	return r.CreateSshKeyContext(context.Background(), key)
+}
+
+func (r *UserRepository) CreateSshKeyContext(ctx context.Context, key string) error {
 	sshKey := &models.SshKey{
 		PrivateRsaKey: key,
 	}
-	return r.tryDBs(func(db *gorm.DB) error {
+	return r.tryDBs(ctx, func(db *gorm.DB) error {
 		return db.Create(sshKey).Error
 	})
 }
 
 func (r *UserRepository) DeleteSshKey(id uint) error {
-	return r.tryDBs(func(db *gorm.DB) error {
+	return r.DeleteSshKeyContext(context.Background(), id)
+}
+
+func (r *UserRepository) DeleteSshKeyContext(ctx context.Context, id uint) error {
+	return r.tryDBs(ctx, func(db *gorm.DB) error {
 		return db.Delete(&models.SshKey{}, id).Error
 	})
 }
@@ -0,0 +1,495 @@
+package ssoclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+	"github.com/jarvisconsulting/sso-client-go/pkg/usercache"
+)
+
+var testDBs atomic.Int32
+
+// newTestDB opens a private in-memory SQLite database with the given models
+// migrated.
+func newTestDB(t *testing.T, tables ...any) *gorm.DB {
+	t.Helper()
+
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", name, testDBs.Add(1))), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestUpsertUser(t *testing.T) {
+	db := newTestDB(t, &models.User{})
+	repo := NewUserRepository(db, nil)
+
+	if err := repo.UpsertUser(&models.User{ID: 1, Email: "old@example.com", Name: "Old"}, "email", "name"); err != nil {
+		t.Fatal(err)
+	}
+	// Only the listed columns change on conflict.
+	if err := repo.UpsertUser(&models.User{ID: 1, Email: "new@example.com", Name: "Ignored"}, "email"); err != nil {
+		t.Fatal(err)
+	}
+	// Without columns an existing row is left alone.
+	if err := repo.UpsertUser(&models.User{ID: 1, Email: "other@example.com", Name: "Other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.FindByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "new@example.com" || user.Name != "Old" {
+		t.Errorf("user = %q %q, want new@example.com Old", user.Email, user.Name)
+	}
+}
+
+func TestUpsertUserFallsBackToSecondary(t *testing.T) {
+	primary := newTestDB(t)
+	secondary := newTestDB(t, &models.User{})
+	repo := NewUserRepository(primary, secondary)
+
+	if err := repo.UpsertUser(&models.User{ID: 1, Email: "user@example.com", Name: "User"}, "email", "name"); err != nil {
+		t.Fatalf("UpsertUser() error = %v", err)
+	}
+
+	var count int64
+	secondary.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("secondary has %d users, want 1", count)
+	}
+}
+
+func TestRepositoryStopsWithContext(t *testing.T) {
+	primary := newTestDB(t, &models.User{})
+	secondary := newTestDB(t, &models.User{})
+	repo := NewUserRepository(primary, secondary)
+	if err := secondary.Create(&models.User{ID: 1, Email: "user@example.com", Name: "User"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// A done context skips the secondary too.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if user, err := repo.FindByIDContext(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("FindByIDContext() = %+v, %v, want context.Canceled", user, err)
+	}
+
+	// The timeout bounds each database call.
+	repo.SetTimeout(time.Nanosecond)
+	if _, err := repo.FindByID(1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FindByID() error = %v, want context.DeadlineExceeded", err)
+	}
+	repo.SetTimeout(time.Minute)
+	if user, err := repo.FindByID(1); err != nil || user.Email != "user@example.com" {
+		t.Errorf("FindByID() = %+v, %v, want the secondary's user", user, err)
+	}
+}
+
+func TestCreateKeepsInactiveUsersInactive(t *testing.T) {
+	db := newTestDB(t, &models.User{})
+	repo := NewUserRepository(db, nil)
+
+	tests := []struct {
+		name   string
+		active *bool
+		want   bool
+	}{
+		{"inactive", boolPtr(false), false},
+		{"active", boolPtr(true), true},
+		{"unset", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{Email: tt.name + "@example.com", Name: tt.name, Active: tt.active}
+			if err := repo.Create(user); err != nil {
+				t.Fatal(err)
+			}
+			got, err := repo.FindByID(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.IsActive() != tt.want || got.CreatedAt.IsZero() {
+				t.Errorf("IsActive() = %v, CreatedAt = %v, want active %v", got.IsActive(), got.CreatedAt, tt.want)
+			}
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestFindByIDUncachedBypassesCache(t *testing.T) {
+	db := newTestDB(t, &models.User{})
+	repo := NewUserRepository(db, nil)
+	redisServer := miniredis.RunT(t)
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", redisServer.Addr()) }}
+	t.Cleanup(func() { pool.Close() })
+	repo.SetCache(usercache.New(pool, usercache.Config{}))
+
+	user := &models.User{Email: "user@example.com", Name: "User"}
+	if err := repo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.FindByID(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	// A write that skips the repository leaves the cached copy stale.
+	if err := db.Model(&models.User{}).Where("id = ?", user.ID).Update("name", "Renamed").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := repo.FindByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Name != "User" {
+		t.Fatalf("FindByID() name = %q, want the cached User", cached.Name)
+	}
+	fresh, err := repo.FindByIDUncached(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Name != "Renamed" {
+		t.Errorf("FindByIDUncached() name = %q, want Renamed", fresh.Name)
+	}
+}
+
+// newTokenDBs returns a repository whose primary and secondary databases both
+// have the JTI and signing key tables.
+func newTokenDBs(t *testing.T) (repo *UserRepository, primary, secondary *gorm.DB) {
+	t.Helper()
+
+	tables := []any{&models.User{}, &models.UserAccessToken{}, &models.SshKey{}}
+	primary = newTestDB(t, tables...)
+	secondary = newTestDB(t, tables...)
+	return NewUserRepository(primary, secondary), primary, secondary
+}
+
+// addToken inserts a JTI for user 1, consumed at consumedAt unless it is zero.
+func addToken(t *testing.T, db *gorm.DB, jti string, createdAt, consumedAt time.Time) {
+	t.Helper()
+
+	token := &models.UserAccessToken{UserID: 1, JTI: jti, CreatedAt: createdAt}
+	if !consumedAt.IsZero() {
+		token.ConsumedAt = &consumedAt
+	}
+	if err := db.Create(token).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func addKeys(t *testing.T, db *gorm.DB, createdAt ...time.Time) {
+	t.Helper()
+
+	for i, created := range createdAt {
+		key := &models.SshKey{PrivateRsaKey: fmt.Sprintf("key-%d", i), CreatedAt: created}
+		if err := db.Create(key).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func jtis(t *testing.T, db *gorm.DB) []string {
+	t.Helper()
+
+	var jtis []string
+	if err := db.Model(&models.UserAccessToken{}).Order("jti").Pluck("jti", &jtis).Error; err != nil {
+		t.Fatal(err)
+	}
+	return jtis
+}
+
+func keyIDs(t *testing.T, db *gorm.DB) []uint {
+	t.Helper()
+
+	var ids []uint
+	if err := db.Model(&models.SshKey{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestFindByJTIRejectsConsumedJTI(t *testing.T) {
+	repo, _, secondary := newTokenDBs(t)
+	addToken(t, secondary, "jti", time.Now(), time.Time{})
+
+	if id, err := repo.FindByJTI("jti"); err != nil || id != 1 {
+		t.Fatalf("FindByJTI() = %d, %v, want 1", id, err)
+	}
+	if err := repo.ConsumeAccessToken("jti"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.FindByJTI("jti"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("FindByJTI() of a consumed JTI error = %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestConsumeAccessTokenOnce(t *testing.T) {
+	// A file database, since concurrent writers to a shared-cache memory
+	// database fail with "table is locked" instead of waiting their turn.
+	secondary, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sso.db")+"?_busy_timeout=5000"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := secondary.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := secondary.AutoMigrate(&models.UserAccessToken{}); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewUserRepository(newTestDB(t), secondary)
+	addToken(t, secondary, "jti", time.Now(), time.Time{})
+
+	const callbacks = 8
+	errs := make(chan error, callbacks)
+	var wg sync.WaitGroup
+	for i := 0; i < callbacks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repo.ConsumeAccessToken("jti")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrAccessTokenConsumed):
+			t.Errorf("ConsumeAccessToken() error = %v, want ErrAccessTokenConsumed", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d concurrent ConsumeAccessToken() calls succeeded, want 1", succeeded)
+	}
+	if err := repo.ConsumeAccessToken("unknown"); !errors.Is(err, ErrAccessTokenConsumed) {
+		t.Errorf("ConsumeAccessToken() of an unknown JTI error = %v, want ErrAccessTokenConsumed", err)
+	}
+}
+
+func TestConsumeAccessTokenUsesSecondary(t *testing.T) {
+	repo, primary, secondary := newTokenDBs(t)
+	now := time.Now()
+	addToken(t, primary, "jti", now, time.Time{})
+	addToken(t, secondary, "jti", now, time.Time{})
+
+	if err := repo.ConsumeAccessToken("jti"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, db := range map[string]*gorm.DB{"primary": primary, "secondary": secondary} {
+		var token models.UserAccessToken
+		if err := db.First(&token, "jti = ?", "jti").Error; err != nil {
+			t.Fatal(err)
+		}
+		if consumed := token.ConsumedAt != nil; consumed != (name == "secondary") {
+			t.Errorf("%s JTI consumed = %v", name, consumed)
+		}
+	}
+
+	if err := NewUserRepository(primary, nil).ConsumeAccessToken("jti"); err == nil {
+		t.Error("ConsumeAccessToken() without a secondary database succeeded")
+	}
+}
+
+func TestDeleteAccessTokens(t *testing.T) {
+	repo, primary, secondary := newTokenDBs(t)
+	now := time.Now()
+	for _, db := range []*gorm.DB{primary, secondary} {
+		addToken(t, db, "old-unused", now.Add(-48*time.Hour), time.Time{})
+		addToken(t, db, "fresh-unused", now.Add(-time.Minute), time.Time{})
+		addToken(t, db, "consumed-long-ago", now.Add(-10*time.Minute), now.Add(-10*time.Minute))
+		addToken(t, db, "consumed-recently", now.Add(-2*time.Minute), now.Add(-time.Minute))
+	}
+
+	deleted, err := repo.DeleteAccessTokens(now.Add(-24*time.Hour), now.Add(-5*time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted %d JTIs, want 2", deleted)
+	}
+	if got := strings.Join(jtis(t, secondary), ","); got != "consumed-recently,fresh-unused" {
+		t.Errorf("secondary JTIs = %s, want consumed-recently,fresh-unused", got)
+	}
+	if got := len(jtis(t, primary)); got != 4 {
+		t.Errorf("primary has %d JTIs, want all 4 left alone", got)
+	}
+}
+
+func TestDeleteAccessTokensInBatches(t *testing.T) {
+	repo, _, secondary := newTokenDBs(t)
+	old := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 5; i++ {
+		addToken(t, secondary, fmt.Sprintf("jti-%d", i), old, time.Time{})
+	}
+
+	var batches []int64
+	for {
+		deleted, err := repo.DeleteAccessTokens(time.Now().Add(-time.Hour), time.Now().Add(-time.Hour), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, deleted)
+		if deleted < 2 {
+			break
+		}
+	}
+	if fmt.Sprint(batches) != "[2 2 1]" {
+		t.Errorf("batches = %v, want [2 2 1]", batches)
+	}
+}
+
+func TestDeleteRetiredSshKeys(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-10 * 24 * time.Hour)
+	recent := now.Add(-24 * time.Hour)
+
+	tests := []struct {
+		name      string
+		created   []time.Time
+		keep      int
+		limit     int
+		wantKeys  []uint
+		wantCount int64
+	}{
+		{"keeps the newest keys", []time.Time{old, old, old, old, old}, 2, 10, []uint{4, 5}, 3},
+		{"keeps keys in the grace period", []time.Time{old, old, recent, old, recent}, 1, 10, []uint{3, 5}, 3},
+		{"deletes oldest first up to the limit", []time.Time{old, old, old, old}, 1, 2, []uint{3, 4}, 2},
+		{"never deletes the only key", []time.Time{old}, 1, 10, []uint{1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, primary, secondary := newTokenDBs(t)
+			addKeys(t, primary, tt.created...)
+			addKeys(t, secondary, tt.created...)
+
+			deleted, err := repo.DeleteRetiredSshKeys(tt.keep, now.Add(-7*24*time.Hour), tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.wantCount {
+				t.Errorf("deleted %d keys, want %d", deleted, tt.wantCount)
+			}
+			if got := keyIDs(t, secondary); fmt.Sprint(got) != fmt.Sprint(tt.wantKeys) {
+				t.Errorf("secondary keys = %v, want %v", got, tt.wantKeys)
+			}
+			if got := len(keyIDs(t, primary)); got != len(tt.created) {
+				t.Errorf("primary has %d keys, want all %d left alone", got, len(tt.created))
+			}
+		})
+	}
+}
+
+func TestDeleteRetiredSshPublicKeysWithoutTable(t *testing.T) {
+	repo, _, _ := newTokenDBs(t)
+
+	deleted, err := repo.DeleteRetiredSshPublicKeys(1, time.Now(), 10)
+	if err != nil || deleted != 0 {
+		t.Errorf("DeleteRetiredSshPublicKeys() = %d, %v, want 0 for a missing table", deleted, err)
+	}
+}
+
+func TestUsersTableWithoutOptionalColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		// whether the table can store an inactive user
+		hasActive bool
+	}{
+		{
+			name:   "before SCIM",
+			schema: `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT NOT NULL UNIQUE, name TEXT NOT NULL, created_at DATETIME, updated_at DATETIME)`,
+		},
+		{
+			name: "before user status",
+			schema: `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT NOT NULL UNIQUE, name TEXT NOT NULL,
+				external_id TEXT NOT NULL DEFAULT '', active BOOLEAN NOT NULL DEFAULT 1, created_at DATETIME, updated_at DATETIME)`,
+			hasActive: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if err := db.Exec(tt.schema).Error; err != nil {
+				t.Fatal(err)
+			}
+			repo := NewUserRepository(db, nil)
+
+			user := &models.User{Email: "user@example.com", Name: "User"}
+			if err := repo.Create(user); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			user.Name = "Renamed"
+			if err := repo.Update(user); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			if err := repo.UpsertUser(&models.User{ID: 2, Email: "other@example.com", Name: "Other"}, "email", "name"); err != nil {
+				t.Fatalf("UpsertUser() error = %v", err)
+			}
+
+			loaded, err := repo.FindByID(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Name != "Renamed" || loaded.Disabled() {
+				t.Errorf("FindByID() = %+v, want the renamed, enabled user", loaded)
+			}
+			if loaded, err := repo.FindByEmail("other@example.com"); err != nil || loaded.Disabled() {
+				t.Errorf("FindByEmail() = %+v, %v, want an enabled user", loaded, err)
+			}
+			users, _, err := repo.ListUsers("", nil, 0, 10)
+			if err != nil || len(users) != 2 || users[0].Disabled() || users[1].Disabled() {
+				t.Errorf("ListUsers() = %+v, %v, want two enabled users", users, err)
+			}
+
+			if !tt.hasActive {
+				return
+			}
+			inactive := &models.User{Email: "inactive@example.com", Name: "Inactive", Active: boolPtr(false)}
+			if err := repo.Create(inactive); err != nil {
+				t.Fatal(err)
+			}
+			if loaded, err := repo.FindByID(inactive.ID); err != nil || !loaded.Disabled() {
+				t.Errorf("FindByID() = %+v, %v, want the inactive user disabled", loaded, err)
+			}
+		})
+	}
+}
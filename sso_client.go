@@ -1,39 +1,71 @@
 package ssoclient
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/sessions"
 	"gorm.io/gorm"
 
 	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
 	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/janitor"
+	"github.com/jarvisconsulting/sso-client-go/pkg/jwe"
+	"github.com/jarvisconsulting/sso-client-go/pkg/keys"
+	"github.com/jarvisconsulting/sso-client-go/pkg/metrics"
 	"github.com/jarvisconsulting/sso-client-go/pkg/middleware"
+	"github.com/jarvisconsulting/sso-client-go/pkg/migrate"
 	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+	"github.com/jarvisconsulting/sso-client-go/pkg/oauth"
+	"github.com/jarvisconsulting/sso-client-go/pkg/scim"
 	"github.com/jarvisconsulting/sso-client-go/pkg/store"
+	"github.com/jarvisconsulting/sso-client-go/pkg/usercache"
+)
+
+const (
+	defaultRedisDialTimeout  = 5000
+	defaultRedisReadTimeout  = 3000
+	defaultRedisWriteTimeout = 3000
+	defaultDBTimeout         = 5000
 )
 
 type Client struct {
 	config       *config.Config
 	authService  *auth.AuthService
 	authHandler  *auth.Handler
+	userRepo     *UserRepository
 	sessionStore store.SessionStore
+	metrics      metrics.Recorder
+	exchanger    *oauth.TokenExchanger
+	userJSON     func(*models.User) any
+	userStatus   auth.UserStatusFunc
+	janitor      *janitor.Janitor
+	userCache    *usercache.Cache
 }
 
 type Handlers struct {
-	SignIn         gin.HandlerFunc
-	SignOut        gin.HandlerFunc
-	Callback       gin.HandlerFunc
-	User           gin.HandlerFunc
-	WebhookSignOut gin.HandlerFunc
+	SignIn             gin.HandlerFunc
+	SignOut            gin.HandlerFunc
+	SignOutCallback    gin.HandlerFunc
+	Callback           gin.HandlerFunc
+	User               gin.HandlerFunc
+	WebhookSignOut     gin.HandlerFunc
+	FrontChannelLogout gin.HandlerFunc
 }
 
 type Middleware struct {
-	RequireAuth gin.HandlerFunc
-	SetUserID   gin.HandlerFunc
-	Session     gin.HandlerFunc
-	SetIsMobile gin.HandlerFunc
+	RequireAuth   gin.HandlerFunc
+	RequireBearer gin.HandlerFunc
+	SetUserID     gin.HandlerFunc
+	Session       gin.HandlerFunc
+	SetIsMobile   gin.HandlerFunc
 }
 
 func New(cfg *config.Config) (*Client, error) {
@@ -41,29 +73,125 @@ func New(cfg *config.Config) (*Client, error) {
 		cfg = config.DefaultConfig()
 	}
 
-	sessionStore, err := store.NewRedisSessionStore(cfg.RedisURI, cfg.SessionKey, cfg.IsRedisSecure, cfg.SessionMaxAge)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := keys.CheckConfig(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.IDTokenDecryptionKey != "" {
+		if _, err := jwe.NewDecrypterFromPEM([]byte(cfg.IDTokenDecryptionKey)); err != nil {
+			return nil, fmt.Errorf("error loading ID token decryption key: %w", err)
+		}
+	}
+
+	if err := models.ConfigureTables(cfg.DBSchema, cfg.DBTableNames); err != nil {
+		return nil, err
+	}
+
+	sessionStore, err := store.NewRedisSessionStore(cfg.RedisURI, cfg.SessionKey, cfg.IsRedisSecure, cfg.SessionMaxAge,
+		redis.DialConnectTimeout(milliseconds(cfg.RedisDialTimeout, defaultRedisDialTimeout)),
+		redis.DialReadTimeout(milliseconds(cfg.RedisReadTimeout, defaultRedisReadTimeout)),
+		redis.DialWriteTimeout(milliseconds(cfg.RedisWriteTimeout, defaultRedisWriteTimeout)),
+	)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.EnableFrontChannelLogout {
+		if redisStore, ok := sessionStore.(*store.RedisSessionStore); ok {
+			redisStore.SetSameSite(http.SameSiteNoneMode)
+		}
+	}
 
 	return &Client{
 		config:       cfg,
 		sessionStore: sessionStore,
+		exchanger:    oauth.NewTokenExchanger(cfg),
 	}, nil
 }
 
 func (c *Client) WithRepository(primaryDB *gorm.DB, secondaryDB *gorm.DB) *Client {
 	userRepo := NewUserRepository(primaryDB, secondaryDB)
+	userRepo.SetTimeout(milliseconds(c.config.DBTimeout, defaultDBTimeout))
+	if c.config.EnableUserCache {
+		if c.userCache == nil {
+			c.userCache = usercache.New(store.PoolOf(c.sessionStore), usercache.Config{
+				Size:     c.config.UserCacheSize,
+				LocalTTL: time.Duration(c.config.UserCacheLocalTTL) * time.Second,
+				TTL:      time.Duration(c.config.UserCacheTTL) * time.Second,
+			})
+			c.userCache.Start()
+		}
+		userRepo.SetCache(c.userCache)
+	}
 
 	handlerConfig := &auth.Config{
-		SignInURL:   c.config.SignInURL,
-		CallbackURL: c.config.CallbackURL,
-		RootURL:     c.config.RootURL,
+		SignInURL:     c.config.SignInURL,
+		CallbackURL:   c.config.CallbackURL,
+		RootURL:       c.config.RootURL,
+		ResponseModes: c.config.CallbackResponseModes,
+
+		EndSessionURL:         c.config.EndSessionURL,
+		PostLogoutRedirectURL: c.config.PostLogoutRedirectURL,
+		ClientID:              c.config.ClientID,
+
+		FrontChannelLogoutOrigin: c.config.FrontChannelLogoutOrigin,
 	}
 
-	c.authService = auth.NewAuthService(userRepo, c.config, c.sessionStore)
+	c.userRepo = userRepo
+	authService, err := auth.NewAuthService(userRepo, c.config, c.sessionStore)
+	if err != nil {
+		// New checks the same settings, so this only fails if the config was
+		// changed after New returned.
+		log.Fatalf("Error creating auth service: %v", err)
+	}
+	c.authService = authService
 	c.authHandler = auth.NewHandler(c.authService, handlerConfig)
+	if c.metrics != nil {
+		c.authService.SetMetricsRecorder(c.metrics)
+		if c.userCache != nil {
+			c.userCache.SetMetricsRecorder(c.metrics)
+		}
+	}
+	if c.userJSON != nil {
+		c.authHandler.SetUserJSON(c.userJSON)
+	}
+	if c.userStatus != nil {
+		c.authService.SetUserStatusFunc(c.userStatus)
+	}
+
+	return c
+}
+
+// WithUserJSON customizes the body of Handlers.User, e.g. to add fields or
+// rename them. By default it is the user's id, email and name.
+func (c *Client) WithUserJSON(render func(*models.User) any) *Client {
+	c.userJSON = render
+	if c.authHandler != nil {
+		c.authHandler.SetUserJSON(render)
+	}
+	return c
+}
 
+// WithUserStatus replaces the models.User lookup behind UserRevalidation, for
+// users kept elsewhere. It is called with the user ID as stored in the session.
+func (c *Client) WithUserStatus(status auth.UserStatusFunc) *Client {
+	c.userStatus = status
+	if c.authService != nil {
+		c.authService.SetUserStatusFunc(status)
+	}
+	return c
+}
+
+// WithMetrics sends the library's counters to recorder instead of expvar.
+func (c *Client) WithMetrics(recorder metrics.Recorder) *Client {
+	c.metrics = recorder
+	if c.authService != nil {
+		c.authService.SetMetricsRecorder(recorder)
+	}
+	if c.userCache != nil {
+		c.userCache.SetMetricsRecorder(recorder)
+	}
 	return c
 }
 
@@ -73,11 +201,13 @@ func (c *Client) GetHandlers() *Handlers {
 	}
 
 	return &Handlers{
-		SignIn:         c.authHandler.SignIn,
-		SignOut:        c.authHandler.SignOut,
-		Callback:       c.authHandler.Callback,
-		User:           c.authHandler.User,
-		WebhookSignOut: c.authHandler.WebhookSignOut,
+		SignIn:             c.authHandler.SignIn,
+		SignOut:            c.authHandler.SignOut,
+		SignOutCallback:    c.authHandler.SignOutCallback,
+		Callback:           c.authHandler.Callback,
+		User:               c.authHandler.User,
+		WebhookSignOut:     c.authHandler.WebhookSignOut,
+		FrontChannelLogout: c.authHandler.FrontChannelLogout,
 	}
 }
 
@@ -85,21 +215,80 @@ func (c *Client) GetMiddleware() *Middleware {
 	sessionMiddleware := middleware.NewSessionMiddleware(c.sessionStore.GetStore(), c.config)
 	authMiddleware := middleware.NewAuthMiddleware(c.sessionStore, c.config.SessionName, c.config.SignInURL)
 
-	return &Middleware{
+	m := &Middleware{
 		RequireAuth: authMiddleware.RequireAuth(),
 		SetUserID:   authMiddleware.SetUserID(),
 		SetIsMobile: authMiddleware.SetIsMobile(),
 		Session:     sessionMiddleware.Handler(),
 	}
+
+	// Bearer tokens, sessions and users are checked and loaded by the
+	// AuthService, which needs the repository. The verifier, checker and loader
+	// look it up on every request, so GetMiddleware may run before
+	// WithRepository.
+	m.RequireBearer = middleware.NewBearerMiddleware(bearerVerifier{c}).RequireBearer()
+	authMiddleware.SetSessionChecker(sessionChecker{c})
+	authMiddleware.SetUserLoader(c.loadUser)
+
+	return m
+}
+
+// loadUser loads users with the client's current AuthService for CurrentUser.
+func (c *Client) loadUser(ctx context.Context, id uint) (*models.User, error) {
+	if c.authService == nil {
+		return nil, errors.New("call WithRepository before loading users")
+	}
+	return c.authService.GetUserByIDContext(ctx, id)
+}
+
+// sessionChecker checks sessions with the client's current AuthService and
+// rejects them until WithRepository sets one.
+type sessionChecker struct {
+	client *Client
+}
+
+func (v sessionChecker) CheckSession(ctx context.Context, session *sessions.Session, userKey string) error {
+	if v.client.authService == nil {
+		return errors.New("call WithRepository before checking sessions")
+	}
+	return v.client.authService.CheckSession(ctx, session, userKey)
+}
+
+// bearerVerifier verifies bearer tokens with the client's current AuthService.
+type bearerVerifier struct {
+	client *Client
+}
+
+func (v bearerVerifier) VerifyAccessTokenContext(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+	if v.client.authService == nil {
+		return nil, errors.New("call WithRepository before verifying bearer tokens")
+	}
+	return v.client.authService.VerifyAccessTokenContext(ctx, accessToken)
 }
 
 func (c *Client) Close() error {
+	if c.janitor != nil {
+		c.janitor.Stop()
+		c.janitor = nil
+	}
+	if c.userCache != nil {
+		c.userCache.Stop()
+		c.userCache = nil
+	}
 	if c.sessionStore != nil {
 		return c.sessionStore.Close()
 	}
 	return nil
 }
 
+// InvalidateSigningKeys drops the cached signing keys so the next callback
+// reloads them, e.g. right after the SSO server has rotated its key.
+func (c *Client) InvalidateSigningKeys() {
+	if c.authService != nil {
+		c.authService.KeyProvider().Invalidate()
+	}
+}
+
 func (c *Client) IsUserSignedIn(r *http.Request) bool {
 	return c.authService.IsUserSignedIn(r)
 }
@@ -108,8 +297,204 @@ func (c *Client) GetUserIDFromSession(r *http.Request) (uint, error) {
 	return c.authService.GetUserIDFromSession(r)
 }
 
+// AccessToken returns the signed-in user's access token for calling APIs on
+// their behalf, refreshing it when it is close to expiry. If the SSO server
+// rejects the refresh the user is signed out and auth.ErrRefreshFailed is
+// returned.
+func (c *Client) AccessToken(r *http.Request) (string, error) {
+	return c.authService.AccessToken(r)
+}
+
+// HTTPClientFor returns a client that calls APIs as the user signed in on r.
+// Each request carries the user's access token and r's trace headers; a 401
+// triggers one token refresh and retry. Use it only while handling r.
+func (c *Client) HTTPClientFor(r *http.Request) *http.Client {
+	return &http.Client{
+		Transport: &oauth.BearerTransport{
+			Token: func(context.Context) (string, error) {
+				return c.authService.AccessToken(r)
+			},
+			Refresh: func(_ context.Context, rejected string) (string, error) {
+				return c.authService.RefreshAccessToken(r, rejected)
+			},
+			Propagate: oauth.TraceHeadersFrom(r),
+		},
+	}
+}
+
+// DeviceLogin signs a user in on a machine without a browser. prompt is called
+// with the code and URL to show the user; the returned tokens' ID token is then
+// resolved to a user exactly like a callback. The credentials are also saved
+// to DeviceCredentialsFile when it is configured.
+func (c *Client) DeviceLogin(ctx context.Context, prompt func(*oauth.DeviceAuthorization)) (*oauth.Credentials, error) {
+	if c.authService == nil {
+		return nil, errors.New("call WithRepository before DeviceLogin")
+	}
+
+	flow, err := oauth.NewDeviceFlow(c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	authorization, err := flow.Start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prompt(authorization)
+
+	token, err := flow.Poll(ctx, authorization)
+	if err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token; request the openid scope")
+	}
+
+	result, err := c.authService.ProcessCallbackContext(ctx, map[string]string{"id_token": token.IDToken})
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := &oauth.Credentials{
+		UserID:       result.UserID,
+		UserKey:      result.UserKey,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		IDToken:      result.IDToken,
+		Expiry:       token.Expiry,
+	}
+	if c.config.DeviceCredentialsFile != "" {
+		if err := oauth.SaveCredentials(c.config.DeviceCredentialsFile, credentials); err != nil {
+			return nil, err
+		}
+	}
+
+	return credentials, nil
+}
+
+// ExchangeToken trades subjectToken for a token that only audience accepts,
+// limited to scopes, so a downstream service can be called on the user's behalf
+// without forwarding their session. Results are cached per subject and audience.
+func (c *Client) ExchangeToken(ctx context.Context, subjectToken, audience string, scopes []string) (*oauth.Token, error) {
+	return c.exchanger.Exchange(ctx, subjectToken, audience, scopes)
+}
+
+// SCIMServer returns a SCIM 2.0 server that provisions users and groups
+// through the repository. Mount it with Register, e.g. on
+// router.Group("/scim/v2").
+func (c *Client) SCIMServer() *scim.Server {
+	if c.authService == nil {
+		log.Fatal("AuthService is nil. Make sure to call WithRepository before SCIMServer")
+	}
+
+	return scim.NewServer(c.userRepo, c.authService, &scim.Config{
+		BaseURL:     c.config.SCIMBaseURL,
+		BearerToken: c.config.SCIMBearerToken,
+		Verifier:    c.authService,
+		Scope:       c.config.SCIMScope,
+	})
+}
+
+// StartJanitor starts deleting used and expired JTIs and retired signing keys
+// in the background, on the schedule set by JanitorInterval. Only one replica
+// does the work per interval. Close stops it.
+func (c *Client) StartJanitor() error {
+	if c.userRepo == nil {
+		return errors.New("call WithRepository before StartJanitor")
+	}
+	if c.janitor != nil {
+		return errors.New("janitor already started")
+	}
+
+	keepKeys := c.config.SigningKeyRetainCount
+	if keepKeys <= 0 {
+		keepKeys = keys.DefaultRetainCount
+	}
+
+	c.janitor = janitor.New(c.userRepo, store.PoolOf(c.sessionStore), janitor.Config{
+		Interval:          time.Duration(c.config.JanitorInterval) * time.Second,
+		BatchSize:         c.config.JanitorBatchSize,
+		TokenRetention:    time.Duration(c.config.AccessTokenRetention) * time.Second,
+		ConsumedRetention: time.Duration(c.config.ConsumedAccessTokenRetention) * time.Second,
+		KeepKeys:          keepKeys,
+		KeyGracePeriod:    time.Duration(c.config.RetiredKeyGracePeriod) * time.Hour,
+	})
+	c.janitor.Start()
+	return nil
+}
+
+// InvalidateCachedUser drops the user from the user cache on every replica.
+// The repository does this itself; call it after changing a user's row by
+// other means.
+func (c *Client) InvalidateCachedUser(userID uint) error {
+	return c.InvalidateCachedUserContext(context.Background(), userID)
+}
+
+func (c *Client) InvalidateCachedUserContext(ctx context.Context, userID uint) error {
+	if c.userCache == nil {
+		return nil
+	}
+	return c.userCache.Invalidate(ctx, userID)
+}
+
+// CheckMigrations returns an error wrapping migrate.ErrPendingMigrations if
+// the primary or secondary database is missing migrations this version of the
+// library expects.
+func (c *Client) CheckMigrations() error {
+	return c.CheckMigrationsContext(context.Background())
+}
+
+func (c *Client) CheckMigrationsContext(ctx context.Context) error {
+	if c.userRepo == nil {
+		return errors.New("call WithRepository before CheckMigrations")
+	}
+	if err := migrate.Check(c.userRepo.primaryDB.WithContext(ctx)); err != nil {
+		return err
+	}
+	// JTIs are read and consumed on the secondary, which needs consumed_at.
+	if c.userRepo.secondaryDB != nil {
+		if err := migrate.Check(c.userRepo.secondaryDB.WithContext(ctx)); err != nil {
+			return fmt.Errorf("secondary database: %w", err)
+		}
+	}
+	return nil
+}
+
+// RevokeUserSessions signs the user out of every session they have.
+func (c *Client) RevokeUserSessions(userID uint) error {
+	return c.RevokeUserSessionsContext(context.Background(), userID)
+}
+
+func (c *Client) RevokeUserSessionsContext(ctx context.Context, userID uint) error {
+	return c.authService.RevokeUserSessionsContext(ctx, userID)
+}
+
+// BumpSessionEpoch invalidates every session the user has, e.g. after their
+// password or roles changed. It needs EnableSessionEpochs.
+func (c *Client) BumpSessionEpoch(userID uint) error {
+	return c.BumpSessionEpochContext(context.Background(), userID)
+}
+
+func (c *Client) BumpSessionEpochContext(ctx context.Context, userID uint) error {
+	return c.authService.BumpSessionEpochContext(ctx, userID)
+}
+
+// BumpGlobalSessionEpoch signs every user out of every session. It needs
+// EnableSessionEpochs.
+func (c *Client) BumpGlobalSessionEpoch() error {
+	return c.BumpGlobalSessionEpochContext(context.Background())
+}
+
+func (c *Client) BumpGlobalSessionEpochContext(ctx context.Context) error {
+	return c.authService.BumpGlobalSessionEpochContext(ctx)
+}
+
 func (c *Client) GetUserByID(id uint) (*models.User, error) {
-	user, err := c.authService.GetUserByID(id)
+	return c.GetUserByIDContext(context.Background(), id)
+}
+
+func (c *Client) GetUserByIDContext(ctx context.Context, id uint) (*models.User, error) {
+	user, err := c.authService.GetUserByIDContext(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -120,3 +505,12 @@ func (c *Client) GetUserByID(id uint) (*models.User, error) {
 		Name:  user.Name,
 	}, nil
 }
+
+// milliseconds converts a config value in milliseconds, using fallback when it
+// is unset.
+func milliseconds(value, fallback int) time.Duration {
+	if value <= 0 {
+		value = fallback
+	}
+	return time.Duration(value) * time.Millisecond
+}
@@ -0,0 +1,223 @@
+package ssoclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/migrate"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+func TestRequireBearerBeforeWithRepository(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+	cfg.IsRedisSecure = false
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	requireBearer := client.GetMiddleware().RequireBearer
+	if requireBearer == nil {
+		t.Fatal("RequireBearer is nil before WithRepository")
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/", requireBearer, func(c *gin.Context) { c.Status(http.StatusOK) })
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestNewRefusesAnotherTableLayout(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+	cfg.IsRedisSecure = false
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	renamed := *cfg
+	renamed.DBTableNames = map[string]string{models.UsersTable: "accounts"}
+	if _, err := New(&renamed); !errors.Is(err, models.ErrTablesConfigured) {
+		t.Errorf("New() with other table names error = %v, want ErrTablesConfigured", err)
+	}
+}
+
+func TestDBTimeout(t *testing.T) {
+	tests := []struct {
+		dbTimeout int
+		want      time.Duration
+	}{
+		{0, defaultDBTimeout * time.Millisecond},
+		{250, 250 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		cfg := config.DefaultConfig()
+		cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+		cfg.IsRedisSecure = false
+		cfg.DBTimeout = tt.dbTimeout
+		client, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		client.WithRepository(newTestDB(t), nil)
+		if client.userRepo.timeout != tt.want {
+			t.Errorf("DBTimeout %d: repository timeout = %v, want %v", tt.dbTimeout, client.userRepo.timeout, tt.want)
+		}
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+	cfg.SessionMaxAge = 60
+
+	var validationErr *config.ValidationError
+	if _, err := New(cfg); !errors.As(err, &validationErr) {
+		t.Errorf("New() error = %v, want a *config.ValidationError", err)
+	}
+}
+
+func TestCheckMigrationsCoversSecondary(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+	cfg.IsRedisSecure = false
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	primary, secondary := newTestDB(t), newTestDB(t)
+	if err := migrate.Up(primary); err != nil {
+		t.Fatal(err)
+	}
+	client.WithRepository(primary, secondary)
+
+	if err := client.CheckMigrations(); !errors.Is(err, migrate.ErrPendingMigrations) {
+		t.Fatalf("CheckMigrations() error = %v, want ErrPendingMigrations for the secondary", err)
+	}
+	if err := migrate.Up(secondary); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CheckMigrations(); err != nil {
+		t.Errorf("CheckMigrations() error = %v", err)
+	}
+}
+
+func TestRequireAuthBeforeWithRepository(t *testing.T) {
+	for _, active := range []bool{true, false} {
+		cfg := config.DefaultConfig()
+		cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+		cfg.IsRedisSecure = false
+		cfg.UserRevalidation = auth.UserRevalidationRequest
+		client, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		// The middleware is taken before the repository is set.
+		requireAuth := client.GetMiddleware().RequireAuth
+		db := newTestDB(t, &models.User{})
+		client.WithRepository(db, nil)
+
+		user := &models.User{Email: "user@example.com"}
+		user.SetActive(active)
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		signIn := httptest.NewRecorder()
+		if err := client.authService.SignInUser(signIn, httptest.NewRequest(http.MethodGet, "/callback", nil), user.ID, false); err != nil {
+			t.Fatal(err)
+		}
+
+		gin.SetMode(gin.TestMode)
+		engine := gin.New()
+		engine.GET("/", requireAuth, func(c *gin.Context) { c.Status(http.StatusOK) })
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, cookie := range signIn.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, r)
+
+		want := http.StatusOK
+		if !active {
+			want = http.StatusUnauthorized
+		}
+		if recorder.Code != want {
+			t.Errorf("active = %v: status = %d, want %d", active, recorder.Code, want)
+		}
+	}
+}
+
+func TestSessionEpochsBeforeWithRepository(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+	cfg.IsRedisSecure = false
+	cfg.EnableSessionEpochs = true
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	// The middleware is taken before the repository is set.
+	requireAuth := client.GetMiddleware().RequireAuth
+	db := newTestDB(t, &models.User{})
+	client.WithRepository(db, nil)
+
+	user := &models.User{Email: "user@example.com"}
+	user.SetActive(true)
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	signIn := httptest.NewRecorder()
+	if err := client.authService.SignInUser(signIn, httptest.NewRequest(http.MethodGet, "/callback", nil), user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/", requireAuth, func(c *gin.Context) { c.Status(http.StatusOK) })
+	get := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, cookie := range signIn.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, r)
+		return recorder.Code
+	}
+
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("status before a bump = %d, want %d", code, http.StatusOK)
+	}
+	if err := client.BumpSessionEpoch(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(); code != http.StatusUnauthorized {
+		t.Errorf("status after a bump = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,197 @@
+package ssoclient
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
+)
+
+// UserStore loads an application's own user type, for apps whose users are
+// not models.User: UUID keys, tenant IDs, extra columns and so on.
+type UserStore[U any, ID comparable] interface {
+	FindUserByID(id ID) (U, error)
+	FindUserIDByJTI(jti string) (ID, error)
+}
+
+// IDCodec converts user IDs to and from the string kept in the session.
+type IDCodec[ID comparable] interface {
+	Encode(id ID) (string, error)
+	Decode(value string) (ID, error)
+}
+
+type UintIDCodec struct{}
+
+func (UintIDCodec) Encode(id uint) (string, error) {
+	return strconv.FormatUint(uint64(id), 10), nil
+}
+
+func (UintIDCodec) Decode(value string) (uint, error) {
+	id, err := strconv.ParseUint(value, 10, 0)
+	return uint(id), err
+}
+
+type StringIDCodec struct{}
+
+func (StringIDCodec) Encode(id string) (string, error) {
+	return id, nil
+}
+
+func (StringIDCodec) Decode(value string) (string, error) {
+	return value, nil
+}
+
+// TextIDCodec handles IDs that marshal to text, such as uuid.UUID:
+// TextIDCodec[uuid.UUID, *uuid.UUID]{}.
+type TextIDCodec[ID interface {
+	comparable
+	encoding.TextMarshaler
+}, PID interface {
+	*ID
+	encoding.TextUnmarshaler
+}] struct{}
+
+func (TextIDCodec[ID, PID]) Encode(id ID) (string, error) {
+	text, err := id.MarshalText()
+	return string(text), err
+}
+
+func (TextIDCodec[ID, PID]) Decode(value string) (ID, error) {
+	var id ID
+	err := PID(&id).UnmarshalText([]byte(value))
+	return id, err
+}
+
+// disabler is implemented by user types that can be deactivated, such as
+// *models.User.
+type disabler interface {
+	Disabled() bool
+}
+
+// TypedClient signs users in as the application's own user type U with
+// primary key type ID. Callbacks resolve users through the store and sessions
+// hold the ID encoded by the codec.
+type TypedClient[U any, ID comparable] struct {
+	client *Client
+	store  UserStore[U, ID]
+	codec  IDCodec[ID]
+}
+
+// NewTypedClient switches client to the given user type. Call it after
+// WithRepository, which still provides the signing keys; the client's uint
+// user helpers no longer apply.
+func NewTypedClient[U any, ID comparable](client *Client, store UserStore[U, ID], codec IDCodec[ID]) *TypedClient[U, ID] {
+	if client.authService == nil {
+		log.Fatal("AuthService is nil. Make sure to call WithRepository before NewTypedClient")
+	}
+
+	client.authService.SetUserIDResolver(func(jti string, _ jwt.MapClaims) (string, error) {
+		id, err := store.FindUserIDByJTI(jti)
+		if err != nil {
+			return "", err
+		}
+		return codec.Encode(id)
+	})
+
+	// UserRevalidation can check users whose type reports its own status.
+	var zero U
+	if _, ok := any(zero).(disabler); ok {
+		client.authService.SetUserStatusFunc(func(userKey string) (bool, error) {
+			id, err := codec.Decode(userKey)
+			if err != nil {
+				return false, fmt.Errorf("error decoding user ID: %w", err)
+			}
+			user, err := store.FindUserByID(id)
+			if err != nil {
+				return false, err
+			}
+			return !any(user).(disabler).Disabled(), nil
+		})
+	}
+
+	return &TypedClient[U, ID]{
+		client: client,
+		store:  store,
+		codec:  codec,
+	}
+}
+
+// UserID returns the ID of the user signed in on r.
+func (t *TypedClient[U, ID]) UserID(r *http.Request) (ID, error) {
+	var zero ID
+
+	key, err := t.client.authService.SessionUserKey(r)
+	if err != nil {
+		return zero, err
+	}
+
+	id, err := t.codec.Decode(key)
+	if err != nil {
+		return zero, fmt.Errorf("error decoding user ID from session: %w", err)
+	}
+	return id, nil
+}
+
+// CurrentUser loads the user signed in on r.
+func (t *TypedClient[U, ID]) CurrentUser(r *http.Request) (U, error) {
+	var zero U
+
+	id, err := t.UserID(r)
+	if err != nil {
+		return zero, err
+	}
+	return t.store.FindUserByID(id)
+}
+
+// UserHandler replaces Handlers.User for typed users, responding with
+// render(user) for the signed-in user.
+func (t *TypedClient[U, ID]) UserHandler(render func(U) any) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := t.CurrentUser(c.Request)
+		if errors.Is(err, auth.ErrNoSessionUser) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not signed in"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user details"})
+			return
+		}
+
+		c.JSON(http.StatusOK, render(user))
+	}
+}
+
+// RevokeUserSessions signs the user out of every session they have.
+func (t *TypedClient[U, ID]) RevokeUserSessions(id ID) error {
+	return t.RevokeUserSessionsContext(context.Background(), id)
+}
+
+func (t *TypedClient[U, ID]) RevokeUserSessionsContext(ctx context.Context, id ID) error {
+	key, err := t.codec.Encode(id)
+	if err != nil {
+		return err
+	}
+	return t.client.authService.RevokeSessionsByKeyContext(ctx, key)
+}
+
+// BumpSessionEpoch invalidates every session the user has.
+func (t *TypedClient[U, ID]) BumpSessionEpoch(id ID) error {
+	return t.BumpSessionEpochContext(context.Background(), id)
+}
+
+func (t *TypedClient[U, ID]) BumpSessionEpochContext(ctx context.Context, id ID) error {
+	key, err := t.codec.Encode(id)
+	if err != nil {
+		return err
+	}
+	return t.client.authService.BumpSessionEpochByKeyContext(ctx, key)
+}
@@ -0,0 +1,287 @@
+package ssoclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/auth"
+	"github.com/jarvisconsulting/sso-client-go/pkg/config"
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+	"github.com/jarvisconsulting/sso-client-go/pkg/oauth"
+)
+
+func TestIDCodecs(t *testing.T) {
+	addr := netip.MustParseAddr("2001:db8::1")
+
+	tests := []struct {
+		name      string
+		roundtrip func() (string, any, any, error)
+	}{
+		{"uint", func() (string, any, any, error) {
+			return roundtrip[uint](UintIDCodec{}, 42)
+		}},
+		{"string", func() (string, any, any, error) {
+			return roundtrip[string](StringIDCodec{}, "7f7c5b1e-uuid")
+		}},
+		{"text", func() (string, any, any, error) {
+			return roundtrip[netip.Addr](TextIDCodec[netip.Addr, *netip.Addr]{}, addr)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, id, decoded, err := tt.roundtrip()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoded == "" || decoded != id {
+				t.Errorf("%v encoded as %q decoded as %v", id, encoded, decoded)
+			}
+		})
+	}
+
+	if _, err := (UintIDCodec{}).Decode("abc"); err == nil {
+		t.Error("UintIDCodec decoded abc")
+	}
+	if _, err := (TextIDCodec[netip.Addr, *netip.Addr]{}).Decode("not an address"); err == nil {
+		t.Error("TextIDCodec decoded an invalid address")
+	}
+}
+
+func roundtrip[ID comparable](codec IDCodec[ID], id ID) (string, any, any, error) {
+	encoded, err := codec.Encode(id)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	decoded, err := codec.Decode(encoded)
+	return encoded, id, decoded, err
+}
+
+// account is an application user keyed by a string ID.
+type account struct {
+	ID       string
+	Tenant   string
+	disabled bool
+}
+
+func (a account) Disabled() bool {
+	return a.disabled
+}
+
+type accountStore map[string]account
+
+func (s accountStore) FindUserByID(id string) (account, error) {
+	user, ok := s[id]
+	if !ok {
+		return account{}, errors.New("account not found")
+	}
+	return user, nil
+}
+
+// FindUserIDByJTI resolves "jti-<id>" to the account <id>.
+func (s accountStore) FindUserIDByJTI(jti string) (string, error) {
+	user, ok := s[strings.TrimPrefix(jti, "jti-")]
+	if !ok {
+		return "", errors.New("unknown JTI")
+	}
+	return user.ID, nil
+}
+
+func newTypedTestClient(t *testing.T, accounts accountStore, configure func(*config.Config)) *TypedClient[account, string] {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+	cfg.IsRedisSecure = false
+	if configure != nil {
+		configure(cfg)
+	}
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.WithRepository(newTestDB(t), nil)
+
+	return NewTypedClient[account, string](client, accounts, StringIDCodec{})
+}
+
+// signInAs stores a session for the encoded user ID and returns its cookies.
+func signInAs(t *testing.T, typed *TypedClient[account, string], userKey string) []*http.Cookie {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	result := &auth.CallbackResult{UserKey: userKey}
+	if err := typed.client.authService.CompleteSignIn(recorder, httptest.NewRequest(http.MethodGet, "/callback", nil), result, false); err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Result().Cookies()
+}
+
+// userRequest builds a fresh request, since sessions are cached per request.
+func userRequest(cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/user", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestTypedClientUserHandler(t *testing.T) {
+	typed := newTypedTestClient(t, accountStore{"a-1": {ID: "a-1", Tenant: "acme"}}, nil)
+	handler := typed.UserHandler(func(user account) any {
+		return gin.H{"id": user.ID, "tenant": user.Tenant}
+	})
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/user", handler)
+
+	tests := []struct {
+		name       string
+		request    *http.Request
+		wantStatus int
+		wantBody   map[string]string
+	}{
+		{"signed in", userRequest(signInAs(t, typed, "a-1")), http.StatusOK, map[string]string{"id": "a-1", "tenant": "acme"}},
+		{"signed out", userRequest(nil), http.StatusUnauthorized, nil},
+		{"unknown user", userRequest(signInAs(t, typed, "a-2")), http.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, tt.request)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantBody == nil {
+				return
+			}
+			var body map[string]string
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["id"] != tt.wantBody["id"] || body["tenant"] != tt.wantBody["tenant"] {
+				t.Errorf("body = %v, want %v", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestTypedClientRevokeUserSessions(t *testing.T) {
+	typed := newTypedTestClient(t, accountStore{"a-1": {ID: "a-1"}}, nil)
+	cookies := signInAs(t, typed, "a-1")
+
+	if id, err := typed.UserID(userRequest(cookies)); err != nil || id != "a-1" {
+		t.Fatalf("UserID() = %q, %v, want a-1", id, err)
+	}
+	if err := typed.RevokeUserSessions("a-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := typed.UserID(userRequest(cookies)); !errors.Is(err, auth.ErrNoSessionUser) {
+		t.Errorf("UserID() after revocation error = %v, want ErrNoSessionUser", err)
+	}
+}
+
+func TestTypedClientChecksUserStatus(t *testing.T) {
+	accounts := accountStore{
+		"active":   {ID: "active"},
+		"disabled": {ID: "disabled", disabled: true},
+	}
+	typed := newTypedTestClient(t, accounts, func(cfg *config.Config) {
+		cfg.UserRevalidation = auth.UserRevalidationRequest
+	})
+
+	tests := []struct {
+		userKey string
+		want    error
+	}{
+		{"active", nil},
+		{"disabled", auth.ErrUserDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userKey, func(t *testing.T) {
+			if err := typed.client.authService.CheckSessionUser(tt.userKey); !errors.Is(err, tt.want) {
+				t.Errorf("CheckSessionUser() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypedClientDeviceLogin(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"jti": "jti-a-1",
+		"sub": "a-1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := http.NewServeMux()
+	provider.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oauth.DeviceAuthorization{DeviceCode: "device", UserCode: "ABCD", VerificationURI: "https://sso.example.com/device", ExpiresIn: 60, Interval: 1})
+	})
+	provider.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oauth.Token{AccessToken: "access", TokenType: "Bearer", IDToken: idToken, ExpiresIn: 3600})
+	})
+	server := httptest.NewServer(provider)
+	t.Cleanup(server.Close)
+
+	cfg := config.DefaultConfig()
+	cfg.RedisURI = "redis://" + miniredis.RunT(t).Addr()
+	cfg.IsRedisSecure = false
+	cfg.ClientID = "cli"
+	cfg.DeviceAuthorizationURL = server.URL + "/device"
+	cfg.TokenURL = server.URL + "/token"
+	cfg.DeviceCredentialsFile = filepath.Join(t.TempDir(), "credentials.json")
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	db := newTestDB(t, &models.SshKey{})
+	if err := db.Create(&models.SshKey{PrivateRsaKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))}).Error; err != nil {
+		t.Fatal(err)
+	}
+	client.WithRepository(db, db)
+	NewTypedClient[account, string](client, accountStore{"a-1": {ID: "a-1"}}, StringIDCodec{})
+
+	credentials, err := client.DeviceLogin(context.Background(), func(*oauth.DeviceAuthorization) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.UserKey != "a-1" || credentials.UserID != 0 {
+		t.Errorf("DeviceLogin() user = %d, %q, want key a-1", credentials.UserID, credentials.UserKey)
+	}
+	saved, err := oauth.LoadCredentials(cfg.DeviceCredentialsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.UserKey != "a-1" {
+		t.Errorf("saved user key = %q, want a-1", saved.UserKey)
+	}
+}
@@ -0,0 +1,67 @@
+package ssoclient
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/jarvisconsulting/sso-client-go/pkg/models"
+)
+
+// optionalUserColumns were added to users by migrations 0003 (SCIM) and 0005
+// (user status). Tables created before them keep working: writes leave out
+// the columns a table lacks, and a user read without active has a nil Active,
+// which counts as active.
+var optionalUserColumns = []string{"external_id", "active", "locked_at", "deleted_at"}
+
+// userColumns remembers which optional columns each database's users table
+// lacks. It is checked once per database, so a table migrated while the
+// process runs is only seen after a restart.
+type userColumns struct {
+	mu      sync.Mutex
+	missing map[*sql.DB][]string
+}
+
+// missingFrom returns the optional columns db's users table lacks. If they
+// cannot be listed, nothing is cached and the caller's query reports the
+// problem.
+func (c *userColumns) missingFrom(db *gorm.DB) []string {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if missing, ok := c.missing[sqlDB]; ok {
+		return missing
+	}
+
+	columnTypes, err := db.Migrator().ColumnTypes(&models.User{})
+	if err != nil || len(columnTypes) == 0 {
+		return nil
+	}
+	present := make(map[string]bool, len(columnTypes))
+	for _, column := range columnTypes {
+		present[column.Name()] = true
+	}
+
+	missing := []string{}
+	for _, column := range optionalUserColumns {
+		if !present[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		log.Printf("Users table lacks %v; run the migrations to enable them", missing)
+	}
+
+	if c.missing == nil {
+		c.missing = map[*sql.DB][]string{}
+	}
+	c.missing[sqlDB] = missing
+	return missing
+}